@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"sort"
+	"time"
+)
+
+// alarmLevel is one of the limits of a metric, exceeded when a value goes above it if High,
+// below it otherwise.
+type alarmLevel struct {
+	Name  string
+	Limit float64
+	High  bool
+}
+
+func (l alarmLevel) exceeded(value float64) bool {
+	if l.High {
+		return value > l.Limit
+	}
+	return value < l.Limit
+}
+
+// cleared reports whether value is back inside the limit by at least deadband.
+func (l alarmLevel) cleared(value float64, deadband float64) bool {
+	if l.High {
+		return value <= l.Limit-deadband
+	}
+	return value >= l.Limit+deadband
+}
+
+type alarm struct {
+	MetricId  int64
+	Level     string
+	Limit     float64
+	Activated time.Time
+	// Cleared is nil while the alarm is active.
+	Cleared *time.Time
+	// Peak is the furthest value from the limit while the alarm was active.
+	Peak float64
+	// Value is the last value while the alarm was active.
+	Value float64
+}
+
+// metricAlarm is an alarm along with the metric and device that raised it.
+type metricAlarm struct {
+	alarm
+	Device database.Device
+	Metric database.Metric
+}
+
+func alarmLevels(limits database.MetricLimits) []alarmLevel {
+	levels := make([]alarmLevel, 0, 4)
+	if limits.HighHigh != nil {
+		levels = append(levels, alarmLevel{Name: "high-high", Limit: *limits.HighHigh, High: true})
+	}
+	if limits.High != nil {
+		levels = append(levels, alarmLevel{Name: "high", Limit: *limits.High, High: true})
+	}
+	if limits.Low != nil {
+		levels = append(levels, alarmLevel{Name: "low", Limit: *limits.Low, High: false})
+	}
+	if limits.LowLow != nil {
+		levels = append(levels, alarmLevel{Name: "low-low", Limit: *limits.LowLow, High: false})
+	}
+	return levels
+}
+
+// evaluateAlarms replays values, sorted by times, against limits and returns every alarm raised,
+// ordered by activation time. An alarm is raised once its limit has been continuously exceeded for
+// limits.Delay seconds and cleared once the value is back inside the limit by limits.Deadband.
+func evaluateAlarms(times []time.Time, values []float64, limits database.MetricLimits) []alarm {
+	alarms := make([]alarm, 0)
+	delay := time.Duration(limits.Delay) * time.Second
+
+	for _, level := range alarmLevels(limits) {
+		var active *alarm
+		var pendingSince *time.Time
+
+		for i, value := range values {
+			if active != nil {
+				if level.cleared(value, limits.Deadband) {
+					cleared := times[i]
+					active.Cleared = &cleared
+					alarms = append(alarms, *active)
+					active = nil
+					continue
+				}
+				active.Value = value
+				if level.High && value > active.Peak || !level.High && value < active.Peak {
+					active.Peak = value
+				}
+				continue
+			}
+
+			if !level.exceeded(value) {
+				pendingSince = nil
+				continue
+			}
+			if pendingSince == nil {
+				since := times[i]
+				pendingSince = &since
+			}
+			if times[i].Sub(*pendingSince) >= delay {
+				active = &alarm{
+					MetricId:  limits.MetricId,
+					Level:     level.Name,
+					Limit:     level.Limit,
+					Activated: times[i],
+					Peak:      value,
+					Value:     value,
+				}
+				pendingSince = nil
+			}
+		}
+
+		if active != nil {
+			alarms = append(alarms, *active)
+		}
+	}
+
+	sort.SliceStable(alarms, func(i, j int) bool {
+		return alarms[i].Activated.Before(alarms[j].Activated)
+	})
+	return alarms
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+func TestEvaluateAlarms(t *testing.T) {
+	high := 100.0
+	lowLow := 0.0
+	limits := database.MetricLimits{
+		MetricId: 10,
+		High:     &high,
+		LowLow:   &lowLow,
+		Deadband: 5,
+		Delay:    20,
+	}
+
+	// 10s apart: a short spike ignored thanks to the delay, a sustained excursion cleared only
+	// once back below 95, and a low-low alarm still active at the end.
+	metric := testMetric(10, "power", 90, 120, 90, 110, 105, 130, 97, 94, 50, -1, -2, -3)
+	alarms := evaluateAlarms(metric.Times, metric.Values, limits)
+
+	if len(alarms) != 2 {
+		t.Fatalf("Expected 2 alarms, got %d: %+v", len(alarms), alarms)
+	}
+
+	start := metric.Times[0]
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * 10 * time.Second) }
+
+	first := alarms[0]
+	if first.Level != "high" || !first.Activated.Equal(at(5)) || first.Cleared == nil || !first.Cleared.Equal(at(7)) {
+		t.Errorf("Unexpected high alarm: %+v", first)
+	}
+	if first.Peak != 130 {
+		t.Errorf("Expected a peak of 130, got %f", first.Peak)
+	}
+
+	second := alarms[1]
+	if second.Level != "low-low" || !second.Activated.Equal(at(11)) || second.Cleared != nil {
+		t.Errorf("Unexpected low-low alarm: %+v", second)
+	}
+}
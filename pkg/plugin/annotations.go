@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type annotation struct {
+	Time    time.Time
+	TimeEnd time.Time
+	Title   string
+	Text    string
+	Tags    []string
+}
+
+// metricThreshold is a limit on the value of a metric, crossed when the value goes Above
+// (or below, if false) Value.
+type metricThreshold struct {
+	MetricId int64   `json:"metricId"`
+	Above    bool    `json:"above"`
+	Value    float64 `json:"value"`
+}
+
+func (t metricThreshold) String() string {
+	direction := "below"
+	if t.Above {
+		direction = "above"
+	}
+	return direction + " " + strconv.FormatFloat(t.Value, 'f', -1, 64)
+}
+
+// parseThresholds parses a comma separated list of thresholds written as
+// <metric id><operator><value>, where operator is either '>' or '<' (e.g. "12>50,13<5").
+func parseThresholds(csv string) ([]metricThreshold, error) {
+	thresholds := make([]metricThreshold, 0)
+	for _, entry := range strings.Split(csv, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		idx := strings.IndexAny(entry, "<>")
+		if idx <= 0 {
+			return nil, errors.New("invalid threshold '" + entry + "'")
+		}
+		metricId, err := strconv.ParseInt(strings.TrimSpace(entry[:idx]), 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid threshold '" + entry + "'")
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(entry[idx+1:]), 64)
+		if err != nil {
+			return nil, errors.New("invalid threshold '" + entry + "'")
+		}
+
+		thresholds = append(thresholds, metricThreshold{
+			MetricId: metricId,
+			Above:    entry[idx] == '>',
+			Value:    value,
+		})
+	}
+	return thresholds, nil
+}
+
+func gapAnnotations(device database.Device, outages []series.Interval) []annotation {
+	annotations := make([]annotation, 0, len(outages))
+	for _, outage := range outages {
+		annotations = append(annotations, annotation{
+			Time:    outage.From,
+			TimeEnd: outage.To,
+			Title:   device.Name + " offline",
+			Text:    "No data received for " + outage.Duration().Round(time.Second).String(),
+			Tags:    []string{"outage", device.Name},
+		})
+	}
+	return annotations
+}
+
+func thresholdAnnotations(device database.Device, metric *database.MetricWithData, threshold metricThreshold) []annotation {
+	excursions := series.Excursions(metric.Times, metric.Values, func(value float64) bool {
+		if threshold.Above {
+			return value > threshold.Value
+		}
+		return value < threshold.Value
+	})
+
+	annotations := make([]annotation, 0, len(excursions))
+	for _, excursion := range excursions {
+		peak := peakValue(metric.Times, metric.Values, excursion, threshold.Above)
+		annotations = append(annotations, annotation{
+			Time:    excursion.From,
+			TimeEnd: excursion.To,
+			Title:   device.Name + " - " + metric.Metric.Name + " " + threshold.String(),
+			Text:    fmt.Sprintf("Peak value %g %s", peak, metric.Metric.Unit),
+			Tags:    []string{"threshold", device.Name, metric.Metric.Name},
+		})
+	}
+	return annotations
+}
+
+// peakValue returns the highest (or lowest if not above) of values within interval.
+func peakValue(times []time.Time, values []float64, interval series.Interval, above bool) float64 {
+	var peak float64
+	found := false
+	for i, value := range values {
+		if times[i].Before(interval.From) || times[i].After(interval.To) {
+			continue
+		}
+		if !found || above && value > peak || !above && value < peak {
+			peak = value
+			found = true
+		}
+	}
+	return peak
+}
+
+func sortAnnotations(annotations []annotation) {
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].Time.Before(annotations[j].Time)
+	})
+}
@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+// assetNode is a node of the plant hierarchy as returned by the Assets entity: an asset, a device
+// or a metric. Ids are prefixed by the kind of node, such as "asset:1", so that they are distinct.
+type assetNode struct {
+	Id     string
+	Parent string
+	Name   string
+	// Kind is the kind of an asset, such as site or area, or device or metric.
+	Kind  string
+	Path  string
+	Depth int64
+}
+
+func assetNodeId(entity string, id int64) string {
+	return entity + ":" + strconv.FormatInt(id, 10)
+}
+
+// assetTree returns the nodes under the asset root, or the whole hierarchy if root is 0, depth
+// first so that parents come before their children. The devices outside any asset are roots of
+// the whole hierarchy, and so are the assets cut from it by parents edited by hand in the
+// database, missing or forming a cycle, so that they can still be fixed.
+func assetTree(assets []database.Asset, devices []database.Device, metrics []database.Metric, root int64) ([]assetNode, error) {
+	names := make(map[int64]string, len(devices))
+	for _, device := range devices {
+		names[device.Id] = device.Name
+	}
+
+	children := make(map[string][]assetNode)
+	assetNodes := make([]assetNode, 0, len(assets))
+	found := root == 0
+	assigned := make(map[int64]bool)
+	for _, asset := range assets {
+		node := assetNode{Id: assetNodeId("asset", asset.Id), Name: asset.Name, Kind: asset.Kind}
+		if asset.ParentId != 0 {
+			node.Parent = assetNodeId("asset", asset.ParentId)
+		}
+		if asset.Id == root {
+			node.Parent = ""
+			found = true
+		}
+		children[node.Parent] = append(children[node.Parent], node)
+		assetNodes = append(assetNodes, node)
+		for _, deviceId := range asset.Devices {
+			assigned[deviceId] = true
+			children[node.Id] = append(children[node.Id], assetNode{Id: assetNodeId("device", deviceId), Parent: node.Id, Name: names[deviceId], Kind: "device"})
+		}
+	}
+	if !found {
+		return nil, &database.Error{Kind: database.ErrorKindInvalidFilter, Message: "asset " + strconv.FormatInt(root, 10) + " does not exist"}
+	}
+
+	for _, device := range devices {
+		if !assigned[device.Id] {
+			children[""] = append(children[""], assetNode{Id: assetNodeId("device", device.Id), Name: device.Name, Kind: "device"})
+		}
+	}
+	for _, metric := range metrics {
+		device := assetNodeId("device", metric.DeviceId)
+		children[device] = append(children[device], assetNode{Id: assetNodeId("metric", metric.Id), Parent: device, Name: metric.Name, Kind: "metric"})
+	}
+
+	roots := children[""]
+	if root != 0 {
+		roots = []assetNode{}
+		for _, node := range children[""] {
+			if node.Id == assetNodeId("asset", root) {
+				roots = append(roots, node)
+			}
+		}
+	}
+
+	nodes := make([]assetNode, 0)
+	visited := make(map[string]bool)
+	var walk func(node assetNode, path string, depth int64)
+	walk = func(node assetNode, path string, depth int64) {
+		// parents edited by hand in the database may form a cycle.
+		if visited[node.Id] {
+			return
+		}
+		visited[node.Id] = true
+
+		node.Path = strings.TrimPrefix(path+" / "+node.Name, " / ")
+		node.Depth = depth
+		nodes = append(nodes, node)
+		for _, child := range children[node.Id] {
+			walk(child, node.Path, depth+1)
+		}
+	}
+	for _, node := range roots {
+		walk(node, "", 0)
+	}
+	if root == 0 {
+		for _, node := range assetNodes {
+			if !visited[node.Id] {
+				node.Parent = ""
+				walk(node, "", 0)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// assetTreeToFrame returns nodes as a table whose rows are the nodes in tree order, their path and
+// depth telling how they nest.
+func assetTreeToFrame(nodes []assetNode) *data.Frame {
+	frame := data.NewFrame("assets")
+
+	ids := make([]string, len(nodes))
+	parents := make([]string, len(nodes))
+	names := make([]string, len(nodes))
+	kinds := make([]string, len(nodes))
+	paths := make([]string, len(nodes))
+	depths := make([]int64, len(nodes))
+	for i, node := range nodes {
+		ids[i], parents[i], names[i], kinds[i], paths[i], depths[i] = node.Id, node.Parent, node.Name, node.Kind, node.Path, node.Depth
+	}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("id", nil, ids),
+		data.NewField("parent", nil, parents),
+		data.NewField("name", nil, names),
+		data.NewField("kind", nil, kinds),
+		data.NewField("path", nil, paths),
+		data.NewField("depth", nil, depths),
+	)
+
+	return frame
+}
+
+// assetTreeToNodeGraph returns nodes as the nodes and edges frames of the node graph panel.
+func assetTreeToNodeGraph(nodes []assetNode) []*data.Frame {
+	ids := make([]string, len(nodes))
+	titles := make([]string, len(nodes))
+	subtitles := make([]string, len(nodes))
+	edgeIds := make([]string, 0, len(nodes))
+	sources := make([]string, 0, len(nodes))
+	targets := make([]string, 0, len(nodes))
+	for i, node := range nodes {
+		ids[i], titles[i], subtitles[i] = node.Id, node.Name, node.Kind
+		if node.Parent != "" {
+			edgeIds = append(edgeIds, node.Parent+"-"+node.Id)
+			sources = append(sources, node.Parent)
+			targets = append(targets, node.Id)
+		}
+	}
+
+	meta := &data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph}
+	return []*data.Frame{
+		data.NewFrame("nodes",
+			data.NewField("id", nil, ids),
+			data.NewField("title", nil, titles),
+			data.NewField("subtitle", nil, subtitles),
+		).SetMeta(meta),
+		data.NewFrame("edges",
+			data.NewField("id", nil, edgeIds),
+			data.NewField("source", nil, sources),
+			data.NewField("target", nil, targets),
+		).SetMeta(meta),
+	}
+}
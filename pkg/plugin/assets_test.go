@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+func TestAssetTree(t *testing.T) {
+	assets := []database.Asset{
+		{Id: 1, Name: "plantA", Kind: "site"},
+		{Id: 2, ParentId: 1, Name: "hall1", Kind: "area", Devices: []int64{1}},
+	}
+	devices := []database.Device{{Id: 1, Name: "inverter"}, {Id: 2, Name: "meter"}}
+	metrics := []database.Metric{{Id: 10, DeviceId: 1, Name: "power"}, {Id: 20, DeviceId: 2, Name: "energy"}}
+
+	nodes, err := assetTree(assets, devices, metrics, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(nodes))
+	for i, node := range nodes {
+		paths[i] = node.Path
+	}
+	expected := []string{"plantA", "plantA / hall1", "plantA / hall1 / inverter", "plantA / hall1 / inverter / power", "meter", "meter / energy"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected paths %q, got %q", expected, paths)
+	}
+
+	nodes, err = assetTree(assets, devices, metrics, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 || nodes[0].Id != "asset:2" || nodes[0].Parent != "" || nodes[2].Depth != 2 {
+		t.Errorf("Unexpected subtree %+v", nodes)
+	}
+
+	if _, err := assetTree(assets, devices, metrics, 9); !errors.Is(err, database.ErrInvalidFilter) {
+		t.Errorf("Expected an invalid filter for an unknown root, got %v", err)
+	}
+}
+
+func TestAssetTreeUnreachable(t *testing.T) {
+	// assets 2 and 3 are each other's parent, asset 4 is under an asset that does not exist.
+	assets := []database.Asset{
+		{Id: 1, Name: "plantA", Kind: "site"},
+		{Id: 2, ParentId: 3, Name: "hall1", Kind: "area", Devices: []int64{1}},
+		{Id: 3, ParentId: 2, Name: "hall2", Kind: "area"},
+		{Id: 4, ParentId: 9, Name: "hall3", Kind: "area"},
+	}
+	devices := []database.Device{{Id: 1, Name: "inverter"}}
+
+	nodes, err := assetTree(assets, devices, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(nodes))
+	for i, node := range nodes {
+		paths[i] = node.Path
+	}
+	expected := []string{"plantA", "hall1", "hall1 / inverter", "hall1 / hall2", "hall3"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected paths %q, got %q", expected, paths)
+	}
+	if nodes[1].Parent != "" || nodes[4].Parent != "" {
+		t.Errorf("Expected the unreachable assets to be roots, got %+v", nodes)
+	}
+}
+
+func TestAssetTreeToNodeGraph(t *testing.T) {
+	nodes := []assetNode{
+		{Id: "asset:1", Name: "plantA", Kind: "site"},
+		{Id: "device:1", Parent: "asset:1", Name: "inverter", Kind: "device"},
+	}
+
+	frames := assetTreeToNodeGraph(nodes)
+	if len(frames) != 2 || frames[0].Name != "nodes" || frames[1].Name != "edges" {
+		t.Fatalf("Expected the nodes and edges frames, got %v", frames)
+	}
+	if frames[0].Rows() != 2 || frames[1].Rows() != 1 {
+		t.Errorf("Expected 2 nodes and 1 edge, got %d and %d", frames[0].Rows(), frames[1].Rows())
+	}
+	if source, target := frames[1].Fields[1].At(0), frames[1].Fields[2].At(0); source != "asset:1" || target != "device:1" {
+		t.Errorf("Unexpected edge from %v to %v", source, target)
+	}
+}
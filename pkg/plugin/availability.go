@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+	"sort"
+	"time"
+)
+
+// defaultGapThreshold is used for devices whose metrics do not have a refresh rate.
+const defaultGapThreshold = time.Minute
+
+type deviceAvailability struct {
+	Device  database.Device
+	From    time.Time
+	To      time.Time
+	Outages []series.Interval
+}
+
+// Uptime returns the fraction, in percent, of the time range in which the device was online.
+func (a deviceAvailability) Uptime() float64 {
+	total := a.To.Sub(a.From)
+	if total <= 0 {
+		return 100
+	}
+	return 100 * (1 - a.Downtime().Seconds()/total.Seconds())
+}
+
+func (a deviceAvailability) Downtime() time.Duration {
+	var downtime time.Duration
+	for _, outage := range a.Outages {
+		downtime += outage.Duration()
+	}
+	return downtime
+}
+
+func (a deviceAvailability) LongestOutage() time.Duration {
+	var longest time.Duration
+	for _, outage := range a.Outages {
+		if outage.Duration() > longest {
+			longest = outage.Duration()
+		}
+	}
+	return longest
+}
+
+// deviceTimestamps returns the sorted timestamps at which any metric of device was collected.
+func deviceTimestamps(device database.DeviceWithMetrics) []time.Time {
+	times := make([]time.Time, 0)
+	for _, metric := range device.Metrics {
+		times = append(times, metric.Times...)
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	return times
+}
+
+// deviceGapThreshold returns threshold if set, otherwise twice the fastest refresh rate
+// of the metrics of device.
+func deviceGapThreshold(device database.DeviceWithMetrics, threshold time.Duration) time.Duration {
+	if threshold > 0 {
+		return threshold
+	}
+
+	var fastest int32
+	for _, metric := range device.Metrics {
+		if rate := metric.Metric.RefreshRate; rate > 0 && (fastest == 0 || rate < fastest) {
+			fastest = rate
+		}
+	}
+	if fastest == 0 {
+		return defaultGapThreshold
+	}
+	return 2 * time.Duration(fastest) * time.Second
+}
@@ -0,0 +1,353 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+const (
+	// defaultCacheBucket is the granularity of cached ranges. Data older than the start of the
+	// previous bucket is considered final and is never queried again while cached.
+	defaultCacheBucket = time.Minute
+	defaultCacheTTL    = 5 * time.Minute
+	// defaultCacheMaxPoints bounds the number of data points held by the cache.
+	defaultCacheMaxPoints = 1000000
+)
+
+// metricsDataFetcher queries the data of the metrics matching filter over timeRange, bounds excluded.
+type metricsDataFetcher func(ctx context.Context, filter *database.Filter, timeRange backend.TimeRange) ([]database.DeviceWithMetrics, error)
+
+// metricsLookup returns the metrics matching filter, as returned by a metricsDataFetcher but
+// without data.
+type metricsLookup func(ctx context.Context, filter *database.Filter) ([]database.DeviceWithMetrics, error)
+
+// metricsDataCache keeps the final part of the data of recent MetricsData queries, so that
+// refreshing dashboards only query the data written since. Entries are keyed by normalized filter,
+// and cover a bucket-aligned range up to the last final bucket. They only hold the points of the
+// metrics, whose scaling, mappings and tags are always the current ones.
+type metricsDataCache struct {
+	Bucket    time.Duration
+	TTL       time.Duration
+	MaxPoints int
+
+	// now is time.Now, unless tested.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, most recently used first.
+	lru    *list.List
+	points int
+}
+
+type cacheEntry struct {
+	key     string
+	created time.Time
+	// data holds the points in (from, sealed) of each metric that matched the filter, by id.
+	from   time.Time
+	sealed time.Time
+	data   map[int64]cachedPoints
+	points int
+}
+
+// cachedPoints are the points of a metric, sorted by time.
+type cachedPoints struct {
+	times  []time.Time
+	values []float64
+}
+
+func newMetricsDataCache() *metricsDataCache {
+	return &metricsDataCache{
+		Bucket:    defaultCacheBucket,
+		TTL:       defaultCacheTTL,
+		MaxPoints: defaultCacheMaxPoints,
+		now:       time.Now,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// cacheKey normalizes filter, so that the same metrics listed in another order share an entry.
+func cacheKey(filter *database.Filter) string {
+	key := "all"
+	if filter.Entity == "devices" || filter.Entity == "metrics" || filter.Entity == "assets" {
+		seen := make(map[string]bool)
+		ids := make([]string, 0)
+		for _, id := range strings.Split(filter.Value, ",") {
+			if id = strings.TrimSpace(id); id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		key = filter.Entity + ":" + strings.Join(ids, ",")
+	} else if filter.Entity == "tags" {
+		tags := make([]string, 0, len(filter.Tags))
+		for k, v := range filter.Tags {
+			tags = append(tags, strconv.Quote(k)+"="+strconv.Quote(v))
+		}
+		sort.Strings(tags)
+		key = "tags:" + strings.Join(tags, ",")
+	}
+
+	adhoc := make([]string, len(filter.Adhoc))
+	for i, f := range filter.Adhoc {
+		adhoc[i] = strconv.Quote(f.Key) + f.Operator + strconv.Quote(f.Value)
+	}
+	sort.Strings(adhoc)
+	for _, f := range adhoc {
+		key += "&" + f
+	}
+	return key
+}
+
+// Query returns the data of the metrics matching filter over timeRange, fetching only what is
+// not cached, and looking up the metrics of fully cached ranges. The returned data belongs to the
+// caller.
+func (c *metricsDataCache) Query(ctx context.Context, filter *database.Filter, timeRange backend.TimeRange, fetch metricsDataFetcher, lookup metricsLookup) ([]database.DeviceWithMetrics, error) {
+	key := cacheKey(filter)
+	now := c.now()
+	sealed := now.Add(-c.Bucket).Truncate(c.Bucket)
+	if timeRange.To.Before(sealed) {
+		sealed = timeRange.To
+	}
+
+	entry := c.get(key, now)
+	if entry != nil && !timeRange.From.Before(entry.from) && !timeRange.To.After(entry.sealed) {
+		devices, err := lookup(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if entry.holds(devices) {
+			return sliceDevices(devices, entry.data, timeRange), nil
+		}
+		// metrics matching the filter since the entry was cached have no data in it.
+		entry = nil
+	}
+	if entry == nil || timeRange.From.Before(entry.from) {
+		// fetch whole buckets, so that later queries on a slightly moved range hit.
+		from := timeRange.From.Truncate(c.Bucket)
+		devices, err := fetch(ctx, filter, backend.TimeRange{From: from, To: timeRange.To})
+		if err != nil {
+			return nil, err
+		}
+		// truncated data is not final, it may be queried again over a narrower range.
+		if sealed.After(from) && !truncated(devices) {
+			c.put(newCacheEntry(key, now, from, sealed, devices))
+		}
+		return sliceDevices(devices, nil, timeRange), nil
+	}
+
+	// a point at the end of the cached range may be in the same second as the range bound.
+	tail, err := fetch(ctx, filter, backend.TimeRange{From: entry.sealed.Add(-time.Second), To: timeRange.To})
+	if err != nil {
+		return nil, err
+	}
+	for _, device := range tail {
+		for _, metric := range device.Metrics {
+			metric.Times, metric.Values = pointsIn(metric.Times, metric.Values, entry.sealed.Add(-time.Nanosecond), timeRange.To)
+		}
+	}
+
+	if sealed.After(entry.sealed) && !truncated(tail) {
+		c.extend(entry, sealed, tail)
+	}
+	return sliceDevices(tail, entry.data, timeRange), nil
+}
+
+// Clear drops all entries.
+func (c *metricsDataCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.points = 0
+}
+
+// get returns the live entry of key, or nil.
+func (c *metricsDataCache) get(key string, now time.Time) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if now.Sub(entry.created) > c.TTL {
+		c.remove(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+func (c *metricsDataCache) put(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.remove(elem)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.points += entry.points
+	c.evict()
+}
+
+// extend appends the points of devices before sealed to entry, unless it was replaced meanwhile.
+func (c *metricsDataCache) extend(entry *cacheEntry, sealed time.Time, devices []database.DeviceWithMetrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[entry.key]
+	if !ok || elem.Value != entry || !sealed.After(entry.sealed) {
+		return
+	}
+
+	// entries are shared by concurrent queries, never modify their data in place.
+	data := make(map[int64]cachedPoints, len(entry.data))
+	for _, device := range devices {
+		for _, metric := range device.Metrics {
+			cached := entry.data[metric.Metric.Id]
+			times, values := pointsIn(metric.Times, metric.Values, time.Time{}, sealed)
+			data[metric.Metric.Id] = cachedPoints{
+				times:  append(append(make([]time.Time, 0, len(cached.times)+len(times)), cached.times...), times...),
+				values: append(append(make([]float64, 0, len(cached.values)+len(values)), cached.values...), values...),
+			}
+		}
+	}
+
+	replaced := *entry
+	replaced.sealed = sealed
+	replaced.data = data
+	replaced.points = 0
+	for _, points := range data {
+		replaced.points += len(points.values)
+	}
+	c.points += replaced.points - entry.points
+	elem.Value = &replaced
+	c.evict()
+}
+
+// evict drops the least recently used entries until the cache holds at most MaxPoints.
+func (c *metricsDataCache) evict() {
+	for c.points > c.MaxPoints && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *metricsDataCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.points -= entry.points
+}
+
+func newCacheEntry(key string, now time.Time, from time.Time, sealed time.Time, devices []database.DeviceWithMetrics) *cacheEntry {
+	entry := &cacheEntry{
+		key:     key,
+		created: now,
+		from:    from,
+		sealed:  sealed,
+		data:    make(map[int64]cachedPoints),
+	}
+	for _, device := range devices {
+		for _, metric := range device.Metrics {
+			// the caller owns the vectors of devices and may modify them.
+			times, values := pointsIn(metric.Times, metric.Values, time.Time{}, sealed)
+			entry.data[metric.Metric.Id] = cachedPoints{
+				times:  append([]time.Time(nil), times...),
+				values: append([]float64(nil), values...),
+			}
+			entry.points += len(values)
+		}
+	}
+	return entry
+}
+
+// holds reports whether e has the points of all the metrics of devices.
+func (e *cacheEntry) holds(devices []database.DeviceWithMetrics) bool {
+	for _, device := range devices {
+		for _, metric := range device.Metrics {
+			if _, ok := e.data[metric.Metric.Id]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pointsIn returns the points of times and values strictly within (from, to). Since times are
+// sorted, these are subslices of both.
+func pointsIn(times []time.Time, values []float64, from time.Time, to time.Time) ([]time.Time, []float64) {
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(from) })
+	j := sort.Search(len(times), func(j int) bool { return !times[j].Before(to) })
+	if j < i {
+		j = i
+	}
+	return times[i:j], values[i:j]
+}
+
+// truncated reports whether the data of any metric of devices was truncated.
+func truncated(devices []database.DeviceWithMetrics) bool {
+	for _, device := range devices {
+		for _, metric := range device.Metrics {
+			if metric.Truncated {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sliceDevices returns copies of devices, whose metrics hold their cached points followed by
+// their own, within timeRange.
+func sliceDevices(devices []database.DeviceWithMetrics, cached map[int64]cachedPoints, timeRange backend.TimeRange) []database.DeviceWithMetrics {
+	sliced := make([]database.DeviceWithMetrics, len(devices))
+	for i, device := range devices {
+		sliced[i] = database.DeviceWithMetrics{
+			Device:  device.Device,
+			Metrics: make([]*database.MetricWithData, len(device.Metrics)),
+		}
+		for j, metric := range device.Metrics {
+			points := cached[metric.Metric.Id]
+			cachedTimes, cachedValues := pointsIn(points.times, points.values, timeRange.From, timeRange.To)
+			times, values := pointsIn(metric.Times, metric.Values, timeRange.From, timeRange.To)
+
+			copied := &database.MetricWithData{
+				Metric:    metric.Metric,
+				Times:     make([]time.Time, 0, len(cachedTimes)+len(times)),
+				Values:    make([]float64, 0, len(cachedValues)+len(values)),
+				Truncated: metric.Truncated,
+			}
+			copied.Times = append(append(copied.Times, cachedTimes...), times...)
+			copied.Values = append(append(copied.Values, cachedValues...), values...)
+			sliced[i].Metrics[j] = copied
+		}
+	}
+	return sliced
+}
+
+// queryMetricsData returns the data of the metrics matching filter over timeRange, through the
+// cache of the datasource.
+func (d *SampleDatasource) queryMetricsData(ctx context.Context, filter *database.Filter, timeRange backend.TimeRange) ([]database.DeviceWithMetrics, error) {
+	return d.cache.Query(ctx, filter, timeRange, d.database.QueryMetricsData, d.database.QueryMatchingMetrics)
+}
+
+// queryCompleteMetricsData is queryMetricsData for queries that would be wrong on truncated data,
+// such as outages after the last row, which fail with database.ErrTruncated instead.
+func (d *SampleDatasource) queryCompleteMetricsData(ctx context.Context, filter *database.Filter, timeRange backend.TimeRange) ([]database.DeviceWithMetrics, error) {
+	devices, err := d.queryMetricsData(ctx, filter, timeRange)
+	if err == nil && truncated(devices) {
+		return nil, database.ErrTruncated
+	}
+	return devices, err
+}
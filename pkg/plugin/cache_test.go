@@ -0,0 +1,229 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+// fakeMetricsData serves points of metrics 1 and 2 every 10 seconds, scaled by scale, and records
+// the ranges it is queried on.
+type fakeMetricsData struct {
+	start   time.Time
+	scale   float64
+	queries []backend.TimeRange
+}
+
+func (f *fakeMetricsData) lookup(context.Context, *database.Filter) ([]database.DeviceWithMetrics, error) {
+	device := database.DeviceWithMetrics{Device: database.Device{Id: 1, Name: "inverter"}}
+	for id := int64(1); id <= 2; id++ {
+		metric := &database.MetricWithData{Metric: database.Metric{Id: id, DeviceId: 1, Scale: f.scale}}
+		device.Metrics = append(device.Metrics, metric)
+	}
+	return []database.DeviceWithMetrics{device}, nil
+}
+
+func (f *fakeMetricsData) fetch(ctx context.Context, filter *database.Filter, timeRange backend.TimeRange) ([]database.DeviceWithMetrics, error) {
+	f.queries = append(f.queries, timeRange)
+
+	devices, _ := f.lookup(ctx, filter)
+	for _, metric := range devices[0].Metrics {
+		for i := 0; i < 1000; i++ {
+			t := f.start.Add(time.Duration(10*i) * time.Second)
+			if t.Unix() > timeRange.From.Unix() && t.Unix() < timeRange.To.Unix() {
+				metric.Times = append(metric.Times, t)
+				metric.Values = append(metric.Values, float64(i))
+			}
+		}
+	}
+	return devices, nil
+}
+
+func assertSameData(t *testing.T, expected []database.DeviceWithMetrics, got []database.DeviceWithMetrics) {
+	t.Helper()
+	if len(expected) != len(got) {
+		t.Fatalf("Expected %d devices, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		for j, metric := range expected[i].Metrics {
+			times, values := got[i].Metrics[j].Times, got[i].Metrics[j].Values
+			if len(times) != len(metric.Times) || len(values) != len(metric.Values) {
+				t.Fatalf("Expected %d points for metric %d, got %d", len(metric.Values), metric.Metric.Id, len(values))
+			}
+			for k := range values {
+				if values[k] != metric.Values[k] || !times[k].Equal(metric.Times[k]) {
+					t.Fatalf("Point mismatch for metric %d at %d", metric.Metric.Id, k)
+				}
+			}
+		}
+	}
+}
+
+func TestMetricsDataCacheQueriesTail(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	source := &fakeMetricsData{start: start, scale: 1}
+	reference := &fakeMetricsData{start: start, scale: 1}
+
+	now := start.Add(time.Hour)
+	cache := newMetricsDataCache()
+	cache.now = func() time.Time { return now }
+	filter := &database.Filter{Entity: "metrics", Value: "2, 1"}
+
+	for i := 0; i < 5; i++ {
+		timeRange := backend.TimeRange{From: now.Add(-30*time.Minute + 5*time.Second), To: now}
+		got, err := cache.Query(context.Background(), filter, timeRange, source.fetch, source.lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := reference.fetch(context.Background(), filter, timeRange)
+		assertSameData(t, expected, got)
+
+		// callers may modify what they get.
+		got[0].Metrics[0].Values[0] = -1
+		got[0].Metrics[0].Times, got[0].Metrics[0].Values = nil, nil
+
+		now = now.Add(20 * time.Second)
+	}
+
+	if len(source.queries) != 5 {
+		t.Fatalf("Expected 5 queries, got %d", len(source.queries))
+	}
+	for _, q := range source.queries[1:] {
+		if q.To.Sub(q.From) > 3*time.Minute {
+			t.Errorf("Expected only the tail to be queried, got %v to %v", q.From, q.To)
+		}
+	}
+
+	// the same metrics in another order share the entry.
+	timeRange := backend.TimeRange{From: now.Add(-10 * time.Minute), To: now.Add(-5 * time.Minute)}
+	if _, err := cache.Query(context.Background(), &database.Filter{Entity: "metrics", Value: "1,2"}, timeRange, source.fetch, source.lookup); err != nil {
+		t.Fatal(err)
+	}
+	if len(source.queries) != 5 {
+		t.Errorf("Expected a cached range not to be queried")
+	}
+}
+
+func TestMetricsDataCacheServesCurrentMetadata(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	source := &fakeMetricsData{start: start, scale: 1}
+
+	now := start.Add(time.Hour)
+	cache := newMetricsDataCache()
+	cache.now = func() time.Time { return now }
+	filter := &database.Filter{Entity: "metrics", Value: "1,2"}
+	timeRange := backend.TimeRange{From: start, To: start.Add(30 * time.Minute)}
+	if _, err := cache.Query(context.Background(), filter, timeRange, source.fetch, source.lookup); err != nil {
+		t.Fatal(err)
+	}
+
+	// the scaling changed on another instance.
+	source.scale = 10
+	got, err := cache.Query(context.Background(), filter, timeRange, source.fetch, source.lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(source.queries) != 1 {
+		t.Errorf("Expected the cached range not to be queried, got %d queries", len(source.queries))
+	}
+	if scale := got[0].Metrics[0].Metric.Scale; scale != 10 {
+		t.Errorf("Expected the current scale, got %v", scale)
+	}
+}
+
+func TestMetricsDataCacheLimits(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	source := &fakeMetricsData{start: start, scale: 1}
+
+	now := start.Add(time.Hour)
+	cache := newMetricsDataCache()
+	cache.now = func() time.Time { return now }
+	timeRange := backend.TimeRange{From: start, To: now}
+	query := func(value string) {
+		if _, err := cache.Query(context.Background(), &database.Filter{Entity: "metrics", Value: value}, timeRange, source.fetch, source.lookup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// expired entries are queried again in full.
+	query("1")
+	now = now.Add(cache.TTL + time.Second)
+	timeRange.To = now
+	query("1")
+	if q := source.queries[1]; !q.From.Equal(start) {
+		t.Errorf("Expected a full query after expiry, got %v to %v", q.From, q.To)
+	}
+
+	// least recently used entries are evicted over the size limit.
+	cache.MaxPoints = cache.points + 1
+	query("2")
+	if _, ok := cache.entries["metrics:1"]; ok {
+		t.Errorf("Expected the least recently used entry to be evicted")
+	}
+	if cache.points > cache.MaxPoints {
+		t.Errorf("Expected at most %d points, got %d", cache.MaxPoints, cache.points)
+	}
+
+	cache.Clear()
+	if cache.lru.Len() != 0 || cache.points != 0 {
+		t.Errorf("Expected an empty cache")
+	}
+}
+
+func TestMetricsDataCacheSkipsTruncated(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	source := &fakeMetricsData{start: start, scale: 1}
+	truncate := func(ctx context.Context, filter *database.Filter, timeRange backend.TimeRange) ([]database.DeviceWithMetrics, error) {
+		devices, err := source.fetch(ctx, filter, timeRange)
+		devices[0].Metrics[0].Truncated = true
+		return devices, err
+	}
+
+	now := start.Add(time.Hour)
+	cache := newMetricsDataCache()
+	cache.now = func() time.Time { return now }
+	got, err := cache.Query(context.Background(), &database.Filter{}, backend.TimeRange{From: start, To: now}, truncate, source.lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got[0].Metrics[0].Truncated {
+		t.Errorf("Expected the data to remain truncated")
+	}
+	if cache.lru.Len() != 0 {
+		t.Errorf("Expected truncated data not to be cached")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	unit := database.AdhocFilter{Key: "unit", Operator: "=", Value: "kW"}
+	device := database.AdhocFilter{Key: "device", Operator: "!=", Value: "meter"}
+
+	same := []database.Filter{
+		{Entity: "metrics", Value: "11,10", Adhoc: []database.AdhocFilter{unit, device}},
+		{Entity: "metrics", Value: "10, 11,11", Adhoc: []database.AdhocFilter{device, unit}},
+	}
+	if cacheKey(&same[0]) != cacheKey(&same[1]) {
+		t.Errorf("Expected %q and %q to match", cacheKey(&same[0]), cacheKey(&same[1]))
+	}
+
+	different := []database.Filter{
+		{},
+		{Adhoc: []database.AdhocFilter{unit}},
+		{Entity: "metrics", Value: "10,11"},
+		{Entity: "devices", Value: "10,11", Adhoc: []database.AdhocFilter{unit}},
+		{Entity: "assets", Value: "10,11"},
+		{Entity: "tags", Tags: map[string]string{"site": "plantA"}},
+		{Entity: "tags", Tags: map[string]string{"site": "plantB"}},
+	}
+	keys := map[string]bool{cacheKey(&same[0]): true}
+	for _, filter := range different {
+		key := cacheKey(&filter)
+		if keys[key] {
+			t.Errorf("Unexpected shared key %q", key)
+		}
+		keys[key] = true
+	}
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// AdhocFilter is a Grafana ad hoc filter: it keeps the metrics whose tag Key compares to Value
+// with Operator, one of =, !=, =~ and !~ (regular expressions), < and > (numeric if both sides
+// are numbers). Unset tags are empty.
+type AdhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// tagColumns are the columns of the built-in tags of metrics m joined with devices d, by key, which
+// tagValue reads from metadata. Any other key is a custom tag.
+var tagColumns = map[string]string{
+	"device":      "d.name",
+	"serial_id":   "d.serial_id",
+	"unit":        "m.unit",
+	"data_format": "m.data_format",
+	"slave_id":    "m.slave_id",
+}
+
+// QueryTagKeys returns the keys of the built-in and custom tags ad hoc filters can compare, sorted.
+func (db *Database) QueryTagKeys(ctx context.Context) ([]string, error) {
+	log.DefaultLogger.Info("QueryTagKeys called")
+	ctx, observer := observe(ctx, "QueryTagKeys")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	keys := make([]string, 0, len(tagColumns))
+	for key := range tagColumns {
+		keys = append(keys, key)
+	}
+
+	query := "SELECT tag_key FROM device_tags UNION SELECT tag_key FROM metric_tags"
+	res, err := db.db.QueryContext(ctx, statement(ctx, query))
+	if missingTable(err) {
+		// no custom tag was ever saved.
+		sort.Strings(keys)
+		return keys, nil
+	}
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	for res.Next() {
+		observer.rows++
+		var key string
+		if err := res.Scan(&key); err != nil {
+			return nil, observer.fail(err)
+		}
+		keys = append(keys, key)
+	}
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// QueryTagValues returns the distinct values of the tag key over all metrics, sorted.
+func (db *Database) QueryTagValues(ctx context.Context, key string) ([]string, error) {
+	log.DefaultLogger.Info("QueryTagValues called")
+	ctx, observer := observe(ctx, "QueryTagValues")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	query := "SELECT tag_value value FROM device_tags WHERE tag_key = ?" +
+		" UNION SELECT tag_value FROM metric_tags WHERE tag_key = ? ORDER BY value"
+	args := []interface{}{key, key}
+	if column, ok := tagColumns[key]; ok {
+		query = "SELECT DISTINCT CAST(" + column + " AS CHAR) value FROM metrics m JOIN devices d ON m.device_id = d.id" +
+			" WHERE " + column + " IS NOT NULL ORDER BY value"
+		args = nil
+	} else if err := checkTagKey(key); err != nil {
+		return nil, err
+	}
+	res, err := db.db.QueryContext(ctx, statement(ctx, query), args...)
+	if missingTable(err) {
+		return make([]string, 0), nil
+	}
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	values := make([]string, 0)
+	for res.Next() {
+		observer.rows++
+		var value string
+		if err := res.Scan(&value); err != nil {
+			return nil, observer.fail(err)
+		}
+		values = append(values, value)
+	}
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+	return values, nil
+}
+
+// adhocMatcher tells whether a tag value compares to the value of an ad hoc filter.
+type adhocMatcher func(tagValue string) bool
+
+// adhocMatchers returns the matchers of filters, in order.
+func adhocMatchers(filters []AdhocFilter) ([]adhocMatcher, error) {
+	matchers := make([]adhocMatcher, 0, len(filters))
+	for _, f := range filters {
+		if _, builtin := tagColumns[f.Key]; !builtin {
+			if err := checkTagKey(f.Key); err != nil {
+				return nil, err
+			}
+		}
+
+		value := f.Value
+		var matcher adhocMatcher
+		switch f.Operator {
+		case "=":
+			matcher = func(v string) bool { return v == value }
+		case "!=":
+			matcher = func(v string) bool { return v != value }
+		case "=~", "!~":
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return nil, &Error{Kind: ErrorKindInvalidFilter, Message: "invalid regular expression '" + value + "'"}
+			}
+			negated := f.Operator == "!~"
+			matcher = func(v string) bool { return pattern.MatchString(v) != negated }
+		case "<":
+			matcher = func(v string) bool { return compareTagValues(v, value) < 0 }
+		case ">":
+			matcher = func(v string) bool { return compareTagValues(v, value) > 0 }
+		default:
+			return nil, &Error{Kind: ErrorKindInvalidFilter, Message: "unknown ad hoc filter operator '" + f.Operator + "'"}
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// compareTagValues compares a and b as numbers if both are, as strings otherwise, returning -1, 0
+// or 1 as a is less than, equal to or greater than b.
+func compareTagValues(a, b string) int {
+	x, errA := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	y, errB := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	if errA != nil || errB != nil {
+		return strings.Compare(a, b)
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// tagValue returns the value of the tag key of metric, built-in or custom, empty if unset.
+// serialId is the serial id of the device of metric.
+func tagValue(metric *Metric, serialId string, key string) string {
+	switch key {
+	case "device":
+		return metric.DeviceName
+	case "serial_id":
+		return serialId
+	case "unit":
+		return metric.Unit
+	case "data_format":
+		return metric.DataFormat
+	case "slave_id":
+		return strconv.FormatInt(int64(metric.SlaveId), 10)
+	default:
+		return metric.Tags[key]
+	}
+}
+
+// adhocFilter returns the metrics that match filters, comparing the tags of the metadata snapshot
+// meta the metrics come from.
+func adhocFilter(meta *metadata, metrics []Metric, filters []AdhocFilter) ([]Metric, error) {
+	matchers, err := adhocMatchers(filters)
+	if err != nil {
+		return nil, err
+	}
+	serialIds := make(map[int64]string, len(meta.devices))
+	for _, device := range meta.devices {
+		serialIds[device.Id] = device.SerialId
+	}
+
+	filtered := make([]Metric, 0, len(metrics))
+	for i := range metrics {
+		matches := true
+		for j, f := range filters {
+			if !matchers[j](tagValue(&metrics[i], serialIds[metrics[i].DeviceId], f.Key)) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, metrics[i])
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestAdhocFilter(t *testing.T) {
+	meta := &metadata{
+		devices: []Device{{Id: 1, Name: "inverter", SerialId: "SN1"}, {Id: 2, Name: "meter", SerialId: "SN2"}},
+		metrics: []Metric{
+			{Id: 10, DeviceId: 1, DeviceName: "inverter", Unit: "kW", SlaveId: 3, Tags: map[string]string{"site": "north", "floor": "9"}},
+			{Id: 11, DeviceId: 1, DeviceName: "inverter", Unit: "V", SlaveId: 3, Tags: map[string]string{"site": "north", "floor": "10"}},
+			{Id: 20, DeviceId: 2, DeviceName: "meter", Unit: "kWh", SlaveId: 12, Tags: map[string]string{"floor": "12"}},
+		},
+	}
+
+	type TestCase struct {
+		filters     []AdhocFilter
+		expectedIds []int64
+	}
+
+	cases := []TestCase{
+		{filters: []AdhocFilter{{Key: "device", Operator: "=", Value: "inverter"}}, expectedIds: []int64{10, 11}},
+		{filters: []AdhocFilter{{Key: "unit", Operator: "!~", Value: "^k"}}, expectedIds: []int64{11}},
+		{filters: []AdhocFilter{{Key: "unit", Operator: "=~", Value: "^k"}, {Key: "serial_id", Operator: "!=", Value: "SN1"}}, expectedIds: []int64{20}},
+		// numbers compare as such, so 12 is greater than 3 and 10 than 9.
+		{filters: []AdhocFilter{{Key: "slave_id", Operator: ">", Value: "3"}}, expectedIds: []int64{20}},
+		{filters: []AdhocFilter{{Key: "floor", Operator: "<", Value: "10"}}, expectedIds: []int64{10}},
+		// other values compare as strings, an unset tag being empty.
+		{filters: []AdhocFilter{{Key: "site", Operator: "<", Value: "o"}}, expectedIds: []int64{10, 11, 20}},
+		{filters: []AdhocFilter{{Key: "site", Operator: "=", Value: ""}}, expectedIds: []int64{20}},
+	}
+
+	for _, tc := range cases {
+		metrics, err := adhocFilter(meta, meta.metrics, tc.filters)
+		if err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", tc.filters, err)
+		}
+		ids := make([]int64, len(metrics))
+		for i, metric := range metrics {
+			ids[i] = metric.Id
+		}
+		if !reflect.DeepEqual(ids, tc.expectedIds) {
+			t.Errorf("Expected metrics %v for %+v, got %v", tc.expectedIds, tc.filters, ids)
+		}
+	}
+
+	invalid := []AdhocFilter{
+		{Key: "bad key", Operator: "=", Value: "a"},
+		{Key: "unit", Operator: "LIKE", Value: "k%"},
+		{Key: "unit", Operator: "=~", Value: "(k"},
+	}
+	for _, f := range invalid {
+		if _, err := adhocFilter(meta, meta.metrics, []AdhocFilter{f}); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected an invalid filter for %+v, got %v", f, err)
+		}
+	}
+}
+
+func TestQueryMetricsDataAdhocFilters(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	db := fakeDatabase(&fakeMetricsData{metricIds: []int64{10}, points: 5, start: start})
+	db.metadata.metrics[0].Unit = "kW"
+	db.metadata.metrics[2].Unit = "kW"
+	filter := &Filter{Entity: "devices", Value: "1", Adhoc: []AdhocFilter{{Key: "unit", Operator: "=", Value: "kW"}}}
+
+	devices, err := db.QueryMetricsData(context.Background(), filter, backend.TimeRange{From: start, To: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// metric 11 of device 1 does not match, metric 20 matches but is not on device 1.
+	if len(devices) != 1 || len(devices[0].Metrics) != 1 || devices[0].Metrics[0].Metric.Id != 10 {
+		t.Fatalf("Expected metric 10 alone, got %+v", devices)
+	}
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Asset is a node of the plant hierarchy, such as a site, an area or a line. Assets form a tree
+// through ParentId, 0 for the roots, and each holds the Devices listed by id, a device belonging
+// to a single asset.
+type Asset struct {
+	Id       int64   `json:"id"`
+	ParentId int64   `json:"parentId"`
+	Name     string  `json:"name"`
+	Kind     string  `json:"kind"`
+	Devices  []int64 `json:"devices"`
+}
+
+// QueryAssets returns all the assets, sorted by id.
+func (db *Database) QueryAssets(ctx context.Context) ([]Asset, error) {
+	log.DefaultLogger.Info("QueryAssets called")
+	ctx, observer := observe(ctx, "QueryAssets")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	meta, err := db.cachedMetadata(ctx)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	assets := make([]Asset, len(meta.assets))
+	copy(assets, meta.assets)
+	return assets, nil
+}
+
+// SaveAsset creates asset, setting its Id, or replaces it if it has one. Its devices are moved
+// from the assets they belonged to.
+func (db *Database) SaveAsset(ctx context.Context, asset *Asset) error {
+	log.DefaultLogger.Info("SaveAsset called")
+	ctx, observer := observe(ctx, "SaveAsset")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+	// the checks need the latest tree, which other instances may have changed.
+	meta, err := db.loadMetadata(ctx)
+	if err != nil {
+		return observer.fail(err)
+	}
+	if err := meta.checkAsset(asset); err != nil {
+		return err
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return observer.fail(err)
+	}
+	defer tx.Rollback()
+
+	if err := checkAncestors(ctx, tx, asset); err != nil {
+		var invalid *Error
+		if errors.As(err, &invalid) {
+			return err
+		}
+		return observer.fail(err)
+	}
+
+	parentId := sql.NullInt64{Int64: asset.ParentId, Valid: asset.ParentId != 0}
+	if asset.Id == 0 {
+		res, err := tx.ExecContext(ctx, statement(ctx, "INSERT INTO assets (parent_id, name, kind) VALUES (?, ?, ?)"),
+			parentId, asset.Name, asset.Kind)
+		if err != nil {
+			return observer.fail(err)
+		}
+		if asset.Id, err = res.LastInsertId(); err != nil {
+			return observer.fail(err)
+		}
+	} else {
+		_, err := tx.ExecContext(ctx, statement(ctx, "UPDATE assets SET parent_id = ?, name = ?, kind = ? WHERE id = ?"),
+			parentId, asset.Name, asset.Kind, asset.Id)
+		if err != nil {
+			return observer.fail(err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, statement(ctx, "DELETE FROM asset_devices WHERE asset_id = ?"), asset.Id); err != nil {
+		return observer.fail(err)
+	}
+	for _, deviceId := range asset.Devices {
+		_, err := tx.ExecContext(ctx, statement(ctx, "INSERT INTO asset_devices (device_id, asset_id) VALUES (?, ?)"+
+			" ON DUPLICATE KEY UPDATE asset_id = VALUES(asset_id)"), deviceId, asset.Id)
+		if err != nil {
+			return observer.fail(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return observer.fail(err)
+	}
+
+	db.InvalidateMetadata()
+	return nil
+}
+
+// DeleteAsset deletes the asset assetId, which must have no children, releasing its devices.
+func (db *Database) DeleteAsset(ctx context.Context, assetId int64) error {
+	log.DefaultLogger.Info("DeleteAsset called")
+	ctx, observer := observe(ctx, "DeleteAsset")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return observer.fail(err)
+	}
+	defer tx.Rollback()
+
+	// the children are checked in the transaction, which locks the rows it reads so that other
+	// instances cannot move assets under this one until it is deleted.
+	var childId int64
+	err = tx.QueryRowContext(ctx, statement(ctx, "SELECT id FROM assets WHERE parent_id = ? LIMIT 1 FOR UPDATE"), assetId).Scan(&childId)
+	if err == nil {
+		return invalidAsset("asset " + strconv.FormatInt(assetId, 10) + " has children, delete or move them first")
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return observer.fail(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, statement(ctx, "DELETE FROM asset_devices WHERE asset_id = ?"), assetId); err != nil {
+		return observer.fail(err)
+	}
+	if _, err := tx.ExecContext(ctx, statement(ctx, "DELETE FROM assets WHERE id = ?"), assetId); err != nil {
+		return observer.fail(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return observer.fail(err)
+	}
+
+	db.InvalidateMetadata()
+	return nil
+}
+
+// loadAssets returns all the assets along with their devices, sorted by id, none if no asset was
+// ever saved.
+func (db *Database) loadAssets(ctx context.Context) ([]Asset, error) {
+	assets := make([]Asset, 0)
+	res, err := db.db.QueryContext(ctx, statement(ctx, "SELECT id, COALESCE(parent_id, 0), name, kind FROM assets ORDER BY id"))
+	if missingTable(err) {
+		return assets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	byId := make(map[int64]int)
+	for res.Next() {
+		var asset Asset
+		if err := res.Scan(&asset.Id, &asset.ParentId, &asset.Name, &asset.Kind); err != nil {
+			return nil, err
+		}
+		byId[asset.Id] = len(assets)
+		assets = append(assets, asset)
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+
+	devices, err := db.db.QueryContext(ctx, statement(ctx, "SELECT asset_id, device_id FROM asset_devices ORDER BY device_id"))
+	if err != nil {
+		return nil, err
+	}
+	defer devices.Close()
+
+	for devices.Next() {
+		var assetId, deviceId int64
+		if err := devices.Scan(&assetId, &deviceId); err != nil {
+			return nil, err
+		}
+		if i, ok := byId[assetId]; ok {
+			assets[i].Devices = append(assets[i].Devices, deviceId)
+		}
+	}
+	return assets, devices.Err()
+}
+
+// assetDevices returns the devices of the assets ids and of all the assets under them.
+func (m *metadata) assetDevices(ids map[int64]bool) map[int64]bool {
+	children := make(map[int64][]*Asset)
+	byId := make(map[int64]*Asset, len(m.assets))
+	for i := range m.assets {
+		asset := &m.assets[i]
+		children[asset.ParentId] = append(children[asset.ParentId], asset)
+		byId[asset.Id] = asset
+	}
+
+	devices := make(map[int64]bool)
+	visited := make(map[int64]bool)
+	pending := make([]*Asset, 0, len(ids))
+	for id := range ids {
+		if asset, ok := byId[id]; ok {
+			pending = append(pending, asset)
+		}
+	}
+	for len(pending) > 0 {
+		asset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if visited[asset.Id] {
+			continue
+		}
+		visited[asset.Id] = true
+
+		for _, deviceId := range asset.Devices {
+			devices[deviceId] = true
+		}
+		pending = append(pending, children[asset.Id]...)
+	}
+	return devices
+}
+
+// checkAsset returns an error if asset cannot be saved in the tree of m: its parent and devices
+// must exist. Whether it is moved under itself is left to checkAncestors, which sees the tree of
+// the transaction saving it.
+func (m *metadata) checkAsset(asset *Asset) error {
+	if asset.Name == "" {
+		return invalidAsset("assets need a name")
+	}
+	if asset.Id != 0 && !m.assetIds[asset.Id] {
+		return invalidAsset("asset " + strconv.FormatInt(asset.Id, 10) + " does not exist")
+	}
+	if asset.ParentId != 0 && !m.assetIds[asset.ParentId] {
+		return invalidAsset("parent asset " + strconv.FormatInt(asset.ParentId, 10) + " does not exist")
+	}
+	for _, deviceId := range asset.Devices {
+		if !m.deviceIds[deviceId] {
+			return invalidAsset("device " + strconv.FormatInt(deviceId, 10) + " does not exist")
+		}
+	}
+	return nil
+}
+
+// checkAncestors returns an error if asset would be moved under itself, walking up from its parent
+// in tx. The rows read are locked until tx ends, so that other instances cannot move the ancestors
+// of asset under it meanwhile.
+func checkAncestors(ctx context.Context, tx *sql.Tx, asset *Asset) error {
+	seen := make(map[int64]bool)
+	for id := asset.ParentId; id != 0 && !seen[id]; {
+		if id == asset.Id {
+			return invalidAsset("asset " + strconv.FormatInt(asset.Id, 10) + " cannot be moved under itself")
+		}
+		seen[id] = true
+
+		var parentId sql.NullInt64
+		err := tx.QueryRowContext(ctx, statement(ctx, "SELECT parent_id FROM assets WHERE id = ? FOR UPDATE"), id).Scan(&parentId)
+		if errors.Is(err, sql.ErrNoRows) {
+			// deleted since the tree was loaded.
+			return invalidAsset("asset " + strconv.FormatInt(id, 10) + " does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		id = parentId.Int64
+	}
+	return nil
+}
+
+func invalidAsset(message string) *Error {
+	return &Error{Kind: ErrorKindInvalidFilter, Message: message}
+}
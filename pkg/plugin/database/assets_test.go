@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testAssets returns a site holding an area, which holds device 1, and device 2 outside both.
+func testAssets() *metadata {
+	meta := testMetadata()
+	meta.assets = []Asset{
+		{Id: 1, Name: "plantA", Kind: "site"},
+		{Id: 2, ParentId: 1, Name: "hall1", Kind: "area", Devices: []int64{1}},
+		{Id: 3, ParentId: 1, Name: "hall2", Kind: "area"},
+	}
+	meta.assetIds = map[int64]bool{1: true, 2: true, 3: true}
+	return meta
+}
+
+func TestQueryFilteredMetricsByAssets(t *testing.T) {
+	db := &Database{metadata: testAssets()}
+
+	type TestCase struct {
+		filter      Filter
+		expectedIds []int64
+	}
+
+	cases := []TestCase{
+		// the devices of the site are those of its areas.
+		{filter: Filter{Entity: "assets", Value: "1"}, expectedIds: []int64{10, 11}},
+		{filter: Filter{Entity: "assets", Value: "2"}, expectedIds: []int64{10, 11}},
+		{filter: Filter{Entity: "assets", Value: "3"}, expectedIds: []int64{}},
+	}
+
+	for _, tc := range cases {
+		metrics, err := db.queryFilteredMetrics(context.Background(), &tc.filter)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", tc.filter, err)
+		}
+		if len(metrics) != len(tc.expectedIds) {
+			t.Errorf("Expected %d metrics for %v, got %d", len(tc.expectedIds), tc.filter, len(metrics))
+			continue
+		}
+		for i, id := range tc.expectedIds {
+			if metrics[i].Id != id {
+				t.Errorf("Metric mismatch for %v at %d: Expected %d, got %d", tc.filter, i, id, metrics[i].Id)
+			}
+		}
+	}
+}
+
+func TestCheckAsset(t *testing.T) {
+	meta := testAssets()
+
+	if err := meta.checkAsset(&Asset{Id: 3, ParentId: 2, Name: "hall2", Devices: []int64{2}}); err != nil {
+		t.Errorf("Unexpected error moving an area: %v", err)
+	}
+
+	invalid := []Asset{
+		{Name: ""},
+		{Id: 9, Name: "unknown"},
+		{ParentId: 9, Name: "orphan"},
+		{Name: "line", Devices: []int64{5}},
+	}
+	for _, asset := range invalid {
+		if err := meta.checkAsset(&asset); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected an invalid asset for %+v, got %v", asset, err)
+		}
+	}
+}
+
+// fakeAssetTree is a database/sql backend answering the parent of assets, as in the tree of
+// testAssets, with the ids of the rows it locked.
+type fakeAssetTree struct {
+	parents map[int64]int64
+	locked  []int64
+}
+
+func (f *fakeAssetTree) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeAssetTree) Driver() driver.Driver                        { return nil }
+func (f *fakeAssetTree) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (f *fakeAssetTree) Close() error              { return nil }
+func (f *fakeAssetTree) Begin() (driver.Tx, error) { return f, nil }
+func (f *fakeAssetTree) Commit() error             { return nil }
+func (f *fakeAssetTree) Rollback() error           { return nil }
+
+func (f *fakeAssetTree) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.HasSuffix(query, "FOR UPDATE") {
+		return nil, errors.New("unexpected query " + query)
+	}
+	id := args[0].Value.(int64)
+	parentId, ok := f.parents[id]
+	if !ok {
+		return &fakeRows{columns: []string{"parent_id"}}, nil
+	}
+	f.locked = append(f.locked, id)
+	return &fakeRows{columns: []string{"parent_id"}, count: 1, row: func(_ int, dest []driver.Value) {
+		dest[0] = nil
+		if parentId != 0 {
+			dest[0] = parentId
+		}
+	}}, nil
+}
+
+func TestCheckAncestors(t *testing.T) {
+	source := &fakeAssetTree{parents: map[int64]int64{1: 0, 2: 1, 3: 1}}
+	db := sql.OpenDB(source)
+
+	check := func(asset Asset) error {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		return checkAncestors(context.Background(), tx, &asset)
+	}
+
+	if err := check(Asset{Id: 3, ParentId: 2, Name: "hall2"}); err != nil {
+		t.Errorf("Unexpected error moving an area: %v", err)
+	}
+	if len(source.locked) != 2 || source.locked[0] != 2 || source.locked[1] != 1 {
+		t.Errorf("Expected the ancestors 2 and 1 to be locked, got %v", source.locked)
+	}
+
+	invalid := []Asset{
+		{Id: 1, ParentId: 2, Name: "plantA"},
+		{Id: 2, ParentId: 2, Name: "hall1"},
+		// the parent was deleted since the tree was loaded.
+		{ParentId: 9, Name: "orphan"},
+	}
+	for _, asset := range invalid {
+		if err := check(asset); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected an invalid asset for %+v, got %v", asset, err)
+		}
+	}
+}
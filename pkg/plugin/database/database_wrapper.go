@@ -1,13 +1,15 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -59,8 +61,10 @@ func (db *Database) Close() error {
 	return nil
 }
 
-func (db *Database) TestConnection() (result *TestResult) {
+func (db *Database) TestConnection(ctx context.Context) (result *TestResult) {
 	log.DefaultLogger.Info("TestConnection called")
+	ctx, observer := observe(ctx, "TestConnection")
+	defer observer.done()
 	defer func() {
 		if r := recover(); r != nil {
 			result = new(TestResult)
@@ -70,7 +74,7 @@ func (db *Database) TestConnection() (result *TestResult) {
 	}()
 
 	var version string
-	err := db.db.QueryRow("SELECT VERSION()").Scan(&version)
+	err := db.db.QueryRowContext(ctx, statement(ctx, "SELECT VERSION()")).Scan(&version)
 
 	if err != nil {
 		db.Close()
@@ -86,172 +90,496 @@ func (db *Database) TestConnection() (result *TestResult) {
 	}
 }
 
-func (db *Database) QueryDevices() ([]Device, error) {
+func (db *Database) QueryDevices(ctx context.Context) ([]Device, error) {
 	log.DefaultLogger.Info("QueryDevices called")
+	ctx, observer := observe(ctx, "QueryDevices")
+	defer observer.done()
 	if !db.IsConnected() {
-		return nil, errors.New("not connected to any database")
+		return nil, ErrNotConnected
 	}
-	res, err := db.db.Query("SELECT id, serial_id, name FROM devices")
-	defer res.Close()
 
+	meta, err := db.cachedMetadata(ctx)
 	if err != nil {
-		log.DefaultLogger.Error("QueryDevices", err)
-		return nil, err
-	}
-
-	devices := make([]Device, 0)
-	for res.Next() {
-		var device Device
-		err := res.Scan(&device.Id, &device.SerialId, &device.Name)
-
-		if err != nil {
-			log.DefaultLogger.Error("QueryDevices", err)
-			return nil, err
-		}
-		devices = append(devices, device)
+		return nil, observer.fail(err)
 	}
+	devices := make([]Device, len(meta.devices))
+	copy(devices, meta.devices)
 
 	log.DefaultLogger.Info("Found "+strconv.Itoa(len(devices))+" devices.", "devices", devices)
 	return devices, nil
 }
 
-func (db *Database) QueryMetrics(deviceIdsCsv *string) ([]Metric, error) {
+func (db *Database) QueryMetrics(ctx context.Context, deviceIdsCsv *string) ([]Metric, error) {
 	log.DefaultLogger.Info("QueryMetrics called")
+	ctx, observer := observe(ctx, "QueryMetrics")
+	defer observer.done()
 	if !db.IsConnected() {
-		return nil, errors.New("not connected to any database")
+		return nil, ErrNotConnected
 	}
 
-	query := "SELECT m.id, m.device_id, m.slave_id, m.function_code," +
-		" m.register_start, m.data_format, m.byte_order, m.refresh_rate, m.name, m.unit, d.name" +
-		" FROM metrics m JOIN devices d on m.device_id = d.id"
+	filter := &Filter{}
 	if deviceIdsCsv != nil {
-		query += " WHERE device_id in (" + *deviceIdsCsv + ")"
+		filter = &Filter{Entity: "devices", Value: *deviceIdsCsv}
 	}
-	res, err := db.db.Query(query)
-	defer res.Close()
+	metrics, err := db.queryFilteredMetrics(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	return metrics, nil
+}
+
+// QueryMatchingMetrics returns the metrics matching filter, grouped by device as by
+// QueryMetricsData but without data.
+func (db *Database) QueryMatchingMetrics(ctx context.Context, filter *Filter) ([]DeviceWithMetrics, error) {
+	log.DefaultLogger.Info("QueryMatchingMetrics called")
+	ctx, observer := observe(ctx, "QueryMatchingMetrics")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	metrics, err := db.matchingMetrics(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+
+	devices := make([]DeviceWithMetrics, 0)
+	byId := make(map[int64]int)
+	for _, metric := range metrics {
+		i, in := byId[metric.DeviceId]
+		if !in {
+			i = len(devices)
+			byId[metric.DeviceId] = i
+			devices = append(devices, DeviceWithMetrics{
+				Device:  Device{Id: metric.DeviceId, Name: metric.DeviceName},
+				Metrics: make([]*MetricWithData, 0),
+			})
+		}
+		devices[i].Metrics = append(devices[i].Metrics, &MetricWithData{Metric: metric})
+	}
+	return devices, nil
+}
 
+// matchingMetrics returns the metrics matching filter, including its ad hoc filters.
+func (db *Database) matchingMetrics(ctx context.Context, filter *Filter) ([]Metric, error) {
+	metrics, err := db.queryFilteredMetrics(ctx, filter)
 	if err != nil {
-		log.DefaultLogger.Error("QueryMetrics", err)
 		return nil, err
 	}
+	if len(filter.Adhoc) == 0 || len(metrics) == 0 {
+		return metrics, nil
+	}
+	meta, err := db.cachedMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return adhocFilter(meta, metrics, filter.Adhoc)
+}
+
+// QueryMetricsData returns the data of the metrics matching filter over timerange, bounds excluded,
+// grouped by device. At most RowLimit rows are returned, the metrics are Truncated beyond.
+func (db *Database) QueryMetricsData(ctx context.Context, filter *Filter, timerange backend.TimeRange) ([]DeviceWithMetrics, error) {
+	log.DefaultLogger.Info("QueryMetricsData called")
+	ctx, observer := observe(ctx, "QueryMetricsData")
+	defer observer.done()
+
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
 
-	metrics := make([]Metric, 0)
+	filtered, err := db.matchingMetrics(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+
+	metrics := make(map[int64]*MetricWithData)
+	devices := make(map[int64]*DeviceWithMetrics)
+	for _, metric := range filtered {
+		metrics[metric.Id] = &MetricWithData{Metric: metric}
+
+		if _, in := devices[metric.DeviceId]; !in {
+			devices[metric.DeviceId] = &DeviceWithMetrics{
+				Device: Device{
+					Id:   metric.DeviceId,
+					Name: metric.DeviceName,
+				},
+				Metrics: make([]*MetricWithData, 0),
+			}
+		}
+		devices[metric.DeviceId].Metrics = append(devices[metric.DeviceId].Metrics, metrics[metric.Id])
+	}
+
+	if len(filtered) == 0 {
+		return make([]DeviceWithMetrics, 0), nil
+	}
+	where := " WHERE UNIX_TIMESTAMP(timestamp) > " + strconv.FormatInt(timerange.From.Unix(), 10) +
+		" AND UNIX_TIMESTAMP(timestamp) < " + strconv.FormatInt(timerange.To.Unix(), 10) +
+		" AND metric_id in (" + metricIdsCsv(filtered) + ")"
+
+	limit := db.rowLimit()
+	if err := db.preallocateMetricsData(ctx, metrics, where, limit); err != nil {
+		return nil, observer.fail(err)
+	}
+
+	// one more row than the limit tells whether the data was truncated.
+	query := "SELECT metric_id, value, UNIX_TIMESTAMP(timestamp) FROM metrics_data" + where +
+		" ORDER BY timestamp ASC LIMIT " + strconv.Itoa(limit+1)
+	log.DefaultLogger.Info("QUERY " + query)
+	res, err := db.db.QueryContext(ctx, statement(ctx, query))
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	var metricId, timestamp int64
+	var value float64
 	for res.Next() {
-		var metric Metric
-		err := res.Scan(&metric.Id,
-			&metric.DeviceId,
-			&metric.SlaveId,
-			&metric.FunctionCode,
-			&metric.RegisterStart,
-			&metric.DataFormat,
-			&metric.ByteOrder,
-			&metric.RefreshRate,
-			&metric.Name,
-			&metric.Unit,
-			&metric.DeviceName)
+		if observer.rows == limit {
+			for _, mwd := range metrics {
+				mwd.Truncated = true
+			}
+			break
+		}
+		observer.rows++
+		if err := res.Scan(&metricId, &value, &timestamp); err != nil {
+			return nil, observer.fail(err)
+		}
+		mwd := metrics[metricId]
+		mwd.Times = append(mwd.Times, time.Unix(timestamp, 0))
+		mwd.Values = append(mwd.Values, value)
+	}
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+
+	// extract Metrics from map
+	data := make([]DeviceWithMetrics, 0, len(devices))
+	for _, d := range devices {
+		data = append(data, *d)
+	}
+	return data, nil
+}
+
+// preallocateMetricsData sizes the vectors of metrics for their rows matching where, up to limit,
+// so that scanning the rows never grows them.
+func (db *Database) preallocateMetricsData(ctx context.Context, metrics map[int64]*MetricWithData, where string, limit int) error {
+	res, err := db.db.QueryContext(ctx, statement(ctx, "SELECT metric_id, COUNT(*) FROM metrics_data"+where+" GROUP BY metric_id"))
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var metricId int64
+		var count int
+		if err := res.Scan(&metricId, &count); err != nil {
+			return err
+		}
+		mwd, ok := metrics[metricId]
+		if !ok {
+			continue
+		}
+		if count > limit {
+			count = limit
+		}
+		mwd.Times = make([]time.Time, 0, count)
+		mwd.Values = make([]float64, 0, count)
+	}
+	return res.Err()
+}
+
+// QueryMetricsStats returns the statistics of the metrics matching filter over timerange. The
+// percentiles are computed from the values of the metrics, which are left out and flagged
+// PercentilesTruncated if they are more than the row limit.
+func (db *Database) QueryMetricsStats(ctx context.Context, filter *Filter, timerange backend.TimeRange, percentiles []float64) ([]MetricStats, error) {
+	log.DefaultLogger.Info("QueryMetricsStats called")
+	ctx, observer := observe(ctx, "QueryMetricsStats")
+	defer observer.done()
+
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	metrics, err := db.queryFilteredMetrics(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+
+	stats := make([]MetricStats, len(metrics))
+	byId := make(map[int64]*MetricStats, len(metrics))
+	for i, metric := range metrics {
+		stats[i].Metric = metric
+		byId[metric.Id] = &stats[i]
+	}
+	if len(metrics) == 0 {
+		return stats, nil
+	}
+
+	where := " WHERE UNIX_TIMESTAMP(timestamp) > " + strconv.FormatInt(timerange.From.Unix(), 10) +
+		" AND UNIX_TIMESTAMP(timestamp) < " + strconv.FormatInt(timerange.To.Unix(), 10) +
+		" AND metric_id in (" + metricIdsCsv(metrics) + ")"
+
+	// first and last values are fetched by joining back on the boundary timestamps of each metric.
+	query := "SELECT s.metric_id, s.cnt, s.min_v, s.max_v, s.avg_v, s.std_v, f.value, l.value FROM" +
+		" (SELECT metric_id, COUNT(*) cnt, MIN(value) min_v, MAX(value) max_v, AVG(value) avg_v," +
+		" STDDEV_POP(value) std_v, MIN(timestamp) first_ts, MAX(timestamp) last_ts FROM metrics_data" +
+		where + " GROUP BY metric_id) s" +
+		" JOIN metrics_data f ON f.metric_id = s.metric_id AND f.timestamp = s.first_ts" +
+		" JOIN metrics_data l ON l.metric_id = s.metric_id AND l.timestamp = s.last_ts"
+
+	res, err := db.db.QueryContext(ctx, statement(ctx, query))
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
 
+	for res.Next() {
+		observer.rows++
+		var metricId int64
+		var s MetricStats
+		err := res.Scan(&metricId, &s.Count, &s.Min, &s.Max, &s.Mean, &s.StdDev, &s.First, &s.Last)
 		if err != nil {
-			log.DefaultLogger.Error("QueryMetrics", err)
-			return nil, err
+			return nil, observer.fail(err)
 		}
-		metrics = append(metrics, metric)
+
+		// several rows may share a boundary timestamp, keep the first one.
+		if st := byId[metricId]; st != nil && st.Count == 0 {
+			s.Metric = st.Metric
+			*st = s
+		}
+	}
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
 	}
 
-	return metrics, nil
+	if len(percentiles) == 0 {
+		return stats, nil
+	}
+
+	// percentiles cannot be computed portably in SQL, sort the values in the database and pick them
+	// here. One more row than the limit tells that there are too many values.
+	limit := db.rowLimit()
+	res, err = db.db.QueryContext(ctx, statement(ctx, "SELECT metric_id, value FROM metrics_data"+where+
+		" ORDER BY metric_id, value LIMIT "+strconv.Itoa(limit+1)))
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	values := make(map[int64][]float64, len(metrics))
+	rows := 0
+	for res.Next() {
+		if rows == limit {
+			for i := range stats {
+				stats[i].PercentilesTruncated = true
+			}
+			return stats, nil
+		}
+		rows++
+		observer.rows++
+		var metricId int64
+		var value float64
+		if err := res.Scan(&metricId, &value); err != nil {
+			return nil, observer.fail(err)
+		}
+		values[metricId] = append(values[metricId], value)
+	}
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+
+	for i := range stats {
+		sorted := values[stats[i].Metric.Id]
+		if len(sorted) == 0 {
+			continue
+		}
+		stats[i].Percentiles = make([]float64, len(percentiles))
+		for j, p := range percentiles {
+			stats[i].Percentiles[j] = series.Percentile(sorted, p)
+		}
+	}
+
+	return stats, nil
 }
 
-func (db *Database) QueryMetricsData(filter *Filter, timerange backend.TimeRange) ([]DeviceWithMetrics, error) {
-	log.DefaultLogger.Info("QueryMetricsData called")
+// QueryFilteredMetrics returns the metrics matching filter, including their device name.
+func (db *Database) QueryFilteredMetrics(ctx context.Context, filter *Filter) ([]Metric, error) {
+	log.DefaultLogger.Info("QueryFilteredMetrics called")
+	ctx, observer := observe(ctx, "QueryFilteredMetrics")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	metrics, err := db.queryFilteredMetrics(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	return metrics, nil
+}
 
+// QueryMetric returns the metric metricId, including its device name, or ErrUnknownMetric.
+func (db *Database) QueryMetric(ctx context.Context, metricId int64) (*Metric, error) {
+	log.DefaultLogger.Info("QueryMetric called")
+	ctx, observer := observe(ctx, "QueryMetric")
+	defer observer.done()
 	if !db.IsConnected() {
-		return nil, errors.New("not connected to any database")
+		return nil, ErrNotConnected
 	}
 
-	// Query metrics
-	query := "select d.id, d.name, m.id, m.name, m.data_format, m.byte_order, m.unit from metrics m" +
-		" join devices d on m.device_id = d.id"
-	if filter.Entity == "devices" {
-		query += " WHERE d.id in (" + filter.Value + ")"
-	} else if filter.Entity == "metrics" {
-		query += " WHERE m.id in (" + filter.Value + ")"
+	metrics, err := db.queryFilteredMetrics(ctx, &Filter{Entity: "metrics", Value: strconv.FormatInt(metricId, 10)})
+	if err != nil {
+		return nil, observer.fail(err)
 	}
+	if len(metrics) == 0 {
+		return nil, unknownMetric(metricId)
+	}
+	return &metrics[0], nil
+}
 
-	res, err := db.db.Query(query)
+// queryFilteredMetrics returns the metrics matching filter from the metadata cache, along with
+// the id and name of their device, their scaling and their value mappings.
+func (db *Database) queryFilteredMetrics(ctx context.Context, filter *Filter) ([]Metric, error) {
+	ids, err := filterIds(filter)
 	if err != nil {
-		log.DefaultLogger.Error("QueryMetricsData", err)
 		return nil, err
 	}
 
-	metrics := make(map[int64]*MetricWithData)
-	devices := make(map[int64]*DeviceWithMetrics)
+	meta, err := db.cachedMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// ids unknown to the cache may have been created since it was loaded.
+	if !meta.knows(filter.Entity, ids) && time.Since(meta.loaded) > metadataMinAge {
+		if meta, err = db.loadMetadata(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var assetDevices map[int64]bool
+	if filter.Entity == "assets" {
+		assetDevices = meta.assetDevices(ids)
+	}
+
+	metrics := make([]Metric, 0)
+	for _, metric := range meta.metrics {
+		switch {
+		case filter.Entity == "devices" && !ids[metric.DeviceId]:
+		case filter.Entity == "assets" && !assetDevices[metric.DeviceId]:
+		case filter.Entity == "metrics" && !ids[metric.Id]:
+		case filter.Entity == "tags" && !hasTags(metric.Tags, filter.Tags):
+		default:
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// loadMetrics returns all the metrics, along with the id and name of their device, their scaling
+// and their value mappings.
+func (db *Database) loadMetrics(ctx context.Context) ([]Metric, error) {
+	query := "select d.id, d.name, m.id, m.name, m.slave_id, m.function_code, m.register_start," +
+		" m.data_format, m.byte_order, m.unit, m.refresh_rate," +
+		" coalesce(s.scale, 1), coalesce(s.value_offset, 0), coalesce(s.unit, '') from metrics m" +
+		" join devices d on m.device_id = d.id" +
+		" left join metric_scaling s on s.metric_id = m.id order by m.id"
+
+	res, err := db.db.QueryContext(ctx, statement(ctx, query))
+	if missingTable(err) {
+		// no scaling was ever saved.
+		query = "select d.id, d.name, m.id, m.name, m.slave_id, m.function_code, m.register_start," +
+			" m.data_format, m.byte_order, m.unit, m.refresh_rate, 1, 0, '' from metrics m" +
+			" join devices d on m.device_id = d.id order by m.id"
+		res, err = db.db.QueryContext(ctx, statement(ctx, query))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	metrics := make([]Metric, 0)
 	for res.Next() {
 		var metric Metric
-		var device Device
-		err := res.Scan(&device.Id,
-			&device.Name,
+		err := res.Scan(&metric.DeviceId,
+			&metric.DeviceName,
 			&metric.Id,
 			&metric.Name,
+			&metric.SlaveId,
+			&metric.FunctionCode,
+			&metric.RegisterStart,
 			&metric.DataFormat,
 			&metric.ByteOrder,
-			&metric.Unit)
+			&metric.Unit,
+			&metric.RefreshRate,
+			&metric.Scale,
+			&metric.Offset,
+			&metric.EngineeringUnit)
 
 		if err != nil {
 			return nil, err
 		}
-
-		metrics[metric.Id] = &MetricWithData{
-			Metric: metric,
-			Data:   make([]*MetricData, 0),
-		}
-
-		if _, in := devices[device.Id]; !in {
-			devices[device.Id] = &DeviceWithMetrics{
-				Device:  device,
-				Metrics: make([]*MetricWithData, 0),
-			}
-		}
-		devices[device.Id].Metrics = append(devices[device.Id].Metrics, metrics[metric.Id])
+		metrics = append(metrics, metric)
+	}
+	if err := res.Err(); err != nil {
+		return nil, err
 	}
 
-	metricIds := ""
-	separator := ""
-	for _, metric := range metrics {
-		metricIds += separator + strconv.FormatInt(metric.Metric.Id, 10)
-		separator = ","
+	mappings, err := db.queryValueMappings(ctx, &Filter{})
+	if err != nil {
+		return nil, err
+	}
+	byId := make(map[int64]*Metric, len(metrics))
+	for i := range metrics {
+		byId[metrics[i].Id] = &metrics[i]
+	}
+	for _, mapping := range mappings {
+		if metric := byId[mapping.MetricId]; metric != nil {
+			metric.Mappings = append(metric.Mappings, mapping)
+		}
 	}
 
-	query = "SELECT id, metric_id, value, UNIX_TIMESTAMP(timestamp) FROM metrics_data"
-	query += " WHERE UNIX_TIMESTAMP(timestamp) > " + strconv.FormatInt(timerange.From.Unix(), 10) +
-		" AND UNIX_TIMESTAMP(timestamp) < " + strconv.FormatInt(timerange.To.Unix(), 10) +
-		" AND metric_id in (" + metricIds + ") ORDER BY timestamp ASC"
-	log.DefaultLogger.Info("QUERY " + query)
-	res.Close()
-	res, err = db.db.Query(query)
-	defer res.Close()
+	return metrics, nil
+}
 
+// loadDevices returns all the devices.
+func (db *Database) loadDevices(ctx context.Context) ([]Device, error) {
+	res, err := db.db.QueryContext(ctx, statement(ctx, "SELECT id, serial_id, name FROM devices ORDER BY id"))
 	if err != nil {
-		log.DefaultLogger.Error("QueryMetricsData", err)
 		return nil, err
 	}
+	defer res.Close()
 
+	devices := make([]Device, 0)
 	for res.Next() {
-		var d MetricData
-		var timestamp int64
-		err := res.Scan(&d.Id, &d.MetricId, &d.Value, &timestamp)
-		d.Timestamp = time.Unix(timestamp, 0)
-
-		if err != nil {
-			log.DefaultLogger.Error("QueryMetricsData", err)
+		var device Device
+		if err := res.Scan(&device.Id, &device.SerialId, &device.Name); err != nil {
 			return nil, err
 		}
-		mwd := metrics[d.MetricId]
-		mwd.Data = append(mwd.Data, &d)
+		devices = append(devices, device)
 	}
+	return devices, res.Err()
+}
 
-	// extract Metrics from map
-	data := make([]DeviceWithMetrics, 0, len(devices))
-	for _, d := range devices {
-		data = append(data, *d)
+// filterClause returns the WHERE clause restricting a query on metrics m joined with devices d to filter.
+func filterClause(filter *Filter) string {
+	if (filter.Entity == "devices" || filter.Entity == "metrics") && strings.TrimSpace(filter.Value) == "" {
+		// an empty list is a syntax error.
+		return " WHERE FALSE"
 	}
-	return data, nil
+	if filter.Entity == "devices" {
+		return " WHERE d.id in (" + filter.Value + ")"
+	} else if filter.Entity == "metrics" {
+		return " WHERE m.id in (" + filter.Value + ")"
+	}
+	return ""
+}
+
+func metricIdsCsv(metrics []Metric) string {
+	metricIds := ""
+	separator := ""
+	for _, metric := range metrics {
+		metricIds += separator + strconv.FormatInt(metric.Id, 10)
+		separator = ","
+	}
+	return metricIds
 }
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fakeMetricsData is a database/sql backend serving rows of metrics_data: each of metricIds has a
+// point every second, starting at start.
+type fakeMetricsData struct {
+	metricIds []int64
+	points    int
+	start     time.Time
+}
+
+var limitClause = regexp.MustCompile(`LIMIT (\d+)`)
+
+func (f *fakeMetricsData) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeMetricsData) Driver() driver.Driver                        { return nil }
+func (f *fakeMetricsData) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (f *fakeMetricsData) Close() error              { return nil }
+func (f *fakeMetricsData) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (f *fakeMetricsData) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if strings.HasPrefix(query, "SELECT s.metric_id") {
+		return &fakeRows{columns: make([]string, 8), count: len(f.metricIds), row: func(i int, dest []driver.Value) {
+			dest[0], dest[1] = f.metricIds[i], int64(f.points)
+			for j := 2; j < 8; j++ {
+				dest[j] = float64(0)
+			}
+		}}, nil
+	}
+	if strings.Contains(query, "COUNT(*)") {
+		return &fakeRows{columns: []string{"metric_id", "count"}, count: len(f.metricIds), row: func(i int, dest []driver.Value) {
+			dest[0], dest[1] = f.metricIds[i], int64(f.points)
+		}}, nil
+	}
+
+	count := f.points * len(f.metricIds)
+	if match := limitClause.FindStringSubmatch(query); match != nil {
+		if limit, _ := strconv.Atoi(match[1]); limit < count {
+			count = limit
+		}
+	}
+	if strings.HasPrefix(query, "SELECT metric_id, value FROM") {
+		return &fakeRows{columns: []string{"metric_id", "value"}, count: count, row: func(i int, dest []driver.Value) {
+			dest[0], dest[1] = f.metricIds[i*len(f.metricIds)/count], float64(i)
+		}}, nil
+	}
+	return &fakeRows{columns: []string{"metric_id", "value", "timestamp"}, count: count, row: func(i int, dest []driver.Value) {
+		dest[0] = f.metricIds[i%len(f.metricIds)]
+		dest[1] = float64(i)
+		dest[2] = f.start.Unix() + int64(i/len(f.metricIds))
+	}}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	count   int
+	row     func(i int, dest []driver.Value)
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == r.count {
+		return io.EOF
+	}
+	r.row(r.next, dest)
+	r.next++
+	return nil
+}
+
+// fakeDatabase returns a database backed by source, whose metadata is that of testMetadata.
+func fakeDatabase(source *fakeMetricsData) *Database {
+	return &Database{db: sql.OpenDB(source), open: true, metadata: testMetadata()}
+}
+
+func TestQueryMetricsDataRowLimit(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	db := fakeDatabase(&fakeMetricsData{metricIds: []int64{10, 11}, points: 50, start: start})
+	filter := &Filter{Entity: "devices", Value: "1"}
+	timeRange := backend.TimeRange{From: start, To: start.Add(time.Hour)}
+
+	type TestCase struct {
+		limit             int
+		expectedPoints    int
+		expectedTruncated bool
+	}
+
+	cases := []TestCase{
+		{limit: 0, expectedPoints: 50, expectedTruncated: false},
+		{limit: 100, expectedPoints: 50, expectedTruncated: false},
+		{limit: 30, expectedPoints: 15, expectedTruncated: true},
+	}
+
+	for _, tc := range cases {
+		db.RowLimit = tc.limit
+		devices, err := db.QueryMetricsData(context.Background(), filter, timeRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 1 || len(devices[0].Metrics) != 2 {
+			t.Fatalf("Expected a single device with 2 metrics, got %+v", devices)
+		}
+
+		for _, metric := range devices[0].Metrics {
+			if len(metric.Times) != tc.expectedPoints || len(metric.Values) != tc.expectedPoints {
+				t.Errorf("Expected %d points for metric %d with limit %d, got %d", tc.expectedPoints, metric.Metric.Id, tc.limit, len(metric.Values))
+			}
+			if metric.Truncated != tc.expectedTruncated {
+				t.Errorf("Expected truncated to be %v with limit %d", tc.expectedTruncated, tc.limit)
+			}
+			// the vectors are allocated once, at their final size or more.
+			if cap(metric.Values) < tc.expectedPoints || cap(metric.Values) > tc.limit && tc.limit > 0 {
+				t.Errorf("Unexpected capacity %d for %d points with limit %d", cap(metric.Values), tc.expectedPoints, tc.limit)
+			}
+			for i := 1; i < len(metric.Times); i++ {
+				if !metric.Times[i].After(metric.Times[i-1]) {
+					t.Fatalf("Points of metric %d are not sorted by time", metric.Metric.Id)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkQueryMetricsData(b *testing.B) {
+	for _, points := range []int{10000, 1000000} {
+		b.Run(strconv.Itoa(points), func(b *testing.B) {
+			start := time.Unix(1659028800, 0)
+			db := fakeDatabase(&fakeMetricsData{metricIds: []int64{10, 11, 20}, points: points / 3, start: start})
+			timeRange := backend.TimeRange{From: start, To: start.Add(24 * time.Hour)}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := db.QueryMetricsData(context.Background(), &Filter{}, timeRange); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryMetricsStatsRowLimit(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	db := fakeDatabase(&fakeMetricsData{metricIds: []int64{10, 11}, points: 50, start: start})
+	filter := &Filter{Entity: "devices", Value: "1"}
+	timeRange := backend.TimeRange{From: start, To: start.Add(time.Hour)}
+
+	type TestCase struct {
+		limit     int
+		truncated bool
+	}
+
+	cases := []TestCase{
+		{limit: 100, truncated: false},
+		{limit: 99, truncated: true},
+	}
+
+	for _, tc := range cases {
+		db.RowLimit = tc.limit
+		stats, err := db.QueryMetricsStats(context.Background(), filter, timeRange, []float64{50})
+		if err != nil {
+			t.Fatalf("Unexpected error for limit %d: %v", tc.limit, err)
+		}
+		if len(stats) != 2 {
+			t.Fatalf("Expected the stats of 2 metrics for limit %d, got %d", tc.limit, len(stats))
+		}
+		for _, s := range stats {
+			if s.Count != 50 || s.PercentilesTruncated != tc.truncated || (len(s.Percentiles) == 0) != tc.truncated {
+				t.Errorf("Unexpected stats for limit %d: %+v", tc.limit, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrorKind identifies the errors of the database package that callers may handle.
+type ErrorKind string
+
+const (
+	ErrorKindNotConnected  ErrorKind = "not_connected"
+	ErrorKindInvalidFilter ErrorKind = "invalid_filter"
+	// ErrorKindInvalidQuery is a query the plugin cannot run as sent.
+	ErrorKindInvalidQuery  ErrorKind = "invalid_query"
+	ErrorKindUnknownMetric ErrorKind = "unknown_metric"
+	ErrorKindTimeout       ErrorKind = "timeout"
+	ErrorKindTruncated     ErrorKind = "truncated"
+	// ErrorKindUnavailable is a database that cannot be reached or refuses the credentials.
+	ErrorKindUnavailable ErrorKind = "unavailable"
+	// ErrorKindRejected is a statement the database failed to execute.
+	ErrorKindRejected ErrorKind = "rejected"
+	// ErrorKindInternal is any other failure, which is a fault of the plugin.
+	ErrorKindInternal ErrorKind = "internal"
+)
+
+// ErrorSource tells whether an error is caused by the plugin, or by the database or the query it
+// was given (downstream).
+type ErrorSource string
+
+const (
+	ErrorSourcePlugin     ErrorSource = "plugin"
+	ErrorSourceDownstream ErrorSource = "downstream"
+)
+
+// Error is an error whose message is fit for users, the underlying error is only logged.
+// Errors of the same kind match each other with errors.Is.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+	Err     error
+}
+
+var (
+	ErrNotConnected  = &Error{Kind: ErrorKindNotConnected, Message: "not connected to any database"}
+	ErrInvalidFilter = &Error{Kind: ErrorKindInvalidFilter, Message: "invalid filter"}
+	ErrInvalidQuery  = &Error{Kind: ErrorKindInvalidQuery, Message: "invalid query"}
+	ErrUnknownMetric = &Error{Kind: ErrorKindUnknownMetric, Message: "unknown metric"}
+	ErrTimeout       = &Error{Kind: ErrorKindTimeout, Message: "the database did not answer in time, narrow the time range or the metrics of the query"}
+	ErrTruncated     = &Error{Kind: ErrorKindTruncated, Message: "the row limit of the datasource was reached, narrow the time range or the metrics of the query"}
+)
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind
+}
+
+// Source returns ErrorSourcePlugin for internal errors, ErrorSourceDownstream otherwise.
+func (e *Error) Source() ErrorSource {
+	if e.Kind == ErrorKindInternal {
+		return ErrorSourcePlugin
+	}
+	return ErrorSourceDownstream
+}
+
+// SourceOf returns the source of err, which is the plugin unless err is a database Error.
+func SourceOf(err error) ErrorSource {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Source()
+	}
+	return ErrorSourcePlugin
+}
+
+// invalidFilter returns an ErrInvalidFilter naming the offending filter.
+func invalidFilter(filter *Filter) *Error {
+	return &Error{Kind: ErrorKindInvalidFilter, Message: "invalid " + filter.Entity + " '" + filter.Value + "'"}
+}
+
+// unknownMetric returns an ErrUnknownMetric naming metricId.
+func unknownMetric(metricId int64) *Error {
+	return &Error{Kind: ErrorKindUnknownMetric, Message: "metric " + strconv.FormatInt(metricId, 10) + " does not exist"}
+}
+
+// classify returns err as an Error, telling users what went wrong without the driver details.
+func classify(err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+
+	var mysqlErr *mysql.MySQLError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Kind: ErrorKindTimeout, Message: ErrTimeout.Message, Err: err}
+	case errors.Is(err, context.Canceled):
+		return &Error{Kind: ErrorKindTimeout, Message: "the query was canceled", Err: err}
+	case errors.As(err, &mysqlErr):
+		// 1044 and 1045 deny access to the database, 1040 refuses more connections.
+		switch mysqlErr.Number {
+		case 1040, 1044, 1045:
+			return &Error{Kind: ErrorKindUnavailable, Message: "the database refused the connection, check the datasource settings", Err: err}
+		}
+		return &Error{Kind: ErrorKindRejected, Message: "the database rejected the query: " + mysqlErr.Message, Err: err}
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn), errors.As(err, &netErr):
+		return &Error{Kind: ErrorKindUnavailable, Message: "cannot reach the database, check the datasource settings", Err: err}
+	default:
+		return &Error{Kind: ErrorKindInternal, Message: "database query failed: " + err.Error(), Err: err}
+	}
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestClassify(t *testing.T) {
+	type TestCase struct {
+		err            error
+		expectedKind   ErrorKind
+		expectedSource ErrorSource
+	}
+
+	cases := []TestCase{
+		{err: fmt.Errorf("query: %w", context.DeadlineExceeded), expectedKind: ErrorKindTimeout, expectedSource: ErrorSourceDownstream},
+		{err: &mysql.MySQLError{Number: 1045, Message: "Access denied for user 'grafana'"}, expectedKind: ErrorKindUnavailable, expectedSource: ErrorSourceDownstream},
+		{err: &mysql.MySQLError{Number: 1146, Message: "Table 'modbus.metrics' doesn't exist"}, expectedKind: ErrorKindRejected, expectedSource: ErrorSourceDownstream},
+		{err: driver.ErrBadConn, expectedKind: ErrorKindUnavailable, expectedSource: ErrorSourceDownstream},
+		{err: errors.New("sql: Scan error on column index 2"), expectedKind: ErrorKindInternal, expectedSource: ErrorSourcePlugin},
+		{err: invalidFilter(&Filter{Entity: "metrics", Value: "a"}), expectedKind: ErrorKindInvalidFilter, expectedSource: ErrorSourceDownstream},
+	}
+
+	for _, tc := range cases {
+		err := classify(tc.err)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Fatalf("Expected an Error for %v, got %T", tc.err, err)
+		}
+		if e.Kind != tc.expectedKind || SourceOf(err) != tc.expectedSource {
+			t.Errorf("Expected %s from %s for %v, got %s from %s", tc.expectedKind, tc.expectedSource, tc.err, e.Kind, SourceOf(err))
+		}
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Expected %v to wrap %v", err, tc.err)
+		}
+	}
+
+	if classify(nil) != nil {
+		t.Errorf("Expected no error")
+	}
+	if SourceOf(errors.New("unknown transform")) != ErrorSourcePlugin {
+		t.Errorf("Expected errors of the plugin to come from the plugin")
+	}
+}
+
+func TestErrorMatchesKind(t *testing.T) {
+	err := fmt.Errorf("write: %w", unknownMetric(42))
+	if !errors.Is(err, ErrUnknownMetric) || errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("Expected %v to only match unknown metrics", err)
+	}
+	if err.Error() != "write: metric 42 does not exist" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
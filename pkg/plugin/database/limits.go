@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func (db *Database) QueryMetricLimits(ctx context.Context, filter *Filter) ([]MetricLimits, error) {
+	log.DefaultLogger.Info("QueryMetricLimits called")
+	ctx, observer := observe(ctx, "QueryMetricLimits")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	filter, err := db.resolveFilter(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+
+	query := "SELECT l.metric_id, l.high_high, l.high, l.low, l.low_low, l.deadband, l.delay FROM metric_limits l" +
+		" JOIN metrics m ON l.metric_id = m.id JOIN devices d ON m.device_id = d.id" + filterClause(filter)
+	res, err := db.db.QueryContext(ctx, statement(ctx, query))
+	if missingTable(err) {
+		// no limits were ever saved.
+		return make([]MetricLimits, 0), nil
+	}
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	limits := make([]MetricLimits, 0)
+	for res.Next() {
+		observer.rows++
+		var l MetricLimits
+		err := res.Scan(&l.MetricId, &l.HighHigh, &l.High, &l.Low, &l.LowLow, &l.Deadband, &l.Delay)
+		if err != nil {
+			return nil, observer.fail(err)
+		}
+		limits = append(limits, l)
+	}
+
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+	return limits, nil
+}
+
+// SaveMetricLimits creates or replaces the limits of limits.MetricId.
+func (db *Database) SaveMetricLimits(ctx context.Context, limits MetricLimits) error {
+	log.DefaultLogger.Info("SaveMetricLimits called")
+	ctx, observer := observe(ctx, "SaveMetricLimits")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+
+	_, err := db.db.ExecContext(ctx, statement(ctx, "INSERT INTO metric_limits (metric_id, high_high, high, low, low_low, deadband, delay)"+
+		" VALUES (?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE high_high = VALUES(high_high), high = VALUES(high),"+
+		" low = VALUES(low), low_low = VALUES(low_low), deadband = VALUES(deadband), delay = VALUES(delay)"),
+		limits.MetricId, limits.HighHigh, limits.High, limits.Low, limits.LowLow, limits.Deadband, limits.Delay)
+	if err != nil {
+		return observer.fail(err)
+	}
+	return nil
+}
+
+func (db *Database) DeleteMetricLimits(ctx context.Context, metricId int64) error {
+	log.DefaultLogger.Info("DeleteMetricLimits called")
+	ctx, observer := observe(ctx, "DeleteMetricLimits")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+
+	_, err := db.db.ExecContext(ctx, statement(ctx, "DELETE FROM metric_limits WHERE metric_id = ?"), metricId)
+	if err != nil {
+		return observer.fail(err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func (db *Database) QueryValueMappings(ctx context.Context, filter *Filter) ([]ValueMapping, error) {
+	log.DefaultLogger.Info("QueryValueMappings called")
+	ctx, observer := observe(ctx, "QueryValueMappings")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	filter, err := db.resolveFilter(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+
+	mappings, err := db.queryValueMappings(ctx, filter)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	return mappings, nil
+}
+
+// queryValueMappings returns the value mappings of the metrics matching filter, by metric, kind and
+// value, none if no mapping was ever saved.
+func (db *Database) queryValueMappings(ctx context.Context, filter *Filter) ([]ValueMapping, error) {
+	query := "SELECT v.metric_id, v.kind, v.value, v.text, v.color FROM metric_value_mappings v" +
+		" JOIN metrics m ON v.metric_id = m.id JOIN devices d ON m.device_id = d.id" + filterClause(filter) +
+		" ORDER BY v.metric_id, v.kind, v.value"
+	res, err := db.db.QueryContext(ctx, statement(ctx, query))
+	if missingTable(err) {
+		return make([]ValueMapping, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	mappings := make([]ValueMapping, 0)
+	for res.Next() {
+		var m ValueMapping
+		if err := res.Scan(&m.MetricId, &m.Kind, &m.Value, &m.Text, &m.Color); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+
+	return mappings, res.Err()
+}
+
+// SaveValueMappings replaces the value mappings of metricId by mappings.
+func (db *Database) SaveValueMappings(ctx context.Context, metricId int64, mappings []ValueMapping) error {
+	log.DefaultLogger.Info("SaveValueMappings called")
+	ctx, observer := observe(ctx, "SaveValueMappings")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return observer.fail(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statement(ctx, "DELETE FROM metric_value_mappings WHERE metric_id = ?"), metricId); err != nil {
+		return observer.fail(err)
+	}
+	for _, m := range mappings {
+		_, err := tx.ExecContext(ctx, statement(ctx, "INSERT INTO metric_value_mappings (metric_id, kind, value, text, color) VALUES (?, ?, ?, ?, ?)"),
+			metricId, m.Kind, m.Value, m.Text, m.Color)
+		if err != nil {
+			return observer.fail(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return observer.fail(err)
+	}
+
+	db.InvalidateMetadata()
+	return nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// metadataMinAge is the age under which the metadata is not reloaded when a query names an
+// unknown device or metric, so that bogus ids cannot hammer the database.
+const metadataMinAge = 10 * time.Second
+
+// metadata is a snapshot of the devices and metrics, so that data queries only touch metrics_data.
+// Snapshots are never modified once loaded.
+type metadata struct {
+	loaded  time.Time
+	devices []Device
+	metrics []Metric
+	assets  []Asset
+	// deviceIds, metricIds and assetIds index devices, metrics and assets.
+	deviceIds map[int64]bool
+	metricIds map[int64]bool
+	assetIds  map[int64]bool
+}
+
+// knows reports whether all ids of entity are in the snapshot.
+func (m *metadata) knows(entity string, ids map[int64]bool) bool {
+	known := m.metricIds
+	if entity == "devices" {
+		known = m.deviceIds
+	} else if entity == "assets" {
+		known = m.assetIds
+	}
+	for id := range ids {
+		if !known[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// RefreshMetadata reloads the devices and metrics served to queries.
+func (db *Database) RefreshMetadata(ctx context.Context) error {
+	log.DefaultLogger.Info("RefreshMetadata called")
+	ctx, observer := observe(ctx, "RefreshMetadata")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+
+	meta, err := db.loadMetadata(ctx)
+	if err != nil {
+		return observer.fail(err)
+	}
+	observer.rows = len(meta.devices) + len(meta.metrics)
+	return nil
+}
+
+// InvalidateMetadata drops the devices and metrics served to queries, so that they are reloaded
+// on next use.
+func (db *Database) InvalidateMetadata() {
+	db.metadataMu.Lock()
+	defer db.metadataMu.Unlock()
+
+	db.metadata = nil
+}
+
+// cachedMetadata returns the current metadata, loading it if needed.
+func (db *Database) cachedMetadata(ctx context.Context) (*metadata, error) {
+	db.metadataMu.Lock()
+	meta := db.metadata
+	db.metadataMu.Unlock()
+
+	if meta != nil {
+		return meta, nil
+	}
+	return db.loadMetadata(ctx)
+}
+
+func (db *Database) loadMetadata(ctx context.Context) (*metadata, error) {
+	devices, err := db.loadDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := db.loadMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.loadTags(ctx, devices, metrics); err != nil {
+		return nil, err
+	}
+	assets, err := db.loadAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &metadata{
+		loaded:    time.Now(),
+		devices:   devices,
+		metrics:   metrics,
+		assets:    assets,
+		deviceIds: make(map[int64]bool, len(devices)),
+		metricIds: make(map[int64]bool, len(metrics)),
+		assetIds:  make(map[int64]bool, len(assets)),
+	}
+	for _, device := range devices {
+		meta.deviceIds[device.Id] = true
+	}
+	for _, metric := range metrics {
+		meta.metricIds[metric.Id] = true
+	}
+	for _, asset := range assets {
+		meta.assetIds[asset.Id] = true
+	}
+
+	db.metadataMu.Lock()
+	db.metadata = meta
+	db.metadataMu.Unlock()
+
+	return meta, nil
+}
+
+// filterIds returns the ids filter restricts its entity to, or nil if it does not filter.
+func filterIds(filter *Filter) (map[int64]bool, error) {
+	if filter.Entity != "devices" && filter.Entity != "metrics" && filter.Entity != "assets" {
+		return nil, nil
+	}
+
+	ids := make(map[int64]bool)
+	for _, id := range strings.Split(filter.Value, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		value, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, invalidFilter(filter)
+		}
+		ids[value] = true
+	}
+	return ids, nil
+}
+
+// resolveFilter returns filter, or if it filters on tags or assets the filter on the metrics
+// having them, which filterClause can express.
+func (db *Database) resolveFilter(ctx context.Context, filter *Filter) (*Filter, error) {
+	if filter.Entity != "tags" && filter.Entity != "assets" {
+		return filter, nil
+	}
+
+	metrics, err := db.queryFilteredMetrics(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Entity: "metrics", Value: metricIdsCsv(metrics)}, nil
+}
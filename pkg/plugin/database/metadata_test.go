@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func testMetadata() *metadata {
+	return &metadata{
+		loaded:    time.Now(),
+		devices:   []Device{{Id: 1, Name: "inverter"}, {Id: 2, Name: "meter"}},
+		metrics:   []Metric{{Id: 10, DeviceId: 1}, {Id: 11, DeviceId: 1}, {Id: 20, DeviceId: 2}},
+		deviceIds: map[int64]bool{1: true, 2: true},
+		metricIds: map[int64]bool{10: true, 11: true, 20: true},
+	}
+}
+
+func TestQueryFilteredMetricsFromMetadata(t *testing.T) {
+	// the snapshot is fresh and knows every id, so the database is never touched.
+	db := &Database{metadata: testMetadata()}
+
+	type TestCase struct {
+		filter      Filter
+		expectedIds []int64
+	}
+
+	cases := []TestCase{
+		{filter: Filter{}, expectedIds: []int64{10, 11, 20}},
+		{filter: Filter{Entity: "devices", Value: "1"}, expectedIds: []int64{10, 11}},
+		{filter: Filter{Entity: "metrics", Value: "20, 10"}, expectedIds: []int64{10, 20}},
+		{filter: Filter{Entity: "metrics", Value: ""}, expectedIds: []int64{}},
+	}
+
+	for _, tc := range cases {
+		metrics, err := db.queryFilteredMetrics(context.Background(), &tc.filter)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", tc.filter, err)
+		}
+		if len(metrics) != len(tc.expectedIds) {
+			t.Errorf("Expected %d metrics for %v, got %d", len(tc.expectedIds), tc.filter, len(metrics))
+			continue
+		}
+		for i, id := range tc.expectedIds {
+			if metrics[i].Id != id {
+				t.Errorf("Metric mismatch for %v at %d: Expected %d, got %d", tc.filter, i, id, metrics[i].Id)
+			}
+		}
+	}
+
+	if _, err := db.queryFilteredMetrics(context.Background(), &Filter{Entity: "metrics", Value: "1) OR (1=1"}); err == nil {
+		t.Errorf("Expected an error for invalid ids")
+	}
+}
+
+func TestMetadataKnows(t *testing.T) {
+	meta := testMetadata()
+	if !meta.knows("devices", map[int64]bool{1: true, 2: true}) || !meta.knows("", nil) {
+		t.Errorf("Expected known ids to be known")
+	}
+	if meta.knows("metrics", map[int64]bool{10: true, 12: true}) || meta.knows("devices", map[int64]bool{10: true}) {
+		t.Errorf("Expected unknown ids not to be known")
+	}
+}
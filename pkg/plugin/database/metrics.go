@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+var (
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "modbusrtu",
+		Subsystem: "database",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of the database methods of the plugin.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"method"})
+
+	rowsScanned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "modbusrtu",
+		Subsystem: "database",
+		Name:      "rows_scanned_total",
+		Help:      "Number of rows scanned by the database methods of the plugin.",
+	}, []string{"method"})
+)
+
+func init() {
+	prometheus.MustRegister(queryDuration, rowsScanned)
+}
+
+// queryObserver records the duration of a database method and the rows it scans, as metrics and
+// as a span.
+type queryObserver struct {
+	method string
+	start  time.Time
+	span   trace.Span
+	rows   int
+}
+
+// observe starts observing method, until done is called. The returned context holds the span of
+// the method.
+func observe(ctx context.Context, method string) (context.Context, *queryObserver) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "mysql")))
+	return ctx, &queryObserver{method: method, start: time.Now(), span: span}
+}
+
+// fail logs err, records it on the span of the method and returns it as an Error.
+func (o *queryObserver) fail(err error) error {
+	log.DefaultLogger.Error(o.method, err)
+	o.span.RecordError(err)
+	o.span.SetStatus(codes.Error, err.Error())
+	return classify(err)
+}
+
+func (o *queryObserver) done() {
+	queryDuration.WithLabelValues(o.method).Observe(time.Since(o.start).Seconds())
+	if o.rows > 0 {
+		rowsScanned.WithLabelValues(o.method).Add(float64(o.rows))
+	}
+	o.span.SetAttributes(attribute.Int("db.rows", o.rows))
+	o.span.End()
+}
+
+// StatsCollector returns a collector of the connection pool statistics of db, labelled with name.
+func (db *Database) StatsCollector(name string) prometheus.Collector {
+	return collectors.NewDBStatsCollector(db.db, name)
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueryObserver(t *testing.T) {
+	// other tests query the database too.
+	rowsScanned.Reset()
+	queryDuration.Reset()
+
+	_, observer := observe(context.Background(), "TestQueryObserver")
+	observer.rows += 3
+	observer.done()
+	_, observer = observe(context.Background(), "TestQueryObserver")
+	observer.done()
+
+	expected := `
+# HELP modbusrtu_database_rows_scanned_total Number of rows scanned by the database methods of the plugin.
+# TYPE modbusrtu_database_rows_scanned_total counter
+modbusrtu_database_rows_scanned_total{method="TestQueryObserver"} 3
+`
+	if err := testutil.CollectAndCompare(rowsScanned, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	if count := testutil.CollectAndCount(queryDuration, "modbusrtu_database_query_duration_seconds"); count != 1 {
+		t.Errorf("Expected a single duration series, got %d", count)
+	}
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// RawKind is the Go type of the values of a RawColumn.
+type RawKind string
+
+const (
+	RawKindInt    RawKind = "int"
+	RawKindUint   RawKind = "uint"
+	RawKindFloat  RawKind = "float"
+	RawKindTime   RawKind = "time"
+	RawKindString RawKind = "string"
+)
+
+// RawColumn is a column of a raw SQL query. Its Values are nil for NULL, or else all of the Go type
+// of Kind: int64, uint64, float64, time.Time or string.
+type RawColumn struct {
+	Name   string
+	Kind   RawKind
+	Values []interface{}
+}
+
+// RawResult holds the columns returned by a raw SQL query. Truncated is set when the row limit was
+// reached before its last row.
+type RawResult struct {
+	Columns   []RawColumn
+	Truncated bool
+}
+
+var (
+	// readStatement matches the statements QueryRaw runs: a SELECT, possibly parenthesized or
+	// preceded by common table expressions.
+	readStatement = regexp.MustCompile(`(?i)^[\s(]*(SELECT|WITH)\b`)
+	// unsafeStatement matches what QueryRaw refuses to screen: comments, which can hide keywords
+	// or hold MySQL specific code, and INTO, which writes variables or files. Both are refused
+	// even within string literals.
+	unsafeStatement = regexp.MustCompile(`(?i)/\*|--|#|\bINTO\b`)
+)
+
+// rowLimit returns the number of rows a single query may return.
+func (db *Database) rowLimit() int {
+	if db.RowLimit <= 0 {
+		return DefaultRowLimit
+	}
+	return db.RowLimit
+}
+
+// QueryRaw runs query, a single SELECT statement, in a read-only transaction. At most RowLimit
+// rows are returned, the result is Truncated beyond. A read-only transaction does not keep a
+// SELECT from writing files, so the user of the datasource must not have the FILE privilege.
+//
+// Beyond writes, nothing is screened: query reads whatever the grants of the user of the
+// datasource allow, other schemas included, and may call any function, such as SLEEP or
+// BENCHMARK to hold a connection until the query is canceled. That user should only be granted
+// SELECT on the schema of exprom-modbus-server and the tables of the plugin.
+func (db *Database) QueryRaw(ctx context.Context, query string) (*RawResult, error) {
+	log.DefaultLogger.Info("QueryRaw called")
+	ctx, observer := observe(ctx, "QueryRaw")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	if !readStatement.MatchString(query) || unsafeStatement.MatchString(query) {
+		return nil, &Error{Kind: ErrorKindInvalidQuery, Message: "only SELECT statements without comments or INTO can be run"}
+	}
+
+	// the transaction keeps the statement from writing, even through the functions it calls.
+	tx, err := db.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.QueryContext(ctx, statement(ctx, query))
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	types, err := res.ColumnTypes()
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	result := &RawResult{Columns: make([]RawColumn, len(types))}
+	for i, t := range types {
+		result.Columns[i] = RawColumn{Name: t.Name(), Kind: rawKind(t.DatabaseTypeName()), Values: make([]interface{}, 0)}
+	}
+
+	limit := db.rowLimit()
+	values := make([]interface{}, len(types))
+	dest := make([]interface{}, len(types))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for res.Next() {
+		if observer.rows == limit {
+			result.Truncated = true
+			break
+		}
+		observer.rows++
+		if err := res.Scan(dest...); err != nil {
+			return nil, observer.fail(err)
+		}
+		for i := range result.Columns {
+			column := &result.Columns[i]
+			value, err := rawValue(column.Kind, values[i])
+			if err != nil {
+				return nil, observer.fail(fmt.Errorf("column %s: %w", column.Name, err))
+			}
+			column.Values = append(column.Values, value)
+		}
+	}
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+	return result, nil
+}
+
+// rawKind returns the kind of the values of a column of the MySQL type typeName.
+func rawKind(typeName string) RawKind {
+	switch typeName {
+	case "UNSIGNED BIGINT":
+		// the only integers that may not fit an int64.
+		return RawKindUint
+	}
+	switch strings.TrimPrefix(typeName, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		return RawKindInt
+	case "DECIMAL", "FLOAT", "DOUBLE":
+		return RawKindFloat
+	case "DATE", "DATETIME", "TIMESTAMP":
+		return RawKindTime
+	default:
+		return RawKindString
+	}
+}
+
+// rawValue converts value, as scanned from a column of kind, to the Go type of kind. The text
+// protocol returns most values as bytes.
+func rawValue(kind RawKind, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case RawKindInt:
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case []byte:
+			return strconv.ParseInt(string(v), 10, 64)
+		}
+	case RawKindUint:
+		switch v := value.(type) {
+		case uint64:
+			return v, nil
+		case int64:
+			if v >= 0 {
+				return uint64(v), nil
+			}
+		case []byte:
+			return strconv.ParseUint(string(v), 10, 64)
+		}
+	case RawKindFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case []byte:
+			return strconv.ParseFloat(string(v), 64)
+		}
+	case RawKindTime:
+		if v, ok := value.(time.Time); ok {
+			return v, nil
+		}
+	case RawKindString:
+		if v, ok := value.([]byte); ok {
+			return string(v), nil
+		}
+		return fmt.Sprint(value), nil
+	}
+	return nil, fmt.Errorf("unexpected %s value %v", kind, value)
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeRawSQL is a database/sql backend answering any statement with rows of an id, a DECIMAL value,
+// a timestamp, a nullable name and a total beyond the range of int64, as bytes like the text
+// protocol of MySQL.
+type fakeRawSQL struct {
+	rows       int
+	readOnly   bool
+	rolledBack bool
+}
+
+func (f *fakeRawSQL) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeRawSQL) Driver() driver.Driver                        { return nil }
+func (f *fakeRawSQL) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (f *fakeRawSQL) Close() error              { return nil }
+func (f *fakeRawSQL) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+func (f *fakeRawSQL) Commit() error             { return nil }
+func (f *fakeRawSQL) Rollback() error           { f.rolledBack = true; return nil }
+
+func (f *fakeRawSQL) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	f.readOnly = opts.ReadOnly
+	return f, nil
+}
+
+func (f *fakeRawSQL) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	start := time.Unix(1659028800, 0)
+	return &fakeTypedRows{
+		fakeRows: fakeRows{columns: []string{"id", "value", "time", "name", "total"}, count: f.rows, row: func(i int, dest []driver.Value) {
+			dest[0] = []byte("1")
+			dest[1] = []byte("2.5")
+			dest[2] = start.Add(time.Duration(i) * time.Second)
+			dest[3] = nil
+			if i%2 == 0 {
+				dest[3] = []byte("pump")
+			}
+			dest[4] = []byte("18446744073709551615")
+		}},
+		types: []string{"UNSIGNED INT", "DECIMAL", "DATETIME", "VARCHAR", "UNSIGNED BIGINT"},
+	}, nil
+}
+
+type fakeTypedRows struct {
+	fakeRows
+	types []string
+}
+
+func (r *fakeTypedRows) ColumnTypeDatabaseTypeName(index int) string { return r.types[index] }
+
+func TestQueryRaw(t *testing.T) {
+	source := &fakeRawSQL{rows: 5}
+	db := &Database{db: sql.OpenDB(source), open: true, RowLimit: 3}
+
+	result, err := db.QueryRaw(context.Background(), "SELECT id, value, time, name, total FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !source.readOnly || !source.rolledBack {
+		t.Errorf("Expected a read-only transaction, rolled back")
+	}
+	if !result.Truncated || len(result.Columns) != 5 || len(result.Columns[0].Values) != 3 {
+		t.Fatalf("Expected 3 rows of 5 columns, truncated, got %+v", result)
+	}
+
+	expectedKinds := []RawKind{RawKindInt, RawKindFloat, RawKindTime, RawKindString, RawKindUint}
+	for i, column := range result.Columns {
+		if column.Kind != expectedKinds[i] {
+			t.Errorf("Expected column %s to be %s, got %s", column.Name, expectedKinds[i], column.Kind)
+		}
+	}
+	if result.Columns[0].Values[0] != int64(1) || result.Columns[1].Values[0] != 2.5 {
+		t.Errorf("Unexpected values %v and %v", result.Columns[0].Values[0], result.Columns[1].Values[0])
+	}
+	if result.Columns[3].Values[0] != "pump" || result.Columns[3].Values[1] != nil {
+		t.Errorf("Unexpected names %v", result.Columns[3].Values)
+	}
+	if result.Columns[4].Values[0] != uint64(math.MaxUint64) {
+		t.Errorf("Unexpected total %v", result.Columns[4].Values[0])
+	}
+}
+
+func TestQueryRawRejectsWrites(t *testing.T) {
+	db := &Database{db: sql.OpenDB(&fakeRawSQL{}), open: true}
+
+	for _, query := range []string{
+		"DELETE FROM metrics_data",
+		"/* SELECT */ DROP TABLE devices",
+		"SELECT * FROM metrics_data INTO OUTFILE '/tmp/data'",
+		"SELECT * FROM metrics_data INTO/**/OUTFILE '/tmp/data'",
+		"SELECT 1 INTO @x",
+		"SELECT /*!50000 1 */",
+		"SELECT 1 -- comment",
+		"SELECT 1 # comment",
+	} {
+		if _, err := db.QueryRaw(context.Background(), query); !errors.Is(err, &Error{Kind: ErrorKindInvalidQuery}) {
+			t.Errorf("Expected %q to be rejected, got %v", query, err)
+		}
+	}
+
+	for _, query := range []string{"select 1", " (SELECT 1) UNION (SELECT 2)", "WITH t AS (SELECT 1) SELECT * FROM t"} {
+		if _, err := db.QueryRaw(context.Background(), query); err != nil {
+			t.Errorf("Unexpected error for %q: %v", query, err)
+		}
+	}
+}
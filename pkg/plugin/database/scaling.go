@@ -0,0 +1,49 @@
+package database
+
+import (
+	"context"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// SaveMetricScaling creates or replaces the scaling of scaling.MetricId.
+func (db *Database) SaveMetricScaling(ctx context.Context, scaling MetricScaling) error {
+	log.DefaultLogger.Info("SaveMetricScaling called")
+	ctx, observer := observe(ctx, "SaveMetricScaling")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+
+	_, err := db.db.ExecContext(ctx, statement(ctx, "INSERT INTO metric_scaling (metric_id, scale, value_offset, unit) VALUES (?, ?, ?, ?)"+
+		" ON DUPLICATE KEY UPDATE scale = VALUES(scale), value_offset = VALUES(value_offset), unit = VALUES(unit)"),
+		scaling.MetricId, scaling.Scale, scaling.Offset, scaling.Unit)
+	if err != nil {
+		return observer.fail(err)
+	}
+
+	db.InvalidateMetadata()
+	return nil
+}
+
+func (db *Database) DeleteMetricScaling(ctx context.Context, metricId int64) error {
+	log.DefaultLogger.Info("DeleteMetricScaling called")
+	ctx, observer := observe(ctx, "DeleteMetricScaling")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+
+	_, err := db.db.ExecContext(ctx, statement(ctx, "DELETE FROM metric_scaling WHERE metric_id = ?"), metricId)
+	if err != nil {
+		return observer.fail(err)
+	}
+
+	db.InvalidateMetadata()
+	return nil
+}
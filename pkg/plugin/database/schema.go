@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// The following tables belong to this plugin rather than to exprom-modbus-server, so they are
+// created by the first change written to them. Until then reads find them missing and treat them
+// as empty, which keeps datasources whose user may only read working.
+var schema = []string{
+	"CREATE TABLE IF NOT EXISTS metric_limits (" +
+		" metric_id BIGINT NOT NULL PRIMARY KEY," +
+		" high_high DOUBLE NULL," +
+		" high DOUBLE NULL," +
+		" low DOUBLE NULL," +
+		" low_low DOUBLE NULL," +
+		" deadband DOUBLE NOT NULL DEFAULT 0," +
+		" delay INT NOT NULL DEFAULT 0)",
+	"CREATE TABLE IF NOT EXISTS device_transports (" +
+		" device_id BIGINT NOT NULL PRIMARY KEY," +
+		" port VARCHAR(255) NOT NULL," +
+		" baud_rate INT NOT NULL DEFAULT 9600," +
+		" data_bits INT NOT NULL DEFAULT 8," +
+		" parity CHAR(1) NOT NULL DEFAULT 'N'," +
+		" stop_bits INT NOT NULL DEFAULT 1," +
+		" timeout INT NOT NULL DEFAULT 1000)",
+	"CREATE TABLE IF NOT EXISTS metric_scaling (" +
+		" metric_id BIGINT NOT NULL PRIMARY KEY," +
+		" scale DOUBLE NOT NULL DEFAULT 1," +
+		" value_offset DOUBLE NOT NULL DEFAULT 0," +
+		" unit VARCHAR(32) NOT NULL DEFAULT '')",
+	"CREATE TABLE IF NOT EXISTS metric_value_mappings (" +
+		" metric_id BIGINT NOT NULL," +
+		" kind VARCHAR(8) NOT NULL," +
+		" value BIGINT NOT NULL," +
+		" text VARCHAR(255) NOT NULL," +
+		" color VARCHAR(32) NOT NULL DEFAULT ''," +
+		" PRIMARY KEY (metric_id, kind, value))",
+	"CREATE TABLE IF NOT EXISTS device_tags (" +
+		" device_id BIGINT NOT NULL," +
+		" tag_key VARCHAR(64) NOT NULL," +
+		" tag_value VARCHAR(255) NOT NULL," +
+		" PRIMARY KEY (device_id, tag_key))",
+	"CREATE TABLE IF NOT EXISTS metric_tags (" +
+		" metric_id BIGINT NOT NULL," +
+		" tag_key VARCHAR(64) NOT NULL," +
+		" tag_value VARCHAR(255) NOT NULL," +
+		" PRIMARY KEY (metric_id, tag_key))",
+	"CREATE TABLE IF NOT EXISTS assets (" +
+		" id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY," +
+		" parent_id BIGINT NULL," +
+		" name VARCHAR(255) NOT NULL," +
+		" kind VARCHAR(32) NOT NULL DEFAULT '')",
+	"CREATE TABLE IF NOT EXISTS asset_devices (" +
+		" device_id BIGINT NOT NULL PRIMARY KEY," +
+		" asset_id BIGINT NOT NULL)",
+}
+
+// ensureSchema creates the tables of the plugin unless it already did. A failure, such as the
+// database being down, is retried by the next call.
+func (db *Database) ensureSchema() error {
+	db.schemaMu.Lock()
+	defer db.schemaMu.Unlock()
+	if db.schemaReady {
+		return nil
+	}
+
+	for _, statement := range schema {
+		if _, err := db.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	db.schemaReady = true
+	return nil
+}
+
+// missingTable tells whether err is the failure of a statement using a table that does not exist
+// (MySQL error 1146), such as a table of the schema that was never written to.
+func missingTable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1146
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// fakeSchema is a database/sql backend running the statements of the schema, the first failures
+// of which fail as if the database were down.
+type fakeSchema struct {
+	failures   int
+	statements int
+}
+
+func (f *fakeSchema) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeSchema) Driver() driver.Driver                        { return nil }
+func (f *fakeSchema) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (f *fakeSchema) Close() error              { return nil }
+func (f *fakeSchema) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (f *fakeSchema) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("connection refused")
+	}
+	f.statements++
+	return driver.RowsAffected(0), nil
+}
+
+func TestEnsureSchemaRetries(t *testing.T) {
+	source := &fakeSchema{failures: 1}
+	db := &Database{db: sql.OpenDB(source), open: true}
+
+	if err := db.ensureSchema(); err == nil {
+		t.Fatal("Expected the failure of the database")
+	}
+	if err := db.ensureSchema(); err != nil {
+		t.Fatalf("Expected the schema to be created once the database is back, got %v", err)
+	}
+	if err := db.ensureSchema(); err != nil || source.statements != len(schema) {
+		t.Errorf("Expected the schema to be created once, got %d statements and %v", source.statements, err)
+	}
+}
+
+// fakeTables is a database/sql backend holding a device and its metric, whose missing tables fail
+// as in MySQL and whose other tables are empty. Writes are denied, as to a user who may only read.
+type fakeTables struct {
+	missing []string
+}
+
+func (f *fakeTables) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeTables) Driver() driver.Driver                        { return nil }
+func (f *fakeTables) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (f *fakeTables) Close() error              { return nil }
+func (f *fakeTables) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (f *fakeTables) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, &mysql.MySQLError{Number: 1142, Message: "command denied"}
+}
+
+func (f *fakeTables) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	for _, table := range f.missing {
+		if regexp.MustCompile(`(?i)\b` + table + `\b`).MatchString(query) {
+			return nil, &mysql.MySQLError{Number: 1146, Message: "Table 'exprom." + table + "' doesn't exist"}
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(strings.ToLower(query), "select d.id, d.name, m.id"):
+		return &fakeRows{columns: make([]string, 14), count: 1, row: func(_ int, dest []driver.Value) {
+			copy(dest, []driver.Value{int64(1), "inverter", int64(10), "power", int64(1), int64(3), int64(0),
+				"float32", "ABCD", "W", int64(10), float64(1), float64(0), ""})
+		}}, nil
+	case strings.HasPrefix(query, "SELECT id, serial_id, name FROM devices"):
+		return &fakeRows{columns: make([]string, 3), count: 1, row: func(_ int, dest []driver.Value) {
+			copy(dest, []driver.Value{int64(1), "SN1", "inverter"})
+		}}, nil
+	default:
+		return &fakeRows{}, nil
+	}
+}
+
+func TestLoadMetricsWithoutScaling(t *testing.T) {
+	db := &Database{db: sql.OpenDB(&fakeTables{missing: []string{"metric_scaling"}}), open: true}
+
+	metrics, err := db.loadMetrics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Scale != 1 || metrics[0].Offset != 0 {
+		t.Errorf("Expected the metric without scaling, got %+v", metrics)
+	}
+}
+
+func TestQueryValueMappingsWithoutTable(t *testing.T) {
+	db := &Database{db: sql.OpenDB(&fakeTables{missing: []string{"metric_value_mappings"}}), open: true, metadata: testMetadata()}
+
+	mappings, err := db.QueryValueMappings(context.Background(), &Filter{})
+	if err != nil || len(mappings) != 0 {
+		t.Errorf("Expected no mapping, got %v and %v", mappings, err)
+	}
+}
+
+func TestQueryMetricLimitsWithoutTable(t *testing.T) {
+	db := &Database{db: sql.OpenDB(&fakeTables{missing: []string{"metric_limits"}}), open: true, metadata: testMetadata()}
+
+	limits, err := db.QueryMetricLimits(context.Background(), &Filter{})
+	if err != nil || len(limits) != 0 {
+		t.Errorf("Expected no limits, got %v and %v", limits, err)
+	}
+}
+
+func TestQueryDeviceTransportsWithoutTable(t *testing.T) {
+	db := &Database{db: sql.OpenDB(&fakeTables{missing: []string{"device_transports"}}), open: true}
+
+	if transport, err := db.QueryDeviceTransport(context.Background(), 1); err != nil || transport != nil {
+		t.Errorf("Expected no transport, got %v and %v", transport, err)
+	}
+	if transports, err := db.QueryDeviceTransports(context.Background()); err != nil || len(transports) != 0 {
+		t.Errorf("Expected no transports, got %v and %v", transports, err)
+	}
+}
+
+func TestQueryTagSetsWithoutTables(t *testing.T) {
+	db := &Database{db: sql.OpenDB(&fakeTables{missing: []string{"device_tags", "metric_tags"}}), open: true}
+
+	if sets, err := db.QueryTagSets(context.Background()); err != nil || len(sets) != 0 {
+		t.Errorf("Expected no tags, got %v and %v", sets, err)
+	}
+}
+
+func TestQueryTagKeysWithoutTables(t *testing.T) {
+	db := &Database{db: sql.OpenDB(&fakeTables{missing: []string{"device_tags", "metric_tags"}}), open: true}
+
+	if keys, err := db.QueryTagKeys(context.Background()); err != nil || len(keys) != len(tagColumns) {
+		t.Errorf("Expected the built-in tags, got %v and %v", keys, err)
+	}
+	if values, err := db.QueryTagValues(context.Background(), "site"); err != nil || len(values) != 0 {
+		t.Errorf("Expected no values, got %v and %v", values, err)
+	}
+}
+
+func TestLoadMetadataWithoutTables(t *testing.T) {
+	missing := []string{"metric_scaling", "metric_value_mappings", "device_tags", "metric_tags", "assets", "asset_devices"}
+	db := &Database{db: sql.OpenDB(&fakeTables{missing: missing}), open: true}
+
+	meta, err := db.loadMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.devices) != 1 || len(meta.metrics) != 1 || len(meta.assets) != 0 {
+		t.Errorf("Expected the device and its metric without assets, got %+v", meta)
+	}
+}
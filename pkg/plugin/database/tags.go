@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// tagKey matches the keys of custom tags, which are frame labels.
+var tagKey = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedTagKeys are the labels of metric frames, which custom tags cannot shadow, on top of the
+// tagColumns.
+var reservedTagKeys = map[string]bool{"device_id": true, "metric": true, "metric_id": true}
+
+// TagSet holds the custom tags of a device (Entity "devices") or a metric (Entity "metrics").
+type TagSet struct {
+	Entity string            `json:"entity"`
+	Id     int64             `json:"id"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// tagTables are the table and id column of the tags of each entity.
+var tagTables = map[string][2]string{
+	"devices": {"device_tags", "device_id"},
+	"metrics": {"metric_tags", "metric_id"},
+}
+
+// QueryTagSets returns the custom tags of all the devices and metrics that have some.
+func (db *Database) QueryTagSets(ctx context.Context) ([]TagSet, error) {
+	log.DefaultLogger.Info("QueryTagSets called")
+	ctx, observer := observe(ctx, "QueryTagSets")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	sets := make([]TagSet, 0)
+	for _, entity := range []string{"devices", "metrics"} {
+		tags, err := db.queryTags(ctx, entity)
+		if err != nil {
+			return nil, observer.fail(err)
+		}
+		ids := make([]int64, 0, len(tags))
+		for id := range tags {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			observer.rows += len(tags[id])
+			sets = append(sets, TagSet{Entity: entity, Id: id, Tags: tags[id]})
+		}
+	}
+	return sets, nil
+}
+
+// queryTags returns the custom tags of entity, by id, none if no tag was ever saved.
+func (db *Database) queryTags(ctx context.Context, entity string) (map[int64]map[string]string, error) {
+	tags := make(map[int64]map[string]string)
+	table := tagTables[entity]
+	res, err := db.db.QueryContext(ctx, statement(ctx, "SELECT "+table[1]+", tag_key, tag_value FROM "+table[0]))
+	if missingTable(err) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var id int64
+		var key, value string
+		if err := res.Scan(&id, &key, &value); err != nil {
+			return nil, err
+		}
+		if tags[id] == nil {
+			tags[id] = make(map[string]string)
+		}
+		tags[id][key] = value
+	}
+	return tags, res.Err()
+}
+
+// SaveTags replaces the custom tags of the device or metric of set.
+func (db *Database) SaveTags(ctx context.Context, set TagSet) error {
+	log.DefaultLogger.Info("SaveTags called")
+	ctx, observer := observe(ctx, "SaveTags")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+
+	table, ok := tagTables[set.Entity]
+	if !ok {
+		return &Error{Kind: ErrorKindInvalidFilter, Message: "unknown tag entity '" + set.Entity + "'"}
+	}
+	for key := range set.Tags {
+		if err := checkTagKey(key); err != nil {
+			return err
+		}
+		if _, builtin := tagColumns[key]; builtin || reservedTagKeys[key] {
+			return &Error{Kind: ErrorKindInvalidFilter, Message: "the tag '" + key + "' is reserved"}
+		}
+	}
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return observer.fail(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statement(ctx, "DELETE FROM "+table[0]+" WHERE "+table[1]+" = ?"), set.Id); err != nil {
+		return observer.fail(err)
+	}
+	for key, value := range set.Tags {
+		_, err := tx.ExecContext(ctx, statement(ctx, "INSERT INTO "+table[0]+" ("+table[1]+", tag_key, tag_value) VALUES (?, ?, ?)"),
+			set.Id, key, value)
+		if err != nil {
+			return observer.fail(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return observer.fail(err)
+	}
+
+	db.InvalidateMetadata()
+	return nil
+}
+
+// loadTags sets the custom tags of devices and metrics, those of a metric being the tags of its
+// device overridden by its own.
+func (db *Database) loadTags(ctx context.Context, devices []Device, metrics []Metric) error {
+	deviceTags, err := db.queryTags(ctx, "devices")
+	if err != nil {
+		return err
+	}
+	metricTags, err := db.queryTags(ctx, "metrics")
+	if err != nil {
+		return err
+	}
+
+	for i := range devices {
+		devices[i].Tags = deviceTags[devices[i].Id]
+	}
+	for i := range metrics {
+		metric := &metrics[i]
+		if len(deviceTags[metric.DeviceId]) == 0 && len(metricTags[metric.Id]) == 0 {
+			continue
+		}
+		metric.Tags = make(map[string]string)
+		for key, value := range deviceTags[metric.DeviceId] {
+			metric.Tags[key] = value
+		}
+		for key, value := range metricTags[metric.Id] {
+			metric.Tags[key] = value
+		}
+	}
+	return nil
+}
+
+// hasTags reports whether tags holds all of wanted.
+func hasTags(tags map[string]string, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if v, ok := tags[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func checkTagKey(key string) error {
+	if !tagKey.MatchString(key) {
+		return &Error{Kind: ErrorKindInvalidFilter, Message: "invalid tag '" + key + "'"}
+	}
+	return nil
+}
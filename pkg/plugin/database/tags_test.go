@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQueryFilteredMetricsByTags(t *testing.T) {
+	meta := testMetadata()
+	meta.metrics[0].Tags = map[string]string{"site": "plantA", "line": "1"}
+	meta.metrics[1].Tags = map[string]string{"site": "plantA", "line": "2"}
+	meta.metrics[2].Tags = map[string]string{"site": "plantB"}
+	db := &Database{metadata: meta}
+
+	metrics, err := db.queryFilteredMetrics(context.Background(), &Filter{Entity: "tags", Tags: map[string]string{"site": "plantA"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 2 || metrics[0].Id != 10 || metrics[1].Id != 11 {
+		t.Errorf("Expected metrics 10 and 11, got %+v", metrics)
+	}
+
+	resolved, err := db.resolveFilter(context.Background(), &Filter{Entity: "tags", Tags: map[string]string{"site": "plantA", "line": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Entity != "metrics" || resolved.Value != "11" {
+		t.Errorf("Expected the filter on metric 11, got %+v", resolved)
+	}
+}
+
+func TestSaveTagsRejectsReservedKeys(t *testing.T) {
+	db := &Database{open: true}
+	for _, key := range []string{"device", "metric_id", "bad key"} {
+		err := db.SaveTags(context.Background(), TagSet{Entity: "devices", Id: 1, Tags: map[string]string{key: "x"}})
+		if !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("Expected an invalid filter for the key %q, got %v", key, err)
+		}
+	}
+}
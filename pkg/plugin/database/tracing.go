@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"regexp"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	quotedLiteral  = regexp.MustCompile(`'(?:[^'\\]|\\.)*'`)
+	numericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	literalList    = regexp.MustCompile(`\(\?(?:\s*,\s*\?)*\)`)
+)
+
+// sanitizeStatement replaces the literals of query, such as the ids and timestamps it is built
+// with, by placeholders so that it can be recorded.
+func sanitizeStatement(query string) string {
+	query = quotedLiteral.ReplaceAllString(query, "?")
+	query = numericLiteral.ReplaceAllString(query, "?")
+	return literalList.ReplaceAllString(query, "(?)")
+}
+
+// statement records query, sanitized, on the span of ctx and returns it unchanged.
+func statement(ctx context.Context, query string) string {
+	trace.SpanFromContext(ctx).AddEvent("statement",
+		trace.WithAttributes(attribute.String("db.statement", sanitizeStatement(query))))
+	return query
+}
@@ -0,0 +1,32 @@
+package database
+
+import "testing"
+
+func TestSanitizeStatement(t *testing.T) {
+
+	type TestCase struct {
+		query    string
+		expected string
+	}
+
+	cases := []TestCase{
+		{
+			query:    "SELECT id FROM metrics_data WHERE UNIX_TIMESTAMP(timestamp) > 1659028780 AND metric_id in (1,2, 3)",
+			expected: "SELECT id FROM metrics_data WHERE UNIX_TIMESTAMP(timestamp) > ? AND metric_id in (?)",
+		},
+		{
+			query:    "SELECT d.id FROM devices d WHERE d.name = 'pump \\'2\\'' AND d.serial_id = 'x1'",
+			expected: "SELECT d.id FROM devices d WHERE d.name = ? AND d.serial_id = ?",
+		},
+		{
+			query:    "DELETE FROM metric_limits WHERE metric_id = ?",
+			expected: "DELETE FROM metric_limits WHERE metric_id = ?",
+		},
+	}
+
+	for _, tc := range cases {
+		if got := sanitizeStatement(tc.query); got != tc.expected {
+			t.Errorf("Statement mismatch: Expected %q, got %q", tc.expected, got)
+		}
+	}
+}
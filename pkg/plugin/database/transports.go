@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// QueryDeviceTransport returns the transport of deviceId, or nil if none is configured.
+func (db *Database) QueryDeviceTransport(ctx context.Context, deviceId int64) (*DeviceTransport, error) {
+	log.DefaultLogger.Info("QueryDeviceTransport called")
+	ctx, observer := observe(ctx, "QueryDeviceTransport")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	var t DeviceTransport
+	err := db.db.QueryRowContext(ctx, statement(ctx, "SELECT device_id, port, baud_rate, data_bits, parity, stop_bits, timeout"+
+		" FROM device_transports WHERE device_id = ?"), deviceId).
+		Scan(&t.DeviceId, &t.Port, &t.BaudRate, &t.DataBits, &t.Parity, &t.StopBits, &t.Timeout)
+	// no transport was ever saved if the table is missing.
+	if err == sql.ErrNoRows || missingTable(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+
+	return &t, nil
+}
+
+func (db *Database) QueryDeviceTransports(ctx context.Context) ([]DeviceTransport, error) {
+	log.DefaultLogger.Info("QueryDeviceTransports called")
+	ctx, observer := observe(ctx, "QueryDeviceTransports")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+	res, err := db.db.QueryContext(ctx, statement(ctx, "SELECT device_id, port, baud_rate, data_bits, parity, stop_bits, timeout FROM device_transports"))
+	if missingTable(err) {
+		return make([]DeviceTransport, 0), nil
+	}
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	transports := make([]DeviceTransport, 0)
+	for res.Next() {
+		observer.rows++
+		var t DeviceTransport
+		err := res.Scan(&t.DeviceId, &t.Port, &t.BaudRate, &t.DataBits, &t.Parity, &t.StopBits, &t.Timeout)
+		if err != nil {
+			return nil, observer.fail(err)
+		}
+		transports = append(transports, t)
+	}
+
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+	return transports, nil
+}
+
+// SaveDeviceTransport creates or replaces the transport of transport.DeviceId.
+func (db *Database) SaveDeviceTransport(ctx context.Context, transport DeviceTransport) error {
+	log.DefaultLogger.Info("SaveDeviceTransport called")
+	ctx, observer := observe(ctx, "SaveDeviceTransport")
+	defer observer.done()
+	if !db.IsConnected() {
+		return ErrNotConnected
+	}
+	if err := db.ensureSchema(); err != nil {
+		return observer.fail(err)
+	}
+
+	_, err := db.db.ExecContext(ctx, statement(ctx, "INSERT INTO device_transports (device_id, port, baud_rate, data_bits, parity, stop_bits, timeout)"+
+		" VALUES (?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE port = VALUES(port), baud_rate = VALUES(baud_rate),"+
+		" data_bits = VALUES(data_bits), parity = VALUES(parity), stop_bits = VALUES(stop_bits), timeout = VALUES(timeout)"),
+		transport.DeviceId, transport.Port, transport.BaudRate, transport.DataBits, transport.Parity, transport.StopBits, transport.Timeout)
+	if err != nil {
+		return observer.fail(err)
+	}
+	return nil
+}
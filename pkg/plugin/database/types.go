@@ -2,23 +2,47 @@ package database
 
 import (
 	"database/sql"
+	"sync"
 	"time"
+
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/units"
 )
 
+// DefaultRowLimit is the row limit of databases that do not set one.
+const DefaultRowLimit = 1000000
+
 type Database struct {
 	db   *sql.DB
 	open bool
+
+	// schemaMu guards schemaReady, set once the schema is created; failures are retried.
+	schemaMu    sync.Mutex
+	schemaReady bool
+
+	metadataMu sync.Mutex
+	metadata   *metadata
+
+	// RowLimit bounds the number of data rows returned by a single query, DefaultRowLimit if 0.
+	RowLimit int
 }
 
+// Filter restricts queries to the devices, metrics or assets (Entity) whose ids are listed in
+// Value, the assets standing for all the devices under them, or to the metrics having all the Tags
+// (Entity "tags").
 type Filter struct {
 	Entity string
 	Value  string
+	Tags   map[string]string
+	// Adhoc further restricts the metrics of QueryMetricsData.
+	Adhoc []AdhocFilter
 }
 
 type Device struct {
 	Id       int64
 	Name     string
 	SerialId string
+	// Tags are the custom tags of the device, such as its site.
+	Tags map[string]string
 }
 
 type Metric struct {
@@ -33,21 +57,93 @@ type Metric struct {
 	ByteOrder     string
 	RefreshRate   int32
 	Unit          string
-}
 
-type MetricData struct {
-	Id        int64
-	MetricId  int64
-	Value     float64
-	Timestamp time.Time
+	// Scale and Offset turn raw register values into values in Unit, which EngineeringUnit, if
+	// set, is the unit they are then converted to.
+	Scale           float64
+	Offset          float64
+	EngineeringUnit string
+
+	// Mappings name the states of status registers.
+	Mappings []ValueMapping
+
+	// Tags are the custom tags of the device of the metric, overridden by those of the metric.
+	Tags map[string]string
 }
 
+// MetricWithData holds the points of a metric as two vectors sorted by time, that frame fields
+// can use as they are.
 type MetricWithData struct {
 	Metric Metric
-	Data   []*MetricData
+	Times  []time.Time
+	Values []float64
+	// Truncated is set when the row limit was reached before the end of the queried range.
+	Truncated bool
+	// Scaling, if set, turned the values of the registers into Values, which it must be inverted
+	// for to tell their named states.
+	Scaling *units.Linear
 }
 
 type DeviceWithMetrics struct {
 	Device  Device
 	Metrics []*MetricWithData
 }
+
+type MetricStats struct {
+	Metric      Metric
+	Count       int64
+	Min         *float64
+	Max         *float64
+	Mean        *float64
+	StdDev      *float64
+	First       *float64
+	Last        *float64
+	Percentiles []float64
+	// PercentilesTruncated is set when the values of the queried metrics were more than the row
+	// limit, so that Percentiles were not computed.
+	PercentilesTruncated bool
+}
+
+// MetricLimits are the engineering limits of a metric, any of which may be unset. Deadband is the
+// distance a value must move back inside a limit to clear its alarm, and Delay the number of seconds
+// a limit must be continuously exceeded before raising it.
+type MetricLimits struct {
+	MetricId int64    `json:"metricId"`
+	HighHigh *float64 `json:"highHigh"`
+	High     *float64 `json:"high"`
+	Low      *float64 `json:"low"`
+	LowLow   *float64 `json:"lowLow"`
+	Deadband float64  `json:"deadband"`
+	Delay    int32    `json:"delay"`
+}
+
+// DeviceTransport is the serial line a device is reachable on for live reads and writes.
+// Timeout is expressed in milliseconds.
+type DeviceTransport struct {
+	DeviceId int64  `json:"deviceId"`
+	Port     string `json:"port"`
+	BaudRate int32  `json:"baudRate"`
+	DataBits int32  `json:"dataBits"`
+	Parity   string `json:"parity"`
+	StopBits int32  `json:"stopBits"`
+	Timeout  int32  `json:"timeout"`
+}
+
+// MetricScaling turns the raw values of a metric into engineering values: value*Scale + Offset,
+// in the unit of the metric, then converted to Unit if set.
+type MetricScaling struct {
+	MetricId int64   `json:"metricId"`
+	Scale    float64 `json:"scale"`
+	Offset   float64 `json:"offset"`
+	Unit     string  `json:"unit"`
+}
+
+// ValueMapping names a state of a metric holding a status register: a value of the register
+// (Kind "value"), or a bit raised in it (Kind "bit", Value being the index of the bit).
+type ValueMapping struct {
+	MetricId int64  `json:"metricId"`
+	Kind     string `json:"kind"`
+	Value    int64  `json:"value"`
+	Text     string `json:"text"`
+	Color    string `json:"color"`
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// VariableKind is what the values of a template variable are.
+type VariableKind string
+
+const (
+	VariableDevices     VariableKind = "devices"
+	VariableMetrics     VariableKind = "metrics"
+	VariableUnits       VariableKind = "units"
+	VariableDataFormats VariableKind = "dataFormats"
+	VariableSlaveIds    VariableKind = "slaveIds"
+	// VariableMetricsWithData are the metrics having data in the time range of the query.
+	VariableMetricsWithData VariableKind = "metricsWithData"
+)
+
+// variableColumns are the text and value columns of each kind, over metrics m joined with devices d.
+var variableColumns = map[VariableKind][2]string{
+	VariableDevices:         {"d.name", "d.id"},
+	VariableMetrics:         {"m.name", "m.id"},
+	VariableUnits:           {"m.unit", "m.unit"},
+	VariableDataFormats:     {"m.data_format", "m.data_format"},
+	VariableSlaveIds:        {"m.slave_id", "m.slave_id"},
+	VariableMetricsWithData: {"m.name", "m.id"},
+}
+
+// VariableQuery lists the values of a template variable of Kind. Devices, SlaveIds and Metrics, if
+// set, restrict the values to those of the devices, slave ids and metrics they list, so that a
+// variable can depend on others. Regex, if set, is a regular expression the texts must match.
+type VariableQuery struct {
+	Kind      VariableKind
+	Devices   []int64
+	SlaveIds  []int64
+	Metrics   []int64
+	Regex     string
+	TimeRange backend.TimeRange
+}
+
+// VariableValue is a value of a template variable: Text is shown to users, Value is used in queries.
+type VariableValue struct {
+	Text  string
+	Value string
+}
+
+// IsVariableKind reports whether kind is a kind of VariableQuery.
+func IsVariableKind(kind VariableKind) bool {
+	_, ok := variableColumns[kind]
+	return ok
+}
+
+// QueryVariable returns the distinct values matching query, sorted by text. The filters are
+// applied by the database rather than on the cached metadata.
+func (db *Database) QueryVariable(ctx context.Context, query *VariableQuery) ([]VariableValue, error) {
+	log.DefaultLogger.Info("QueryVariable called")
+	ctx, observer := observe(ctx, "QueryVariable")
+	defer observer.done()
+	if !db.IsConnected() {
+		return nil, ErrNotConnected
+	}
+
+	columns, ok := variableColumns[query.Kind]
+	if !ok {
+		return nil, &Error{Kind: ErrorKindInvalidQuery, Message: "unknown variable kind '" + string(query.Kind) + "'"}
+	}
+
+	from := " FROM devices d LEFT JOIN metrics m ON m.device_id = d.id"
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	if query.Kind != VariableDevices {
+		conditions = append(conditions, "m.id IS NOT NULL")
+	}
+	for _, in := range []struct {
+		column string
+		ids    []int64
+	}{{"d.id", query.Devices}, {"m.slave_id", query.SlaveIds}, {"m.id", query.Metrics}} {
+		if len(in.ids) == 0 {
+			continue
+		}
+		conditions = append(conditions, in.column+" IN (?"+strings.Repeat(", ?", len(in.ids)-1)+")")
+		for _, id := range in.ids {
+			args = append(args, id)
+		}
+	}
+	if query.Kind == VariableMetricsWithData {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM metrics_data md WHERE md.metric_id = m.id"+
+			" AND md.timestamp > FROM_UNIXTIME(?) AND md.timestamp < FROM_UNIXTIME(?))")
+		args = append(args, query.TimeRange.From.Unix(), query.TimeRange.To.Unix())
+	}
+	if query.Regex != "" {
+		conditions = append(conditions, "CAST("+columns[0]+" AS CHAR) REGEXP ?")
+		args = append(args, query.Regex)
+	}
+
+	sql := "SELECT DISTINCT CAST(" + columns[0] + " AS CHAR) text, CAST(" + columns[1] + " AS CHAR) value" + from
+	if len(conditions) > 0 {
+		sql += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	sql += " ORDER BY text"
+
+	res, err := db.db.QueryContext(ctx, statement(ctx, sql), args...)
+	if err != nil {
+		return nil, observer.fail(err)
+	}
+	defer res.Close()
+
+	values := make([]VariableValue, 0)
+	for res.Next() {
+		observer.rows++
+		var v VariableValue
+		if err := res.Scan(&v.Text, &v.Value); err != nil {
+			return nil, observer.fail(err)
+		}
+		values = append(values, v)
+	}
+	if err := res.Err(); err != nil {
+		return nil, observer.fail(err)
+	}
+	return values, nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fakeStatements is a database/sql backend recording the last statement it ran and its arguments,
+// which it answers with a single row of "text" and "value".
+type fakeStatements struct {
+	query string
+	args  []interface{}
+}
+
+func (f *fakeStatements) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeStatements) Driver() driver.Driver                        { return nil }
+func (f *fakeStatements) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (f *fakeStatements) Close() error              { return nil }
+func (f *fakeStatements) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+func (f *fakeStatements) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f.query = query
+	f.args = make([]interface{}, len(args))
+	for i, arg := range args {
+		f.args[i] = arg.Value
+	}
+	return &fakeRows{columns: []string{"text", "value"}, count: 1, row: func(i int, dest []driver.Value) {
+		dest[0], dest[1] = "text", "value"
+	}}, nil
+}
+
+func TestQueryVariable(t *testing.T) {
+	source := &fakeStatements{}
+	db := &Database{db: sql.OpenDB(source), open: true}
+	timeRange := backend.TimeRange{From: time.Unix(1000, 0), To: time.Unix(2000, 0)}
+
+	type TestCase struct {
+		query              VariableQuery
+		expectedConditions []string
+		expectedArgs       []interface{}
+	}
+
+	cases := []TestCase{
+		{
+			query:              VariableQuery{Kind: VariableDevices},
+			expectedConditions: nil,
+			expectedArgs:       []interface{}{},
+		},
+		{
+			query:              VariableQuery{Kind: VariableSlaveIds, Devices: []int64{1, 2}},
+			expectedConditions: []string{"m.id IS NOT NULL", "d.id IN (?, ?)"},
+			expectedArgs:       []interface{}{int64(1), int64(2)},
+		},
+		{
+			query:              VariableQuery{Kind: VariableMetrics, Devices: []int64{1}, SlaveIds: []int64{3}, Regex: "^temp"},
+			expectedConditions: []string{"m.id IS NOT NULL", "d.id IN (?)", "m.slave_id IN (?)", "CAST(m.name AS CHAR) REGEXP ?"},
+			expectedArgs:       []interface{}{int64(1), int64(3), "^temp"},
+		},
+		{
+			query: VariableQuery{Kind: VariableMetricsWithData, Metrics: []int64{10, 11}, TimeRange: timeRange},
+			expectedConditions: []string{"m.id IS NOT NULL", "m.id IN (?, ?)", "EXISTS (SELECT 1 FROM metrics_data md WHERE md.metric_id = m.id" +
+				" AND md.timestamp > FROM_UNIXTIME(?) AND md.timestamp < FROM_UNIXTIME(?))"},
+			expectedArgs: []interface{}{int64(10), int64(11), int64(1000), int64(2000)},
+		},
+	}
+
+	for _, tc := range cases {
+		values, err := db.QueryVariable(context.Background(), &tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 1 || values[0] != (VariableValue{Text: "text", Value: "value"}) {
+			t.Errorf("Unexpected values %v", values)
+		}
+
+		where := ""
+		if len(tc.expectedConditions) > 0 {
+			where = " WHERE " + strings.Join(tc.expectedConditions, " AND ")
+		}
+		if !strings.HasSuffix(source.query, where+" ORDER BY text") {
+			t.Errorf("Conditions mismatch for %s: Expected %q, got %q", tc.query.Kind, where, source.query)
+		}
+		if !reflect.DeepEqual(source.args, tc.expectedArgs) {
+			t.Errorf("Arguments mismatch for %s: Expected %v, got %v", tc.query.Kind, tc.expectedArgs, source.args)
+		}
+	}
+
+	if _, err := db.QueryVariable(context.Background(), &VariableQuery{Kind: "sites"}); !errors.Is(err, &Error{Kind: ErrorKindInvalidQuery}) {
+		t.Errorf("Expected an invalid query for an unknown kind, got %v", err)
+	}
+}
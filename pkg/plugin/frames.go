@@ -1,32 +1,375 @@
 package plugin
 
 import (
+	"encoding/json"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+	"strconv"
 	"time"
 )
 
-func metricToFrame(device *database.Device, metric *database.MetricWithData) *data.Frame {
-	frame := data.NewFrame(device.Name)
+// fillOptions describes how gaps in a metric series are filled when building its frame.
+// The zero value leaves the series as is.
+type fillOptions struct {
+	Mode series.FillMode
+	// Threshold is the longest interval between two points that is not considered a gap,
+	// defaults to twice the refresh rate of the metric.
+	Threshold time.Duration
+}
 
-	times := make([]time.Time, len(metric.Data))
-	values := make([]float64, len(metric.Data))
+// metricToFrame returns the series of metric as a time series frame with a single, labelled value field,
+// so that each metric of a response can be told apart (e.g. by alerting). The named states of the
+// metric are rendered according to states.
+func metricToFrame(device *database.Device, metric *database.MetricWithData, fill fillOptions, states statesMode) *data.Frame {
+	frame := data.NewFrame(device.Name + " - " + metric.Metric.Name)
 
-	for i, d := range metric.Data {
-		times[i] = d.Timestamp
-		values[i] = d.Value
+	interval := time.Duration(metric.Metric.RefreshRate) * time.Second
+	threshold := fill.Threshold
+	if threshold == 0 {
+		threshold = 2 * interval
+	}
+	if interval == 0 {
+		interval = threshold
 	}
 
-	valueField := data.NewField("Value", data.Labels{"metric": metric.Metric.Name}, values)
-	timeField := data.NewField("Time", nil, times)
-
-	// Add field config (unit, ...)
-	valueField.Config = &data.FieldConfig{
-		Unit: metric.Metric.Unit,
+	var timeField, valueField *data.Field
+	if fill.Mode == series.FillNone {
+		// the vectors of the metric are the fields, which spares copying large series.
+		timeField = data.NewField("Time", nil, metric.Times)
+		valueField = data.NewField("Value", metricLabels(device, &metric.Metric), metric.Values)
+	} else {
+		times, values := series.Fill(metric.Times, metric.Values, interval, threshold, fill.Mode)
+		timeField = data.NewField("Time", nil, times)
+		valueField = data.NewField("Value", metricLabels(device, &metric.Metric), values)
 	}
+	valueField.Config = metricFieldConfig(device, &metric.Metric)
+	valueField = stateField(valueField, metric, states)
 
 	// populate fields with metric values
-	frame.Fields = append(frame.Fields, valueField, timeField)
+	frame.Fields = append(frame.Fields, timeField, valueField)
+	frame.SetMeta(&data.FrameMeta{Type: data.FrameTypeTimeSeriesMany})
+	if metric.Truncated {
+		frame.AppendNotices(truncationNotice(metric))
+	}
+
+	return frame
+}
+
+// metricToNumericFrame returns the last value of metric as a single row frame, or an empty one if
+// metric has no data. Its named states are rendered according to states.
+func metricToNumericFrame(device *database.Device, metric *database.MetricWithData, states statesMode) *data.Frame {
+	frame := data.NewFrame(device.Name + " - " + metric.Metric.Name)
+
+	values := make([]*float64, 0, 1)
+	if len(metric.Values) > 0 {
+		values = append(values, &metric.Values[len(metric.Values)-1])
+	}
+
+	valueField := data.NewField("Value", metricLabels(device, &metric.Metric), values)
+	valueField.Config = metricFieldConfig(device, &metric.Metric)
+	valueField = stateField(valueField, metric, states)
+	frame.Fields = append(frame.Fields, valueField)
+	if metric.Truncated {
+		frame.AppendNotices(truncationNotice(metric))
+	}
+
+	return frame
+}
+
+// truncationNotice warns that the data of metric stops short of the queried range.
+func truncationNotice(metric *database.MetricWithData) data.Notice {
+	text := "The row limit of the datasource was reached"
+	if len(metric.Times) > 0 {
+		text += ", data after " + metric.Times[len(metric.Times)-1].UTC().Format(time.RFC3339) + " is missing"
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     text + ". Narrow the time range or the metrics of the query.",
+	}
+}
+
+// percentilesTruncationNotice warns that the percentiles of statistics were not computed.
+func percentilesTruncationNotice() data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     "The row limit of the datasource was reached, percentiles were not computed. Narrow the time range or the metrics of the query.",
+	}
+}
+
+// metricLabels returns the labels of the frames of metric, its custom tags included.
+func metricLabels(device *database.Device, metric *database.Metric) data.Labels {
+	labels := data.Labels{
+		"device":    device.Name,
+		"device_id": strconv.FormatInt(device.Id, 10),
+		"metric":    metric.Name,
+		"metric_id": strconv.FormatInt(metric.Id, 10),
+	}
+	for key, value := range metric.Tags {
+		labels[key] = value
+	}
+	return labels
+}
+
+func metricFieldConfig(device *database.Device, metric *database.Metric) *data.FieldConfig {
+	return &data.FieldConfig{
+		DisplayNameFromDS: device.Name + " - " + metric.Name,
+		Unit:              metric.Unit,
+	}
+}
+
+func statsToFrame(stats []database.MetricStats, percentiles []float64) *data.Frame {
+	frame := data.NewFrame("stats")
+
+	deviceIds := make([]int64, len(stats))
+	deviceNames := make([]string, len(stats))
+	metricIds := make([]int64, len(stats))
+	metricNames := make([]string, len(stats))
+	units := make([]string, len(stats))
+	counts := make([]int64, len(stats))
+	mins := make([]*float64, len(stats))
+	maxs := make([]*float64, len(stats))
+	means := make([]*float64, len(stats))
+	stdDevs := make([]*float64, len(stats))
+	firsts := make([]*float64, len(stats))
+	lasts := make([]*float64, len(stats))
+	pValues := make([][]*float64, len(percentiles))
+	for j := range percentiles {
+		pValues[j] = make([]*float64, len(stats))
+	}
+
+	for i, s := range stats {
+		deviceIds[i] = s.Metric.DeviceId
+		deviceNames[i] = s.Metric.DeviceName
+		metricIds[i] = s.Metric.Id
+		metricNames[i] = s.Metric.Name
+		units[i] = s.Metric.Unit
+		counts[i] = s.Count
+		mins[i] = s.Min
+		maxs[i] = s.Max
+		means[i] = s.Mean
+		stdDevs[i] = s.StdDev
+		firsts[i] = s.First
+		lasts[i] = s.Last
+		for j := range s.Percentiles {
+			value := s.Percentiles[j]
+			pValues[j][i] = &value
+		}
+	}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("device_id", nil, deviceIds),
+		data.NewField("device", nil, deviceNames),
+		data.NewField("metric_id", nil, metricIds),
+		data.NewField("metric", nil, metricNames),
+		data.NewField("unit", nil, units),
+		data.NewField("count", nil, counts),
+		data.NewField("min", nil, mins),
+		data.NewField("max", nil, maxs),
+		data.NewField("mean", nil, means),
+		data.NewField("stddev", nil, stdDevs),
+		data.NewField("first", nil, firsts),
+		data.NewField("last", nil, lasts),
+	)
+
+	for j, p := range percentiles {
+		frame.Fields = append(frame.Fields,
+			data.NewField("p"+strconv.FormatFloat(p, 'f', -1, 64), nil, pValues[j]))
+	}
+
+	return frame
+}
+
+// availabilityToTimelineFrame returns the online/offline transitions of a device, suitable for
+// the state timeline panel.
+func availabilityToTimelineFrame(availability deviceAvailability) *data.Frame {
+	frame := data.NewFrame(availability.Device.Name)
+
+	times := make([]time.Time, 0, 2*len(availability.Outages)+1)
+	states := make([]string, 0, 2*len(availability.Outages)+1)
+
+	if len(availability.Outages) == 0 || availability.Outages[0].From.After(availability.From) {
+		times = append(times, availability.From)
+		states = append(states, "online")
+	}
+	for _, outage := range availability.Outages {
+		times = append(times, outage.From)
+		states = append(states, "offline")
+		if outage.To.Before(availability.To) {
+			times = append(times, outage.To)
+			states = append(states, "online")
+		}
+	}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("Time", nil, times),
+		data.NewField("state", data.Labels{"device": availability.Device.Name}, states),
+	)
+
+	return frame
+}
+
+func availabilityToSummaryFrame(availabilities []deviceAvailability) *data.Frame {
+	frame := data.NewFrame("availability")
+
+	ids := make([]int64, len(availabilities))
+	names := make([]string, len(availabilities))
+	uptimes := make([]float64, len(availabilities))
+	outages := make([]int64, len(availabilities))
+	downtimes := make([]float64, len(availabilities))
+	longest := make([]float64, len(availabilities))
+
+	for i, a := range availabilities {
+		ids[i] = a.Device.Id
+		names[i] = a.Device.Name
+		uptimes[i] = a.Uptime()
+		outages[i] = int64(len(a.Outages))
+		downtimes[i] = a.Downtime().Seconds()
+		longest[i] = a.LongestOutage().Seconds()
+	}
+
+	uptimeField := data.NewField("uptime", nil, uptimes)
+	uptimeField.Config = &data.FieldConfig{Unit: "percent"}
+	downtimeField := data.NewField("downtime", nil, downtimes)
+	downtimeField.Config = &data.FieldConfig{Unit: "s"}
+	longestField := data.NewField("longest_outage", nil, longest)
+	longestField.Config = &data.FieldConfig{Unit: "s"}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("device_id", nil, ids),
+		data.NewField("device", nil, names),
+		uptimeField,
+		data.NewField("outages", nil, outages),
+		downtimeField,
+		longestField,
+	)
+
+	return frame
+}
+
+// annotationsToFrame returns annotations in the frame shape Grafana expects from annotation queries.
+func annotationsToFrame(annotations []annotation) *data.Frame {
+	frame := data.NewFrame("annotations")
+
+	times := make([]time.Time, len(annotations))
+	timeEnds := make([]time.Time, len(annotations))
+	titles := make([]string, len(annotations))
+	texts := make([]string, len(annotations))
+	tags := make([]json.RawMessage, len(annotations))
+
+	for i, a := range annotations {
+		times[i] = a.Time
+		timeEnds[i] = a.TimeEnd
+		titles[i] = a.Title
+		texts[i] = a.Text
+		tags[i], _ = json.Marshal(a.Tags)
+	}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("time", nil, times),
+		data.NewField("timeEnd", nil, timeEnds),
+		data.NewField("title", nil, titles),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+
+	return frame
+}
+
+// variableToFrame returns values in the text and value fields Grafana expects from variable queries.
+func variableToFrame(values []database.VariableValue) *data.Frame {
+	frame := data.NewFrame("variable")
+
+	texts := make([]string, len(values))
+	vals := make([]string, len(values))
+	for i, v := range values {
+		texts[i] = v.Text
+		vals[i] = v.Value
+	}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("text", nil, texts),
+		data.NewField("value", nil, vals),
+	)
+
+	return frame
+}
+
+// alarmsToActiveFrame returns the alarms that are still active at the end of the time range.
+func alarmsToActiveFrame(alarms []metricAlarm) *data.Frame {
+	frame := data.NewFrame("active")
+
+	active := make([]metricAlarm, 0)
+	for _, a := range alarms {
+		if a.Cleared == nil {
+			active = append(active, a)
+		}
+	}
+
+	since := make([]time.Time, len(active))
+	devices := make([]string, len(active))
+	metricIds := make([]int64, len(active))
+	metrics := make([]string, len(active))
+	levels := make([]string, len(active))
+	limits := make([]float64, len(active))
+	values := make([]float64, len(active))
+
+	for i, a := range active {
+		since[i] = a.Activated
+		devices[i] = a.Device.Name
+		metricIds[i] = a.Metric.Id
+		metrics[i] = a.Metric.Name
+		levels[i] = a.Level
+		limits[i] = a.Limit
+		values[i] = a.Value
+	}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("since", nil, since),
+		data.NewField("device", nil, devices),
+		data.NewField("metric_id", nil, metricIds),
+		data.NewField("metric", nil, metrics),
+		data.NewField("level", nil, levels),
+		data.NewField("limit", nil, limits),
+		data.NewField("value", nil, values),
+	)
+
+	return frame
+}
+
+func alarmsToHistoryFrame(alarms []metricAlarm) *data.Frame {
+	frame := data.NewFrame("history")
+
+	activated := make([]time.Time, len(alarms))
+	cleared := make([]*time.Time, len(alarms))
+	devices := make([]string, len(alarms))
+	metricIds := make([]int64, len(alarms))
+	metrics := make([]string, len(alarms))
+	levels := make([]string, len(alarms))
+	limits := make([]float64, len(alarms))
+	peaks := make([]float64, len(alarms))
+
+	for i, a := range alarms {
+		activated[i] = a.Activated
+		cleared[i] = a.Cleared
+		devices[i] = a.Device.Name
+		metricIds[i] = a.Metric.Id
+		metrics[i] = a.Metric.Name
+		levels[i] = a.Level
+		limits[i] = a.Limit
+		peaks[i] = a.Peak
+	}
+
+	frame.Fields = append(frame.Fields,
+		data.NewField("time", nil, activated),
+		data.NewField("timeEnd", nil, cleared),
+		data.NewField("device", nil, devices),
+		data.NewField("metric_id", nil, metricIds),
+		data.NewField("metric", nil, metrics),
+		data.NewField("level", nil, levels),
+		data.NewField("limit", nil, limits),
+		data.NewField("peak", nil, peaks),
+	)
 
 	return frame
 }
@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+func testMetric(id int64, name string, values ...float64) *database.MetricWithData {
+	start := time.Unix(1659028780, 0)
+	metric := &database.MetricWithData{
+		Metric: database.Metric{Id: id, Name: name, Unit: "kW", RefreshRate: 10},
+		Times:  make([]time.Time, len(values)),
+		Values: values,
+	}
+	for i := range values {
+		metric.Times[i] = start.Add(time.Duration(i) * 10 * time.Second)
+	}
+	return metric
+}
+
+func TestMetricToFrameIsAlertableTimeSeries(t *testing.T) {
+	device := &database.Device{Id: 1, Name: "inverter"}
+	metrics := []*database.MetricWithData{
+		testMetric(10, "power", 1, 2, 3),
+		testMetric(11, "voltage", 230, 231, 229),
+	}
+
+	seen := make(map[string]bool)
+	for _, metric := range metrics {
+		frame := metricToFrame(device, metric, fillOptions{}, statesMappings)
+
+		schema := frame.TimeSeriesSchema()
+		if schema.Type != data.TimeSeriesTypeWide {
+			t.Fatalf("Expected a wide time series for %s, got %s", metric.Metric.Name, schema.Type)
+		}
+		if schema.TimeIndex != 0 || len(schema.ValueIndices) != 1 {
+			t.Errorf("Expected the time field first and a single value field for %s", metric.Metric.Name)
+		}
+		if !frame.Meta.Type.IsTimeSeries() {
+			t.Errorf("Expected a time series frame type for %s, got %q", metric.Metric.Name, frame.Meta.Type)
+		}
+
+		labels := frame.Fields[schema.ValueIndices[0]].Labels
+		if labels["metric_id"] == "" || labels["device_id"] == "" {
+			t.Errorf("Missing identifying labels for %s: %v", metric.Metric.Name, labels)
+		}
+		if seen[labels.String()] {
+			t.Errorf("Labels of %s are not distinct: %v", metric.Metric.Name, labels)
+		}
+		seen[labels.String()] = true
+	}
+}
+
+func TestMetricLabelsHaveTags(t *testing.T) {
+	device := &database.Device{Id: 1, Name: "inverter"}
+	metric := testMetric(10, "power")
+	metric.Metric.Tags = map[string]string{"site": "plantA", "line": "2"}
+
+	labels := metricLabels(device, &metric.Metric)
+	if labels["site"] != "plantA" || labels["line"] != "2" || labels["metric_id"] != "10" {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+}
+
+func TestMetricToNumericFrame(t *testing.T) {
+	device := &database.Device{Id: 1, Name: "inverter"}
+
+	frame := metricToNumericFrame(device, testMetric(10, "power", 1, 2, 3), statesMappings)
+	if frame.TimeSeriesSchema().Type != data.TimeSeriesTypeNot {
+		t.Errorf("Expected a numeric frame without time field")
+	}
+	if rows, _ := frame.RowLen(); rows != 1 {
+		t.Fatalf("Expected a single row, got %d", rows)
+	}
+	if value, ok := frame.Fields[0].ConcreteAt(0); !ok || value.(float64) != 3 {
+		t.Errorf("Expected the last value 3, got %v", value)
+	}
+
+	empty := metricToNumericFrame(device, testMetric(11, "voltage"), statesMappings)
+	if rows, _ := empty.RowLen(); rows != 0 {
+		t.Errorf("Expected no rows for a metric without data, got %d", rows)
+	}
+}
+
+func TestMetricToFrameTruncated(t *testing.T) {
+	device := &database.Device{Id: 1, Name: "inverter"}
+	metric := testMetric(10, "power", 1, 2, 3)
+
+	frame := metricToFrame(device, metric, fillOptions{}, statesMappings)
+	if len(frame.Meta.Notices) != 0 {
+		t.Errorf("Expected no notice, got %v", frame.Meta.Notices)
+	}
+
+	metric.Truncated = true
+	frame = metricToFrame(device, metric, fillOptions{}, statesMappings)
+	if len(frame.Meta.Notices) != 1 || frame.Meta.Notices[0].Severity != data.NoticeSeverityWarning {
+		t.Fatalf("Expected a warning notice, got %v", frame.Meta.Notices)
+	}
+	if !strings.Contains(frame.Meta.Notices[0].Text, "2022-07-28T17:20:00Z") {
+		t.Errorf("Expected the notice to name the last point, got %q", frame.Meta.Notices[0].Text)
+	}
+}
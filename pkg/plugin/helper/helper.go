@@ -2,6 +2,7 @@ package helper
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
 	"unicode"
@@ -29,6 +30,53 @@ func GetCredentials(instanceSettings *backend.DataSourceInstanceSettings) (*data
 	}, nil
 }
 
+// GetRowLimit returns the "rowLimit" setting of the datasource, or 0 if unset.
+func GetRowLimit(instanceSettings *backend.DataSourceInstanceSettings) (int, error) {
+	// the config editor saves the limit as text.
+	type JSONDataStruct struct {
+		RowLimit json.Number
+	}
+	var jsonData JSONDataStruct
+
+	err := json.Unmarshal(instanceSettings.JSONData, &jsonData)
+	if err != nil {
+		return 0, err
+	}
+	if jsonData.RowLimit == "" {
+		return 0, nil
+	}
+
+	limit, err := jsonData.RowLimit.Int64()
+	if err != nil || limit < 0 {
+		return 0, errors.New("invalid row limit '" + jsonData.RowLimit.String() + "'")
+	}
+	return int(limit), nil
+}
+
+// Features are the features of the datasource that users must opt in to in its settings.
+type Features struct {
+	// AllowWrites lets editors write the registers of devices.
+	AllowWrites bool
+	// AllowRawSQL lets anyone who can query the datasource run SELECT statements on its database,
+	// reading whatever the grants of its user allow.
+	AllowRawSQL bool
+}
+
+// GetFeatures returns the features enabled in the settings of the datasource.
+func GetFeatures(instanceSettings *backend.DataSourceInstanceSettings) (*Features, error) {
+	type JSONDataStruct struct {
+		AllowWrites bool
+		AllowRawSQL bool
+	}
+	var jsonData JSONDataStruct
+
+	err := json.Unmarshal(instanceSettings.JSONData, &jsonData)
+	if err != nil {
+		return nil, err
+	}
+	return &Features{AllowWrites: jsonData.AllowWrites, AllowRawSQL: jsonData.AllowRawSQL}, nil
+}
+
 func SqlFieldToStructField(field string) string {
 	structField := ""
 	capitalize := true
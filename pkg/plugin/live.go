@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/modbus"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/parser"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rtuPool keeps a single RTU client per serial port, shared by all the devices on that bus.
+// The first transport opening a port sets its line settings, until the client is dropped: when
+// its port fails, or when a transport on that port is saved.
+type rtuPool struct {
+	mu      sync.Mutex
+	clients map[string]*modbus.RTUClient
+}
+
+func newRTUPool() *rtuPool {
+	return &rtuPool{
+		clients: make(map[string]*modbus.RTUClient),
+	}
+}
+
+func (p *rtuPool) client(transport *database.DeviceTransport) (*modbus.RTUClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if client, ok := p.clients[transport.Port]; ok {
+		if client.Err() == nil {
+			return client, nil
+		}
+		// the port failed, say the adapter was unplugged, so it is opened again.
+		client.Close()
+		delete(p.clients, transport.Port)
+	}
+
+	config := modbus.SerialConfig{
+		Port:     transport.Port,
+		BaudRate: int(transport.BaudRate),
+		DataBits: int(transport.DataBits),
+		Parity:   transport.Parity,
+		StopBits: int(transport.StopBits),
+		Timeout:  time.Duration(transport.Timeout) * time.Millisecond,
+	}
+	port, err := modbus.OpenSerial(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client := modbus.NewRTUClient(port, config)
+	p.clients[transport.Port] = client
+	return client, nil
+}
+
+// drop closes the clients of ports, if open, once their pending requests are answered. The next
+// request on these ports opens them with the settings of its transport.
+func (p *rtuPool) drop(ports ...string) {
+	p.mu.Lock()
+	dropped := make([]*modbus.RTUClient, 0, len(ports))
+	for _, port := range ports {
+		if client, ok := p.clients[port]; ok {
+			dropped = append(dropped, client)
+			delete(p.clients, port)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, client := range dropped {
+		client.Close()
+	}
+}
+
+func (p *rtuPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for port, client := range p.clients {
+		client.Close()
+		delete(p.clients, port)
+	}
+}
+
+// metricClient returns the client of the serial line the device of metric is on.
+func (d *SampleDatasource) metricClient(ctx context.Context, metric *database.Metric) (*modbus.RTUClient, error) {
+	transport, err := d.database.QueryDeviceTransport(ctx, metric.DeviceId)
+	if err != nil {
+		return nil, err
+	}
+	if transport == nil {
+		return nil, errors.New("no transport configured for device '" + metric.DeviceName + "'")
+	}
+	return d.rtuPool.client(transport)
+}
+
+// readMetrics reads the current value of metrics from their devices, returning for each metric either
+// its value or the error that prevented reading it. Registers of metrics of the same device, slave
+// and function code are read in blocks, merging ranges at most maxGap registers apart.
+func (d *SampleDatasource) readMetrics(ctx context.Context, metrics []database.Metric, maxGap int) ([]float64, []error) {
+	values := make([]float64, len(metrics))
+	errs := make([]error, len(metrics))
+
+	type blockKey struct {
+		DeviceId     int64
+		SlaveId      int32
+		FunctionCode int32
+	}
+	groups := make(map[blockKey][]int)
+	keys := make([]blockKey, 0)
+	for i, metric := range metrics {
+		key := blockKey{DeviceId: metric.DeviceId, SlaveId: metric.SlaveId, FunctionCode: metric.FunctionCode}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	for _, key := range keys {
+		members := groups[key]
+		client, err := d.metricClient(ctx, &metrics[members[0]])
+		if err != nil {
+			for _, i := range members {
+				errs[i] = err
+			}
+			continue
+		}
+
+		// metrics that cannot be decoded are not read at all.
+		readable := make([]int, 0, len(members))
+		decoders := make([]*parser.Decoder, 0, len(members))
+		ranges := make([]modbus.RegisterRange, 0, len(members))
+		for _, i := range members {
+			decoder, err := parser.NewDecoder(metrics[i].DataFormat, metrics[i].ByteOrder)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			readable = append(readable, i)
+			decoders = append(decoders, decoder)
+			ranges = append(ranges, modbus.RegisterRange{
+				Start:    uint16(metrics[i].RegisterStart),
+				Quantity: uint16(decoder.Width() / 2),
+			})
+		}
+
+		for _, block := range modbus.PlanBlocks(ranges, maxGap) {
+			raw, err := client.ReadRegisters(ctx, byte(key.SlaveId), byte(key.FunctionCode), block.Start, block.Quantity)
+			for _, j := range block.Members {
+				i := readable[j]
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				values[i], errs[i] = decoders[j].Decode(block.Slice(raw, ranges[j]))
+			}
+		}
+	}
+
+	return values, errs
+}
+
+// writeMetric writes value to the registers of metric, which must be holding registers.
+func (d *SampleDatasource) writeMetric(ctx context.Context, metric *database.Metric, value float64) error {
+	if metric.FunctionCode != modbus.FuncReadHoldingRegisters {
+		return errors.New("metric " + strconv.FormatInt(metric.Id, 10) + " is not a holding register")
+	}
+
+	raw, err := parser.GetDoubleToBytesEncoder(metric.DataFormat, metric.ByteOrder)(value)
+	if err != nil {
+		return err
+	}
+
+	client, err := d.metricClient(ctx, metric)
+	if err != nil {
+		return err
+	}
+	return client.WriteRegisters(ctx, byte(metric.SlaveId), uint16(metric.RegisterStart), raw)
+}
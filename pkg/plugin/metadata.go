@@ -0,0 +1,31 @@
+package plugin
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// metadataRefreshInterval is how often the devices and metrics are reloaded, to pick up the
+// changes made by exprom-modbus-server. The /refresh resource reloads them on demand.
+const metadataRefreshInterval = 5 * time.Minute
+
+// refreshMetadata reloads the devices and metrics every interval, until the instance is disposed.
+func (d *SampleDatasource) refreshMetadata(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := d.database.RefreshMetadata(ctx); err != nil {
+				log.DefaultLogger.Error("Error refreshing devices and metrics", "error", err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The metrics below are registered on the default registry, which the SDK exposes to Grafana.
+var (
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "modbusrtu",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of data queries, by entity and status.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 8),
+	}, []string{"entity", "status"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "modbusrtu",
+		Name:      "query_errors_total",
+		Help:      "Number of failed data queries, by entity and source of the error (plugin or downstream).",
+	}, []string{"entity", "source"})
+
+	framesReturned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "modbusrtu",
+		Name:      "frames_total",
+		Help:      "Number of data frames returned by data queries, by entity.",
+	}, []string{"entity"})
+
+	activeStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "modbusrtu",
+		Name:      "active_streams",
+		Help:      "Number of streams currently running.",
+	})
+
+	streamSendErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "modbusrtu",
+		Name:      "stream_send_errors_total",
+		Help:      "Number of frames that could not be fetched or sent to streams.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(queryDuration, queryErrors, framesReturned, activeStreams, streamSendErrors)
+}
+
+// entities are the known query entities, the only values of the entity label so that unknown
+// entities sent by clients do not create series.
+var entities = map[queryEntity]bool{
+	entityDevices:            true,
+	entityMetrics:            true,
+	entityMetricsData:        true,
+	entityMetricsStats:       true,
+	entityDeviceAvailability: true,
+	entityAnnotations:        true,
+	entityAlarms:             true,
+	entityLiveRead:           true,
+	entityRawSQL:             true,
+	entityVariableQuery:      true,
+	entityAssets:             true,
+}
+
+// observeQuery records a query of entity that started at start and answered res.
+func observeQuery(entity string, start time.Time, res *backend.DataResponse) {
+	if !entities[queryEntity(entity)] {
+		entity = "unknown"
+	}
+	status := "ok"
+	if res.Error != nil {
+		status = "error"
+		queryErrors.WithLabelValues(entity, string(database.SourceOf(res.Error))).Inc()
+	}
+
+	queryDuration.WithLabelValues(entity, status).Observe(time.Since(start).Seconds())
+	framesReturned.WithLabelValues(entity).Add(float64(len(res.Frames)))
+}
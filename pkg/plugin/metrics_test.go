@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveQuery(t *testing.T) {
+	frames := testutil.ToFloat64(framesReturned.WithLabelValues("MetricsData"))
+	observeQuery("MetricsData", time.Now(), &backend.DataResponse{
+		Frames: data.Frames{data.NewFrame("a"), data.NewFrame("b")},
+	})
+	if got := testutil.ToFloat64(framesReturned.WithLabelValues("MetricsData")); got != frames+2 {
+		t.Errorf("Expected %f frames, got %f", frames+2, got)
+	}
+
+	// unknown entities all share a single series.
+	observeQuery("unknown", time.Now(), &backend.DataResponse{Error: errors.New("unknown entity")})
+	series := testutil.CollectAndCount(queryDuration)
+	observeQuery("DROP TABLE devices", time.Now(), &backend.DataResponse{Error: errors.New("unknown entity")})
+	if got := testutil.CollectAndCount(queryDuration); got != series {
+		t.Errorf("Expected %d duration series, got %d", series, got)
+	}
+}
+
+func TestMetricsLint(t *testing.T) {
+	for _, c := range []prometheus.Collector{queryDuration, framesReturned, activeStreams, streamSendErrors} {
+		problems, err := testutil.CollectAndLint(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range problems {
+			t.Errorf("%s: %s", p.Metric, p.Text)
+		}
+	}
+}
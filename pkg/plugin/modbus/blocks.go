@@ -0,0 +1,60 @@
+package modbus
+
+import "sort"
+
+// RegisterRange is a run of Quantity consecutive registers starting at Start.
+type RegisterRange struct {
+	Start    uint16
+	Quantity uint16
+}
+
+func (r RegisterRange) end() int {
+	return int(r.Start) + int(r.Quantity)
+}
+
+// Block is a range of registers read in a single request on behalf of several ranges,
+// designated by their index in the slice given to PlanBlocks.
+type Block struct {
+	RegisterRange
+	Members []int
+}
+
+// Slice returns the bytes of member, one of the ranges of the block, from raw, the bytes read
+// for the whole block. Members may overlap and share their bytes.
+func (b Block) Slice(raw []byte, member RegisterRange) []byte {
+	offset := 2 * int(member.Start-b.Start)
+	return raw[offset : offset+2*int(member.Quantity)]
+}
+
+// PlanBlocks merges overlapping ranges, and ranges separated by at most maxGap registers,
+// into blocks of at most MaxReadRegisters registers. All ranges must be read with the same
+// slave id and function code, and be at most MaxReadRegisters long.
+func PlanBlocks(ranges []RegisterRange, maxGap int) []Block {
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return ranges[order[i]].Start < ranges[order[j]].Start
+	})
+
+	blocks := make([]Block, 0)
+	for _, idx := range order {
+		r := ranges[idx]
+		if len(blocks) > 0 {
+			last := &blocks[len(blocks)-1]
+			end := last.end()
+			if r.end() > end {
+				end = r.end()
+			}
+			if int(r.Start) <= last.end()+maxGap && end-int(last.Start) <= MaxReadRegisters {
+				last.Quantity = uint16(end - int(last.Start))
+				last.Members = append(last.Members, idx)
+				continue
+			}
+		}
+		blocks = append(blocks, Block{RegisterRange: r, Members: []int{idx}})
+	}
+
+	return blocks
+}
@@ -0,0 +1,60 @@
+package modbus_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/modbus"
+)
+
+func TestPlanBlocks(t *testing.T) {
+
+	type TestCase struct {
+		name     string
+		ranges   []modbus.RegisterRange
+		maxGap   int
+		expected []modbus.Block
+	}
+
+	cases := []TestCase{
+		{
+			name:   "adjacent and overlapping",
+			ranges: []modbus.RegisterRange{{Start: 4, Quantity: 2}, {Start: 0, Quantity: 2}, {Start: 2, Quantity: 2}, {Start: 5, Quantity: 1}},
+			maxGap: 0,
+			expected: []modbus.Block{
+				{RegisterRange: modbus.RegisterRange{Start: 0, Quantity: 6}, Members: []int{1, 2, 0, 3}},
+			},
+		},
+		{
+			name:   "gap tolerance",
+			ranges: []modbus.RegisterRange{{Start: 0, Quantity: 2}, {Start: 5, Quantity: 2}, {Start: 20, Quantity: 1}},
+			maxGap: 3,
+			expected: []modbus.Block{
+				{RegisterRange: modbus.RegisterRange{Start: 0, Quantity: 7}, Members: []int{0, 1}},
+				{RegisterRange: modbus.RegisterRange{Start: 20, Quantity: 1}, Members: []int{2}},
+			},
+		},
+		{
+			name:   "protocol limit",
+			ranges: []modbus.RegisterRange{{Start: 0, Quantity: 100}, {Start: 100, Quantity: 25}, {Start: 125, Quantity: 2}},
+			maxGap: 0,
+			expected: []modbus.Block{
+				{RegisterRange: modbus.RegisterRange{Start: 0, Quantity: 125}, Members: []int{0, 1}},
+				{RegisterRange: modbus.RegisterRange{Start: 125, Quantity: 2}, Members: []int{2}},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		blocks := modbus.PlanBlocks(tc.ranges, tc.maxGap)
+		if !reflect.DeepEqual(blocks, tc.expected) {
+			t.Errorf("Blocks mismatch for %s: Expected %+v, got %+v", tc.name, tc.expected, blocks)
+		}
+	}
+
+	block := modbus.Block{RegisterRange: modbus.RegisterRange{Start: 10, Quantity: 3}}
+	raw := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05}
+	if slice := block.Slice(raw, modbus.RegisterRange{Start: 11, Quantity: 2}); !reflect.DeepEqual(slice, raw[2:]) {
+		t.Errorf("Slice mismatch: Expected %X, got %X", raw[2:], slice)
+	}
+}
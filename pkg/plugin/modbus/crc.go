@@ -0,0 +1,18 @@
+package modbus
+
+// CRC16 returns the Modbus RTU checksum of data (CRC-16/MODBUS: polynomial 0xA001 reflected,
+// initial value 0xFFFF). It is appended to frames low byte first.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,275 @@
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	FuncReadHoldingRegisters   = 0x03
+	FuncReadInputRegisters     = 0x04
+	FuncWriteSingleRegister    = 0x06
+	FuncWriteMultipleRegisters = 0x10
+
+	// MaxReadRegisters is the largest quantity of registers a single read request may ask for.
+	MaxReadRegisters = 125
+	// MaxWriteRegisters is the largest quantity of registers a single write request may carry.
+	MaxWriteRegisters = 123
+)
+
+var ErrTimeout = errors.New("modbus: timed out waiting for response")
+
+// ExceptionError is returned when a slave answers a request with an exception response.
+type ExceptionError struct {
+	FunctionCode byte
+	Code         byte
+}
+
+func (e *ExceptionError) Error() string {
+	return fmt.Sprintf("modbus: exception %d for function 0x%02x", e.Code, e.FunctionCode)
+}
+
+// SerialConfig describes the serial line a device is reachable on.
+type SerialConfig struct {
+	Port     string
+	BaudRate int
+	DataBits int
+	// Parity is either "N" (none), "E" (even) or "O" (odd).
+	Parity   string
+	StopBits int
+	Timeout  time.Duration
+}
+
+// charTime returns the time needed to transmit a single character on the line.
+func (c SerialConfig) charTime() time.Duration {
+	bits := 1 + c.DataBits + c.StopBits
+	if c.Parity == "E" || c.Parity == "O" {
+		bits++
+	}
+	return time.Duration(bits) * time.Second / time.Duration(c.BaudRate)
+}
+
+// frameDelay returns the silent interval required between two frames: 3.5 character times,
+// fixed to 1.75ms above 19200 bauds as recommended by the Modbus over serial line specification.
+func (c SerialConfig) frameDelay() time.Duration {
+	if c.BaudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return c.charTime() * 7 / 2
+}
+
+// inputFlusher is implemented by ports that can discard the bytes received but not read yet, such
+// as those of OpenSerial.
+type inputFlusher interface {
+	FlushInput() error
+}
+
+// RTUClient is a Modbus RTU master on a serial line. It is safe for concurrent use, requests
+// are serialized since the line is half-duplex.
+type RTUClient struct {
+	mu           sync.Mutex
+	port         io.ReadWriteCloser
+	config       SerialConfig
+	lastActivity time.Time
+	// failed is the error of the port that broke the client, if any.
+	failed error
+}
+
+// NewRTUClient returns a client sending requests on port, which must already be configured
+// according to config (see OpenSerial).
+func NewRTUClient(port io.ReadWriteCloser, config SerialConfig) *RTUClient {
+	return &RTUClient{
+		port:   port,
+		config: config,
+	}
+}
+
+// Close closes the port once the pending request, if any, is answered.
+func (c *RTUClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.port.Close()
+}
+
+// Err returns the error of the port that broke the client, which then fails all requests, or nil.
+func (c *RTUClient) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failed
+}
+
+// ReadRegisters reads quantity registers starting at address using functionCode, either
+// FuncReadHoldingRegisters or FuncReadInputRegisters, and returns their raw big-endian bytes.
+func (c *RTUClient) ReadRegisters(ctx context.Context, slaveId byte, functionCode byte, address uint16, quantity uint16) ([]byte, error) {
+	if functionCode != FuncReadHoldingRegisters && functionCode != FuncReadInputRegisters {
+		return nil, fmt.Errorf("modbus: function 0x%02x cannot read registers", functionCode)
+	}
+	if quantity == 0 || quantity > MaxReadRegisters {
+		return nil, fmt.Errorf("modbus: cannot read %d registers", quantity)
+	}
+
+	pdu := make([]byte, 5)
+	pdu[0] = functionCode
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], quantity)
+
+	response, err := c.transaction(ctx, slaveId, pdu, func(header []byte) int {
+		// slave id, function code, byte count, registers, crc
+		return 3 + int(header[2]) + 2
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if int(response[2]) != 2*int(quantity) {
+		return nil, fmt.Errorf("modbus: expected %d bytes, got %d", 2*quantity, response[2])
+	}
+	return response[3 : 3+response[2]], nil
+}
+
+// WriteRegisters writes values, raw big-endian register bytes, starting at address. A single
+// register is written with FuncWriteSingleRegister, several with FuncWriteMultipleRegisters.
+func (c *RTUClient) WriteRegisters(ctx context.Context, slaveId byte, address uint16, values []byte) error {
+	quantity := len(values) / 2
+	if len(values)%2 != 0 || quantity == 0 || quantity > MaxWriteRegisters {
+		return fmt.Errorf("modbus: cannot write %d bytes", len(values))
+	}
+
+	var pdu []byte
+	if quantity == 1 {
+		pdu = make([]byte, 5)
+		pdu[0] = FuncWriteSingleRegister
+		binary.BigEndian.PutUint16(pdu[1:], address)
+		copy(pdu[3:], values)
+	} else {
+		pdu = make([]byte, 6+len(values))
+		pdu[0] = FuncWriteMultipleRegisters
+		binary.BigEndian.PutUint16(pdu[1:], address)
+		binary.BigEndian.PutUint16(pdu[3:], uint16(quantity))
+		pdu[5] = byte(len(values))
+		copy(pdu[6:], values)
+	}
+
+	response, err := c.transaction(ctx, slaveId, pdu, func(header []byte) int {
+		// both functions answer with slave id, function code, address, value or quantity, crc
+		return 8
+	})
+	if err != nil {
+		return err
+	}
+
+	if binary.BigEndian.Uint16(response[2:]) != address {
+		return errors.New("modbus: write response does not match request")
+	}
+	return nil
+}
+
+// transaction sends pdu to slaveId and returns the validated response frame. responseLength
+// returns the length of a regular response given its first 3 bytes. The response is awaited
+// until the timeout of the line or the deadline of ctx, whichever comes first.
+func (c *RTUClient) transaction(ctx context.Context, slaveId byte, pdu []byte, responseLength func(header []byte) int) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed != nil {
+		return nil, c.failed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := make([]byte, 0, len(pdu)+3)
+	request = append(request, slaveId)
+	request = append(request, pdu...)
+	crc := CRC16(request)
+	request = append(request, byte(crc), byte(crc>>8))
+
+	// the line must be silent for 3.5 characters before a new frame starts.
+	if wait := time.Until(c.lastActivity.Add(c.config.frameDelay())); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	// a late response to a request that timed out would be taken for the response to this one.
+	if port, ok := c.port.(inputFlusher); ok {
+		if err := port.FlushInput(); err != nil {
+			return nil, c.fail(err)
+		}
+	}
+
+	_, err := c.port.Write(request)
+	c.lastActivity = time.Now()
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	deadline := c.lastActivity.Add(c.config.charTime()*time.Duration(len(request)) + c.config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	response := make([]byte, 0, 3+2*MaxReadRegisters+2)
+
+	response, err = c.readAtLeast(ctx, response, 3, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	length := 5 // exception: slave id, function code, exception code, crc
+	if response[1]&0x80 == 0 {
+		length = responseLength(response)
+	}
+	response, err = c.readAtLeast(ctx, response, length, deadline)
+	if err != nil {
+		return nil, err
+	}
+	response = response[:length]
+
+	if crc := binary.LittleEndian.Uint16(response[length-2:]); crc != CRC16(response[:length-2]) {
+		return nil, errors.New("modbus: invalid response checksum")
+	}
+	if response[0] != slaveId {
+		return nil, fmt.Errorf("modbus: response from slave %d instead of %d", response[0], slaveId)
+	}
+	if response[1] == pdu[0]|0x80 {
+		return nil, &ExceptionError{FunctionCode: pdu[0], Code: response[2]}
+	}
+	if response[1] != pdu[0] {
+		return nil, fmt.Errorf("modbus: response to function 0x%02x instead of 0x%02x", response[1], pdu[0])
+	}
+
+	return response, nil
+}
+
+// readAtLeast reads from the port into buffer until it holds n bytes, deadline is reached or ctx
+// is canceled. The port is expected to return from reads periodically even when no data is
+// received.
+func (c *RTUClient) readAtLeast(ctx context.Context, buffer []byte, n int, deadline time.Time) ([]byte, error) {
+	chunk := make([]byte, n)
+	for len(buffer) < n {
+		if time.Now().After(deadline) {
+			return nil, ErrTimeout
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		read, err := c.port.Read(chunk[:n-len(buffer)])
+		if read > 0 {
+			buffer = append(buffer, chunk[:read]...)
+			c.lastActivity = time.Now()
+		}
+		if err != nil && err != io.EOF {
+			return nil, c.fail(err)
+		}
+	}
+	return buffer, nil
+}
+
+// fail breaks the client with err, an error of its port, and returns it.
+func (c *RTUClient) fail(err error) error {
+	c.failed = err
+	return err
+}
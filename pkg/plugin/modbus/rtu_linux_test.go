@@ -0,0 +1,275 @@
+package modbus_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/modbus"
+)
+
+// openPty returns the master side of a new pseudo-terminal along with the path of its slave side,
+// which stands for the serial port the client opens.
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("pseudo-terminals are not available: " + err.Error())
+	}
+
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Skip("cannot unlock pseudo-terminal: " + errno.Error())
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Skip("cannot get pseudo-terminal number: " + errno.Error())
+	}
+
+	return master, "/dev/pts/" + strconv.FormatUint(uint64(n), 10)
+}
+
+// simulatedSlave answers Modbus RTU requests sent to slaveId on the master side of a pty,
+// holding registers 0 to 99 and ignoring requests to other slaves.
+type simulatedSlave struct {
+	mu        sync.Mutex
+	slaveId   byte
+	registers [100]uint16
+	requests  int
+}
+
+func (s *simulatedSlave) serve(master *os.File) {
+	buffer := make([]byte, 0, 512)
+	chunk := make([]byte, 256)
+	for {
+		n, err := master.Read(chunk)
+		if err != nil {
+			return
+		}
+		buffer = append(buffer, chunk[:n]...)
+
+		length := requestLength(buffer)
+		if length == 0 || len(buffer) < length {
+			continue
+		}
+		request := buffer[:length]
+		buffer = buffer[:0]
+
+		if binary.LittleEndian.Uint16(request[length-2:]) != modbus.CRC16(request[:length-2]) || request[0] != s.slaveId {
+			continue
+		}
+		if response := s.handle(request[:length-2]); response != nil {
+			crc := modbus.CRC16(response)
+			master.Write(append(response, byte(crc), byte(crc>>8)))
+		}
+	}
+}
+
+func requestLength(buffer []byte) int {
+	if len(buffer) < 2 {
+		return 0
+	}
+	switch buffer[1] {
+	case modbus.FuncWriteMultipleRegisters:
+		if len(buffer) < 7 {
+			return 0
+		}
+		return 7 + int(buffer[6]) + 2
+	default:
+		return 8
+	}
+}
+
+func (s *simulatedSlave) handle(request []byte) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	function := request[1]
+	address := int(binary.BigEndian.Uint16(request[2:]))
+	exception := []byte{s.slaveId, function | 0x80, 2}
+
+	switch function {
+	case modbus.FuncReadHoldingRegisters, modbus.FuncReadInputRegisters:
+		quantity := int(binary.BigEndian.Uint16(request[4:]))
+		if address+quantity > len(s.registers) {
+			return exception
+		}
+		response := []byte{s.slaveId, function, byte(2 * quantity)}
+		for i := 0; i < quantity; i++ {
+			response = append(response, byte(s.registers[address+i]>>8), byte(s.registers[address+i]))
+		}
+		return response
+	case modbus.FuncWriteSingleRegister:
+		if address >= len(s.registers) {
+			return exception
+		}
+		s.registers[address] = binary.BigEndian.Uint16(request[4:])
+		return request[:6]
+	case modbus.FuncWriteMultipleRegisters:
+		quantity := int(binary.BigEndian.Uint16(request[4:]))
+		if address+quantity > len(s.registers) {
+			return exception
+		}
+		for i := 0; i < quantity; i++ {
+			s.registers[address+i] = binary.BigEndian.Uint16(request[7+2*i:])
+		}
+		return request[:6]
+	default:
+		return []byte{s.slaveId, function | 0x80, 1}
+	}
+}
+
+// newTestClient returns a client of slave along with the master side of the line, on which the
+// slave answers.
+func newTestClient(t *testing.T, slave *simulatedSlave) (*modbus.RTUClient, *os.File) {
+	master, path := openPty(t)
+
+	config := modbus.SerialConfig{
+		Port:     path,
+		BaudRate: 9600,
+		DataBits: 8,
+		Parity:   "E",
+		StopBits: 1,
+		Timeout:  300 * time.Millisecond,
+	}
+	port, err := modbus.OpenSerial(config)
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+
+	go slave.serve(master)
+	client := modbus.NewRTUClient(port, config)
+	t.Cleanup(func() {
+		client.Close()
+		master.Close()
+	})
+	return client, master
+}
+
+func TestCRC16(t *testing.T) {
+	crc := modbus.CRC16([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A})
+	if crc != 0xCDC5 {
+		t.Errorf("Expected crc 0xCDC5, got 0x%04X", crc)
+	}
+}
+
+func TestRTUReadWrite(t *testing.T) {
+	slave := &simulatedSlave{slaveId: 7}
+	slave.registers[10] = 0x4218
+	slave.registers[11] = 0xE400
+	client, _ := newTestClient(t, slave)
+
+	values, err := client.ReadRegisters(context.Background(), 7, modbus.FuncReadHoldingRegisters, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x42, 0x18, 0xE4, 0x00}; string(values) != string(expected) {
+		t.Errorf("Expected %X, got %X", expected, values)
+	}
+
+	if err := client.WriteRegisters(context.Background(), 7, 20, []byte{0x12, 0x34}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteRegisters(context.Background(), 7, 21, []byte{0xAB, 0xCD, 0xEF, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err = client.ReadRegisters(context.Background(), 7, modbus.FuncReadInputRegisters, 20, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x12, 0x34, 0xAB, 0xCD, 0xEF, 0x01}; string(values) != string(expected) {
+		t.Errorf("Expected %X, got %X", expected, values)
+	}
+}
+
+func TestRTUErrors(t *testing.T) {
+	slave := &simulatedSlave{slaveId: 7}
+	client, _ := newTestClient(t, slave)
+
+	_, err := client.ReadRegisters(context.Background(), 7, modbus.FuncReadHoldingRegisters, 99, 2)
+	var exception *modbus.ExceptionError
+	if !errors.As(err, &exception) || exception.Code != 2 {
+		t.Errorf("Expected an illegal address exception, got %v", err)
+	}
+
+	start := time.Now()
+	_, err = client.ReadRegisters(context.Background(), 8, modbus.FuncReadHoldingRegisters, 0, 1)
+	if err != modbus.ErrTimeout {
+		t.Errorf("Expected a timeout for an absent slave, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Timeout took too long: %s", elapsed)
+	}
+
+	// the line must still be usable after a timeout.
+	if _, err := client.ReadRegisters(context.Background(), 7, modbus.FuncReadHoldingRegisters, 0, 1); err != nil {
+		t.Errorf("Expected a successful read after a timeout, got %v", err)
+	}
+}
+
+func TestRTUStaleInput(t *testing.T) {
+	slave := &simulatedSlave{slaveId: 7}
+	slave.registers[0] = 0x1234
+	client, master := newTestClient(t, slave)
+
+	// a late response to an earlier request, which must not be taken for the next one.
+	stale := []byte{7, modbus.FuncReadHoldingRegisters, 2, 0xDE, 0xAD}
+	crc := modbus.CRC16(stale)
+	if _, err := master.Write(append(stale, byte(crc), byte(crc>>8))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	values, err := client.ReadRegisters(context.Background(), 7, modbus.FuncReadHoldingRegisters, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != 0x12 || values[1] != 0x34 {
+		t.Errorf("Expected the current response, got % X", values)
+	}
+}
+
+func TestRTUCanceled(t *testing.T) {
+	slave := &simulatedSlave{slaveId: 7}
+	client, _ := newTestClient(t, slave)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.ReadRegisters(ctx, 7, modbus.FuncReadHoldingRegisters, 0, 1); err != context.Canceled {
+		t.Errorf("Expected the cancellation, got %v", err)
+	}
+	slave.mu.Lock()
+	defer slave.mu.Unlock()
+	if slave.requests != 0 {
+		t.Errorf("Expected no request to be sent, got %d", slave.requests)
+	}
+}
+
+func TestRTUPortFailure(t *testing.T) {
+	slave := &simulatedSlave{slaveId: 7}
+	client, master := newTestClient(t, slave)
+	if client.Err() != nil {
+		t.Fatalf("Expected a working client, got %v", client.Err())
+	}
+
+	// hanging up the line fails the port.
+	master.Close()
+	_, err := client.ReadRegisters(context.Background(), 7, modbus.FuncReadHoldingRegisters, 0, 1)
+	if err == nil || err == modbus.ErrTimeout {
+		t.Fatalf("Expected the failure of the port, got %v", err)
+	}
+	if client.Err() != err {
+		t.Errorf("Expected the client to keep the failure, got %v", client.Err())
+	}
+}
@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package modbus
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// tcflsh is the ioctl discarding the queues of a terminal, which syscall only names on some
+// architectures. It is the same on those plugins are built for (amd64, arm and arm64).
+const tcflsh = 0x540B
+
+var baudRates = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+}
+
+// serialPort is a serial port opened by OpenSerial.
+type serialPort struct {
+	*os.File
+}
+
+// FlushInput discards the bytes received but not read yet.
+func (p serialPort) FlushInput() error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, p.Fd(), tcflsh, syscall.TCIFLUSH)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// OpenSerial opens and configures the serial port described by config in raw mode. Reads on
+// the returned port return after at most config.Timeout (rounded to 100ms) without data, and its
+// input can be flushed.
+func OpenSerial(config SerialConfig) (io.ReadWriteCloser, error) {
+	speed, ok := baudRates[config.BaudRate]
+	if !ok {
+		return nil, fmt.Errorf("modbus: unsupported baud rate %d", config.BaudRate)
+	}
+
+	cflag := speed | syscall.CREAD | syscall.CLOCAL
+	switch config.DataBits {
+	case 7:
+		cflag |= syscall.CS7
+	case 8:
+		cflag |= syscall.CS8
+	default:
+		return nil, fmt.Errorf("modbus: unsupported data bits %d", config.DataBits)
+	}
+	switch config.Parity {
+	case "N":
+	case "E":
+		cflag |= syscall.PARENB
+	case "O":
+		cflag |= syscall.PARENB | syscall.PARODD
+	default:
+		return nil, fmt.Errorf("modbus: unsupported parity '%s'", config.Parity)
+	}
+	switch config.StopBits {
+	case 1:
+	case 2:
+		cflag |= syscall.CSTOPB
+	default:
+		return nil, fmt.Errorf("modbus: unsupported stop bits %d", config.StopBits)
+	}
+
+	// VTIME is expressed in tenths of a second, a read returns as soon as a byte is received.
+	vtime := config.Timeout.Milliseconds() / 100
+	if vtime < 1 {
+		vtime = 1
+	} else if vtime > 255 {
+		vtime = 255
+	}
+
+	termios := syscall.Termios{
+		Cflag:  cflag,
+		Ispeed: speed,
+		Ospeed: speed,
+	}
+	termios.Cc[syscall.VMIN] = 0
+	termios.Cc[syscall.VTIME] = uint8(vtime)
+
+	port, err := os.OpenFile(config.Port, syscall.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, port.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		port.Close()
+		return nil, errno
+	}
+
+	return serialPort{port}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package modbus
+
+import (
+	"errors"
+	"io"
+)
+
+// OpenSerial is only implemented on Linux.
+func OpenSerial(config SerialConfig) (io.ReadWriteCloser, error) {
+	return nil, errors.New("modbus: serial ports are not supported on this platform")
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+// filterNotices explains what the filter of qm left out of matched, the metrics it matched: the
+// listed devices or metrics that matched nothing. These may exist but be left out by the ad hoc
+// filters, which are then trusted.
+func filterNotices(qm queryModel, matched []database.Metric) []data.Notice {
+	filter := qm.Filter
+	notices := make([]data.Notice, 0)
+	if filter.Entity == "" || len(qm.AdhocFilters) > 0 {
+		return notices
+	}
+	if filter.Entity == "tags" {
+		if len(matched) == 0 {
+			notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: "No metric has the filtered tags."})
+		}
+		return notices
+	}
+	if filter.Entity == "assets" {
+		// matched metrics do not tell which of the listed assets they are under.
+		if len(matched) == 0 {
+			notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: "The filtered assets do not exist or have no metrics."})
+		}
+		return notices
+	}
+
+	found := make(map[int64]bool, len(matched))
+	for _, metric := range matched {
+		if filter.Entity == "devices" {
+			found[metric.DeviceId] = true
+		} else {
+			found[metric.Id] = true
+		}
+	}
+	for _, id := range filter.Ids {
+		if found[id] {
+			continue
+		}
+		found[id] = true
+
+		text := "Metric " + strconv.FormatInt(id, 10) + " does not exist."
+		if filter.Entity == "devices" {
+			text = "Device " + strconv.FormatInt(id, 10) + " does not exist or has no metrics."
+		}
+		notices = append(notices, data.Notice{Severity: data.NoticeSeverityWarning, Text: text})
+	}
+	return notices
+}
+
+// noDataNotice tells that metric has no data in the queried range.
+func noDataNotice(metric *database.Metric) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     "Metric " + strconv.FormatInt(metric.Id, 10) + " (" + metric.Name + ") has no data in range.",
+	}
+}
+
+// appendNotices adds notices to the first frame of response, or to an empty frame if it has none.
+func appendNotices(response *backend.DataResponse, notices ...data.Notice) {
+	if len(notices) == 0 {
+		return
+	}
+	if len(response.Frames) == 0 {
+		response.Frames = append(response.Frames, data.NewFrame(""))
+	}
+	response.Frames[0].AppendNotices(notices...)
+}
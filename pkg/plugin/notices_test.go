@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+func TestFilterNotices(t *testing.T) {
+	matched := []database.Metric{{Id: 10, DeviceId: 1}, {Id: 11, DeviceId: 1}}
+
+	type TestCase struct {
+		filter        queryFilter
+		expectedTexts []string
+	}
+
+	cases := []TestCase{
+		{filter: queryFilter{}, expectedTexts: []string{}},
+		{filter: queryFilter{Entity: "metrics", Ids: []int64{10, 11}}, expectedTexts: []string{}},
+		{filter: queryFilter{Entity: "metrics", Ids: []int64{10, 42, 42}}, expectedTexts: []string{"Metric 42 does not exist."}},
+		{filter: queryFilter{Entity: "devices", Ids: []int64{1, 2}}, expectedTexts: []string{"Device 2 does not exist or has no metrics."}},
+	}
+
+	for _, tc := range cases {
+		notices := filterNotices(queryModel{Filter: tc.filter}, matched)
+		if len(notices) != len(tc.expectedTexts) {
+			t.Errorf("Expected %d notices for %v, got %v", len(tc.expectedTexts), tc.filter, notices)
+			continue
+		}
+		for i, text := range tc.expectedTexts {
+			if notices[i].Text != text || notices[i].Severity != data.NoticeSeverityWarning {
+				t.Errorf("Notice mismatch for %v: Expected warning %q, got %v", tc.filter, text, notices[i])
+			}
+		}
+	}
+}
+
+func TestAppendNotices(t *testing.T) {
+	response := &backend.DataResponse{}
+	appendNotices(response)
+	if len(response.Frames) != 0 {
+		t.Errorf("Expected no frame without notices")
+	}
+
+	notice := noDataNotice(&database.Metric{Id: 42, Name: "power"})
+	appendNotices(response, notice)
+	appendNotices(response, notice)
+	if len(response.Frames) != 1 || len(response.Frames[0].Meta.Notices) != 2 {
+		t.Fatalf("Expected both notices on a single frame, got %d frames", len(response.Frames))
+	}
+	if response.Frames[0].Meta.Notices[0].Text != "Metric 42 (power) has no data in range." {
+		t.Errorf("Unexpected notice %q", response.Frames[0].Meta.Notices[0].Text)
+	}
+}
@@ -4,63 +4,397 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"strconv"
+	"strings"
 )
 
-// Deprecated: The plugin does not need to parse data, as it is already saved as a double in the database.
-func GetBytesToDoubleParser(format string, order string) func([]byte) (float64, error) {
-	flip := func(bytes []byte) {
-		// TODO: Any drawbacks in mutating this?
-		i := 1
-		for i < len(bytes) {
-			bytes[i], bytes[i-1] = bytes[i-1], bytes[i]
-			i += 2
-		}
+// formatWidths is the number of bytes of each fixed width data format. The width of "string"
+// and "bit<n>" formats is given by the byte order.
+var formatWidths = map[string]int{
+	"int16":    2,
+	"uint16":   2,
+	"bcd16":    2,
+	"int32":    4,
+	"uint32":   4,
+	"float32":  4,
+	"bcd32":    4,
+	"mod10k32": 4,
+	"int48":    6,
+	"uint48":   6,
+	"mod10k48": 6,
+	"int64":    8,
+	"uint64":   8,
+	"float64":  8,
+	"mod10k64": 8,
+}
+
+// parseOrder validates order, which names the bytes of a value from the most significant (A) on,
+// in the order they are read on the wire. It must be big-endian (ABCD), little-endian (DCBA), or
+// either with the bytes of each register swapped (BADC, CDAB).
+func parseOrder(order string) (littleEndian bool, flipped bool, err error) {
+	n := len(order)
+	if n == 0 || n%2 != 0 || n > 26 {
+		return false, false, errors.New("invalid byte order '" + order + "'")
 	}
 
-	isBigEndian := false
-	isFlipped := false
+	big := make([]byte, n)
+	for i := range big {
+		big[i] = byte('A' + i)
+	}
+	reverse := func(b []byte) []byte {
+		r := make([]byte, len(b))
+		for i := range b {
+			r[len(b)-1-i] = b[i]
+		}
+		return r
+	}
+	flip := func(b []byte) []byte {
+		f := make([]byte, len(b))
+		for i := 0; i+1 < len(b); i += 2 {
+			f[i], f[i+1] = b[i+1], b[i]
+		}
+		return f
+	}
 
 	switch order {
-	case "AB", "ABCD", "BADC", "ABCDEFGH", "BADCFEHG":
-		isBigEndian = true
+	case string(big):
+		return false, false, nil
+	case string(reverse(big)):
+		return true, false, nil
+	case string(flip(big)):
+		return false, true, nil
+	case string(reverse(flip(big))):
+		return true, true, nil
+	default:
+		return false, false, errors.New("invalid byte order '" + order + "'")
 	}
+}
 
-	switch order {
-	case "BADC", "CDAB", "BADCFEHG", "GHEFCDAB":
-		isFlipped = true
+// bitIndex returns n for formats named bit<n>, designating a single bit of the value, or -1.
+func bitIndex(format string) int {
+	if !strings.HasPrefix(format, "bit") {
+		return -1
+	}
+	n, err := strconv.Atoi(format[3:])
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+var (
+	ErrInputLength = errors.New("incompatible input bytes")
+	ErrBCD         = errors.New("invalid BCD digit")
+	ErrMod10k      = errors.New("register out of range for 10000-base value")
+	ErrASCII       = errors.New("invalid ASCII character")
+)
+
+// Decoder decodes the raw bytes of the registers of a metric, as read on the wire, according to
+// its data format and byte order. Both are validated once by NewDecoder; decoding never mutates
+// its input and, except for strings, does not allocate.
+type Decoder struct {
+	format       string
+	width        int
+	littleEndian bool
+	flipped      bool
+	bit          int
+	// decode decodes numeric formats, resolved by NewDecoder; it is nil for strings.
+	decode func(d *Decoder, b []byte) (float64, error)
+}
+
+// decoders decode the numeric formats other than bit<n>.
+var decoders = map[string]func(d *Decoder, b []byte) (float64, error){
+	"int16":  func(d *Decoder, b []byte) (float64, error) { return float64(int16(d.uint(b))), nil },
+	"uint16": (*Decoder).unsigned,
+	"uint32": (*Decoder).unsigned,
+	"uint48": (*Decoder).unsigned,
+	"uint64": (*Decoder).unsigned,
+	"int32":  func(d *Decoder, b []byte) (float64, error) { return float64(int32(d.uint(b))), nil },
+	"float32": func(d *Decoder, b []byte) (float64, error) {
+		return float64(math.Float32frombits(uint32(d.uint(b)))), nil
+	},
+	// sign extend from the 48th bit.
+	"int48":    func(d *Decoder, b []byte) (float64, error) { return float64(int64(d.uint(b)<<16) >> 16), nil },
+	"int64":    func(d *Decoder, b []byte) (float64, error) { return float64(int64(d.uint(b))), nil },
+	"float64":  func(d *Decoder, b []byte) (float64, error) { return math.Float64frombits(d.uint(b)), nil },
+	"bcd16":    (*Decoder).bcd,
+	"bcd32":    (*Decoder).bcd,
+	"mod10k32": (*Decoder).mod10k,
+	"mod10k48": (*Decoder).mod10k,
+	"mod10k64": (*Decoder).mod10k,
+}
+
+// NewDecoder returns a Decoder for format and order, or an error if they are unknown or do not
+// match. The width of "string" and "bit<n>" formats is given by order.
+func NewDecoder(format string, order string) (*Decoder, error) {
+	littleEndian, flipped, err := parseOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Decoder{
+		format:       format,
+		width:        len(order),
+		littleEndian: littleEndian,
+		flipped:      flipped,
+		bit:          bitIndex(format),
+		decode:       decoders[format],
+	}
+	if d.bit >= 0 {
+		d.decode = (*Decoder).bitValue
+	}
+
+	width, known := formatWidths[format]
+	switch {
+	case d.bit >= 0:
+		if d.bit >= 8*d.width || d.bit >= 64 {
+			return nil, errors.New("bit " + strconv.Itoa(d.bit) + " out of range for order " + order)
+		}
+	case format == "string":
+	case !known:
+		return nil, errors.New("unknown format " + format)
+	case width != d.width:
+		return nil, errors.New("order " + order + " does not match format " + format)
+	}
+
+	return d, nil
+}
+
+// Width returns the number of bytes of a value.
+func (d *Decoder) Width() int {
+	return d.width
+}
+
+// Modulus returns the number of distinct values of format, at which a counter stored in that
+// format wraps around, or 0 for floating point, string, bit and unknown formats.
+func Modulus(format string) float64 {
+	width := formatWidths[format]
+	switch {
+	case integerRanges[format][1] != 0:
+		return math.Pow(2, float64(8*width))
+	case strings.HasPrefix(format, "bcd"):
+		// two digits per byte.
+		return math.Pow(10, float64(2*width))
+	case strings.HasPrefix(format, "mod10k"):
+		// each register holds 0 to 9999.
+		return math.Pow(10000, float64(width/2))
+	default:
+		return 0
+	}
+}
+
+// at returns the i-th most significant byte of the value in b.
+func (d *Decoder) at(b []byte, i int) byte {
+	if d.littleEndian {
+		i = d.width - 1 - i
+	}
+	if d.flipped {
+		i ^= 1
+	}
+	return b[i]
+}
+
+// uint returns the value in b, at most 8 bytes long, as an unsigned integer.
+func (d *Decoder) uint(b []byte) uint64 {
+	var value uint64
+	for i := 0; i < d.width; i++ {
+		value = value<<8 | uint64(d.at(b, i))
+	}
+	return value
+}
+
+// Decode decodes the single value held by b, which must be exactly Width bytes long.
+func (d *Decoder) Decode(b []byte) (float64, error) {
+	if len(b) != d.width {
+		return 0, ErrInputLength
+	}
+
+	if d.decode == nil {
+		return 0, errors.New("format " + d.format + " is not numeric")
+	}
+	return d.decode(d, b)
+}
+
+func (d *Decoder) unsigned(b []byte) (float64, error) {
+	return float64(d.uint(b)), nil
+}
+
+// bitValue decodes the single bit of bit<n> formats.
+func (d *Decoder) bitValue(b []byte) (float64, error) {
+	return float64(d.uint(b) >> d.bit & 1), nil
+}
+
+// DecodeBlock decodes len(values) consecutive values from block, which holds the registers of
+// as many values of the format of the decoder.
+func (d *Decoder) DecodeBlock(block []byte, values []float64) error {
+	if len(block) != d.width*len(values) {
+		return ErrInputLength
+	}
+
+	for i := range values {
+		value, err := d.Decode(block[i*d.width : (i+1)*d.width])
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	return nil
+}
+
+// DecodeString decodes the ASCII string held by b. Trailing NUL bytes and spaces are trimmed.
+func (d *Decoder) DecodeString(b []byte) (string, error) {
+	if d.format != "string" {
+		return "", errors.New("format " + d.format + " is not a string")
+	}
+	if len(b) != d.width {
+		return "", ErrInputLength
+	}
+
+	length := d.width
+	for length > 0 && (d.at(b, length-1) == 0 || d.at(b, length-1) == ' ') {
+		length--
+	}
+
+	s := make([]byte, length)
+	for i := range s {
+		if s[i] = d.at(b, i); s[i] > 0x7F {
+			return "", ErrASCII
+		}
+	}
+	return string(s), nil
+}
+
+// bcd decodes packed binary coded decimal, two digits per byte.
+func (d *Decoder) bcd(b []byte) (float64, error) {
+	var value float64
+	for i := 0; i < d.width; i++ {
+		c := d.at(b, i)
+		high, low := c>>4, c&0x0F
+		if high > 9 || low > 9 {
+			return 0, ErrBCD
+		}
+		value = value*100 + float64(high)*10 + float64(low)
+	}
+	return value, nil
+}
+
+// mod10k decodes Modicon "10000-base" values, where each signed register holds four decimal
+// digits (-9999 to 9999) of the value, the most significant register first.
+func (d *Decoder) mod10k(b []byte) (float64, error) {
+	var value float64
+	for i := 0; i < d.width; i += 2 {
+		register := int16(uint16(d.at(b, i))<<8 | uint16(d.at(b, i+1)))
+		if register > 9999 || register < -9999 {
+			return 0, ErrMod10k
+		}
+		value = value*10000 + float64(register)
+	}
+	return value, nil
+}
+
+// GetBytesToDoubleParser returns a function decoding a single value with a Decoder for format and
+// order, which reports unknown formats and orders when called.
+//
+// Deprecated: use NewDecoder, which reports errors upfront.
+func GetBytesToDoubleParser(format string, order string) func([]byte) (float64, error) {
+	decoder, err := NewDecoder(format, order)
+	if err != nil {
+		return func([]byte) (float64, error) {
+			return 0, err
+		}
 	}
+	return decoder.Decode
+}
 
-	var parser binary.ByteOrder
-	if isBigEndian {
-		parser = binary.BigEndian
-	} else {
-		parser = binary.LittleEndian
+// GetBytesToStringParser returns a function decoding an ASCII string with a Decoder for format
+// and order, which reports unknown formats and orders when called.
+//
+// Deprecated: use NewDecoder, which reports errors upfront.
+func GetBytesToStringParser(format string, order string) func([]byte) (string, error) {
+	decoder, err := NewDecoder(format, order)
+	if err == nil && format != "string" {
+		err = errors.New("unknown format " + format)
+	}
+	if err != nil {
+		return func([]byte) (string, error) {
+			return "", err
+		}
 	}
+	return decoder.DecodeString
+}
+
+// integerRanges are the bounds of the values integer formats can encode, the upper one excluded.
+var integerRanges = map[string][2]float64{
+	"int16":  {math.MinInt16, math.MaxInt16 + 1},
+	"uint16": {0, math.MaxUint16 + 1},
+	"int32":  {math.MinInt32, math.MaxInt32 + 1},
+	"uint32": {0, math.MaxUint32 + 1},
+	"int48":  {-(1 << 47), 1 << 47},
+	"uint48": {0, 1 << 48},
+	"int64":  {math.MinInt64, -math.MinInt64},
+	"uint64": {0, 1 << 64},
+}
 
-	return func(bytes []byte) (float64, error) {
-		if len(order) != len(bytes) {
-			return 0, errors.New("incompatible input bytes")
+// GetDoubleToBytesEncoder returns the inverse of GetBytesToDoubleParser for integer and floating
+// point formats: a function encoding a value into the raw bytes of the registers of a metric,
+// ready to be written on the wire. Values the format cannot hold exactly, such as fractions for
+// integers, are rejected rather than truncated or wrapped, as are NaN and infinities. BCD and
+// mod10k formats cannot be written.
+func GetDoubleToBytesEncoder(format string, order string) func(float64) ([]byte, error) {
+	littleEndian, flipped, err := parseOrder(order)
+	if err == nil && formatWidths[format] != len(order) {
+		err = errors.New("cannot encode format " + format + " with order " + order)
+	}
+	if err != nil {
+		return func(float64) ([]byte, error) {
+			return nil, err
 		}
+	}
 
-		if isFlipped {
-			flip(bytes)
+	return func(value float64) ([]byte, error) {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, errors.New("cannot encode " + strconv.FormatFloat(value, 'g', -1, 64))
 		}
+		b := make([]byte, len(order))
 
-		switch format {
-		case "int16":
-			return float64(int16(parser.Uint16(bytes))), nil
-		case "uint16":
-			return float64(parser.Uint16(bytes)), nil
-		case "int32":
-			return float64(int32(parser.Uint32(bytes))), nil
-		case "uint32":
-			return float64(parser.Uint32(bytes)), nil
-		case "float32":
-			return float64(math.Float32frombits(parser.Uint32(bytes))), nil
-		case "float64":
-			return math.Float64frombits(parser.Uint64(bytes)), nil
-		default:
-			panic(errors.New("unknown format " + format))
+		if bounds, ok := integerRanges[format]; ok {
+			if value != math.Trunc(value) || value < bounds[0] || value >= bounds[1] {
+				return nil, errors.New("cannot encode " + strconv.FormatFloat(value, 'g', -1, 64) + " as " + format)
+			}
+			var raw uint64
+			if bounds[0] < 0 {
+				raw = uint64(int64(value))
+			} else {
+				raw = uint64(value)
+			}
+			for i := range b {
+				b[len(b)-1-i] = byte(raw >> (8 * i))
+			}
+		} else {
+			switch format {
+			case "float32":
+				if math.Abs(value) > math.MaxFloat32 {
+					return nil, errors.New("cannot encode " + strconv.FormatFloat(value, 'g', -1, 64) + " as " + format)
+				}
+				binary.BigEndian.PutUint32(b, math.Float32bits(float32(value)))
+			case "float64":
+				binary.BigEndian.PutUint64(b, math.Float64bits(value))
+			default:
+				return nil, errors.New("cannot encode format " + format)
+			}
+		}
+
+		// swapping and reversing commute, and are their own inverse.
+		if flipped {
+			for i := 1; i < len(b); i += 2 {
+				b[i], b[i-1] = b[i-1], b[i]
+			}
+		}
+		if littleEndian {
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
 		}
+		return b, nil
 	}
 }
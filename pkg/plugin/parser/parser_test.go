@@ -65,6 +65,49 @@ func TestParser(t *testing.T) {
 				},
 			},
 		},
+		{
+			rawBytes: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFE},
+			values: []SubTestCase{
+				{format: "int48", order: "ABCDEF", expectedValue: -2},
+				{format: "uint48", order: "ABCDEF", expectedValue: 281474976710654},
+				{format: "int48", order: "FEDCBA", expectedValue: -1099511627777},
+			},
+		},
+		{
+			rawBytes: []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02},
+			values: []SubTestCase{
+				{format: "int64", order: "ABCDEFGH", expectedValue: 4294967298},
+				{format: "uint64", order: "ABCDEFGH", expectedValue: 4294967298},
+				{format: "int64", order: "GHEFCDAB", expectedValue: 562949953486848},
+				{format: "mod10k64", order: "ABCDEFGH", expectedValue: 100000002},
+			},
+		},
+		{
+			rawBytes: []byte{0x12, 0x34, 0x56, 0x78},
+			values: []SubTestCase{
+				{format: "bcd32", order: "ABCD", expectedValue: 12345678},
+				{format: "bcd32", order: "CDAB", expectedValue: 56781234},
+				{format: "bit0", order: "ABCD", expectedValue: 0},
+				{format: "bit3", order: "ABCD", expectedValue: 1},
+				{format: "bit28", order: "ABCD", expectedValue: 1},
+			},
+		},
+		{
+			rawBytes: []byte{0x12, 0x34},
+			values: []SubTestCase{
+				{format: "bcd16", order: "AB", expectedValue: 1234},
+				{format: "bit4", order: "AB", expectedValue: 1},
+				{format: "bit4", order: "BA", expectedValue: 1},
+				{format: "bit5", order: "BA", expectedValue: 0},
+			},
+		},
+		{
+			rawBytes: []byte{0x00, 0x0C, 0x04, 0xD2},
+			values: []SubTestCase{
+				{format: "mod10k32", order: "ABCD", expectedValue: 121234},
+				{format: "mod10k32", order: "CDAB", expectedValue: 12340012},
+			},
+		},
 	}
 
 	isValid := func(val float64, expected float64) bool {
@@ -88,3 +131,240 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestEncoderRoundTrip(t *testing.T) {
+
+	type TestCase struct {
+		format string
+		order  string
+		value  float64
+	}
+
+	cases := []TestCase{
+		{format: "int16", order: "AB", value: -1234},
+		{format: "uint16", order: "BA", value: 65000},
+		{format: "int32", order: "CDAB", value: -123456},
+		{format: "uint32", order: "BADC", value: 3825222168},
+		{format: "int48", order: "ABCDEF", value: -123456789012},
+		{format: "uint48", order: "FEDCBA", value: 281474976710655},
+		{format: "int64", order: "ABCDEFGH", value: -1 << 62},
+		{format: "uint64", order: "HGFEDCBA", value: 1 << 63},
+		{format: "float32", order: "ABCD", value: 38.25},
+		{format: "float64", order: "GHEFCDAB", value: -9463.5},
+	}
+
+	for i, tc := range cases {
+		raw, err := parser.GetDoubleToBytesEncoder(tc.format, tc.order)(tc.value)
+		if err != nil {
+			t.Errorf("Error for test %d: %s", i, err.Error())
+			continue
+		}
+		val, err := parser.GetBytesToDoubleParser(tc.format, tc.order)(raw)
+		if err != nil {
+			t.Errorf("Error for test %d: %s", i, err.Error())
+		}
+		if val != tc.value {
+			t.Errorf("Value mismatch for test %d: Expected %f, got %f", i, tc.value, val)
+		}
+	}
+
+	if _, err := parser.GetDoubleToBytesEncoder("float32", "AB")(1); err == nil {
+		t.Errorf("Expected an error for an order too short for the format")
+	}
+
+	rejected := []TestCase{
+		{format: "int16", order: "AB", value: 32768},
+		{format: "int16", order: "AB", value: -32769},
+		{format: "uint16", order: "AB", value: -1},
+		{format: "uint32", order: "ABCD", value: 4294967296},
+		{format: "uint48", order: "ABCDEF", value: 1 << 48},
+		{format: "int64", order: "ABCDEFGH", value: 1 << 63},
+		{format: "uint64", order: "ABCDEFGH", value: 1 << 64},
+		{format: "int32", order: "ABCD", value: 1.5},
+		{format: "float32", order: "ABCD", value: 1e39},
+		{format: "float64", order: "ABCDEFGH", value: math.NaN()},
+		{format: "float64", order: "ABCDEFGH", value: math.Inf(-1)},
+		{format: "bcd16", order: "AB", value: 12},
+	}
+	for _, tc := range rejected {
+		if raw, err := parser.GetDoubleToBytesEncoder(tc.format, tc.order)(tc.value); err == nil {
+			t.Errorf("Expected %v to be rejected as %s, got % X", tc.value, tc.format, raw)
+		}
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+
+	type TestCase struct {
+		format   string
+		order    string
+		rawBytes []byte
+	}
+
+	cases := []TestCase{
+		{format: "int24", order: "ABC", rawBytes: []byte{0x00, 0x00, 0x00}},
+		{format: "unknown", order: "AB", rawBytes: []byte{0x00, 0x00}},
+		{format: "int32", order: "ACBD", rawBytes: []byte{0x00, 0x00, 0x00, 0x00}},
+		{format: "int32", order: "AB", rawBytes: []byte{0x00, 0x00}},
+		{format: "int16", order: "AB", rawBytes: []byte{0x00, 0x00, 0x00}},
+		{format: "bcd16", order: "AB", rawBytes: []byte{0x1A, 0x00}},
+		{format: "mod10k32", order: "ABCD", rawBytes: []byte{0x27, 0x10, 0x00, 0x00}},
+		{format: "bit16", order: "AB", rawBytes: []byte{0x00, 0x00}},
+	}
+
+	for i, tc := range cases {
+		if _, err := parser.GetBytesToDoubleParser(tc.format, tc.order)(tc.rawBytes); err == nil {
+			t.Errorf("Expected an error for test %d (%s, %s)", i, tc.format, tc.order)
+		}
+	}
+}
+
+func TestStringParser(t *testing.T) {
+
+	type TestCase struct {
+		order         string
+		rawBytes      []byte
+		expectedValue string
+	}
+
+	cases := []TestCase{
+		{order: "ABCDEFGH", rawBytes: []byte("SN-42\x00\x00\x00"), expectedValue: "SN-42"},
+		{order: "BADCFEHG", rawBytes: []byte("NS4- 2  "), expectedValue: "SN-42"},
+		{order: "ABCD", rawBytes: []byte("AB  "), expectedValue: "AB"},
+	}
+
+	for i, tc := range cases {
+		val, err := parser.GetBytesToStringParser("string", tc.order)(tc.rawBytes)
+		if err != nil {
+			t.Errorf("Error for test %d: %s", i, err.Error())
+		}
+		if val != tc.expectedValue {
+			t.Errorf("Value mismatch for test %d: Expected %q, got %q", i, tc.expectedValue, val)
+		}
+	}
+
+	if _, err := parser.GetBytesToStringParser("string", "AB")([]byte{0xC3, 0xA9}); err == nil {
+		t.Errorf("Expected an error for non ASCII bytes")
+	}
+}
+
+func TestDecoderDoesNotMutateInput(t *testing.T) {
+	raw := []byte{0x42, 0x18, 0xE4, 0x00}
+	decoder, err := parser.NewDecoder("float32", "BADC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		val, err := decoder.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(val-2.5074362e-24) > 1e-7 {
+			t.Errorf("Value mismatch on decode %d: got %g", i, val)
+		}
+	}
+	if raw[0] != 0x42 || raw[1] != 0x18 || raw[2] != 0xE4 || raw[3] != 0x00 {
+		t.Errorf("Input was mutated: %X", raw)
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	decoder, err := parser.NewDecoder("int16", "AB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]float64, 3)
+	if err := decoder.DecodeBlock([]byte{0x00, 0x01, 0xFF, 0xFF, 0x01, 0x00}, values); err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != 1 || values[1] != -1 || values[2] != 256 {
+		t.Errorf("Value mismatch: got %v", values)
+	}
+
+	if err := decoder.DecodeBlock([]byte{0x00, 0x01, 0xFF}, values); err == nil {
+		t.Errorf("Expected an error for a block of the wrong size")
+	}
+}
+
+func TestDecoderAllocations(t *testing.T) {
+	formats := map[string]string{
+		"int16":    "BA",
+		"float32":  "CDAB",
+		"uint48":   "ABCDEF",
+		"float64":  "GHEFCDAB",
+		"bcd32":    "ABCD",
+		"mod10k32": "ABCD",
+		"bit3":     "AB",
+	}
+
+	raw := make([]byte, 8)
+	for format, order := range formats {
+		decoder, err := parser.NewDecoder(format, order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := raw[:decoder.Width()]
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _ = decoder.Decode(b)
+		})
+		if allocs != 0 {
+			t.Errorf("Expected no allocation decoding %s, got %f", format, allocs)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	decoder, err := parser.NewDecoder("float32", "CDAB")
+	if err != nil {
+		b.Fatal(err)
+	}
+	raw := []byte{0x42, 0x18, 0xE4, 0x00}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = decoder.Decode(raw)
+	}
+}
+
+func BenchmarkDecodeBlock(b *testing.B) {
+	decoder, err := parser.NewDecoder("uint32", "ABCD")
+	if err != nil {
+		b.Fatal(err)
+	}
+	// a full 125 registers read, minus the odd one.
+	block := make([]byte, 248)
+	values := make([]float64, len(block)/decoder.Width())
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(block)))
+	for i := 0; i < b.N; i++ {
+		_ = decoder.DecodeBlock(block, values)
+	}
+}
+
+func TestModulus(t *testing.T) {
+	expected := map[string]float64{
+		"int16":    1 << 16,
+		"uint16":   1 << 16,
+		"uint32":   1 << 32,
+		"int48":    1 << 48,
+		"uint64":   1 << 64,
+		"int64":    1 << 64,
+		"bcd16":    1e4,
+		"bcd32":    1e8,
+		"mod10k32": 1e8,
+		"mod10k48": 1e12,
+		"mod10k64": 1e16,
+		"float32":  0,
+		"string":   0,
+		"bit3":     0,
+		"unknown":  0,
+	}
+	for format, modulus := range expected {
+		if m := parser.Modulus(format); m != modulus {
+			t.Errorf("Expected the modulus of %s to be %g, got %g", format, modulus, m)
+		}
+	}
+}
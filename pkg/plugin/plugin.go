@@ -2,10 +2,10 @@ package plugin
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
 	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/helper"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
 	"strconv"
 	"strings"
 	"time"
@@ -13,8 +13,13 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Make sure SampleDatasource implements required interfaces. This is important to do
@@ -30,6 +35,7 @@ var (
 	_ backend.QueryDataHandler      = (*SampleDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*SampleDatasource)(nil)
 	_ backend.StreamHandler         = (*SampleDatasource)(nil)
+	_ backend.CallResourceHandler   = (*SampleDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*SampleDatasource)(nil)
 )
 
@@ -39,27 +45,68 @@ func NewSampleDatasource(settings backend.DataSourceInstanceSettings) (instancem
 	if err != nil {
 		return nil, err
 	}
+	rowLimit, err := helper.GetRowLimit(&settings)
+	if err != nil {
+		return nil, err
+	}
+	features, err := helper.GetFeatures(&settings)
+	if err != nil {
+		return nil, err
+	}
 	db, err := database.Connect(credentials)
 	if err != nil {
 		return nil, errors.New("cannot connect to database: " + err.Error())
 	}
+	db.RowLimit = rowLimit
+
+	ds := &SampleDatasource{
+		database:       db,
+		features:       features,
+		rtuPool:        newRTUPool(),
+		cache:          newMetricsDataCache(),
+		statsCollector: db.StatsCollector(settings.UID),
+		done:           make(chan struct{}),
+	}
+	ds.resourceHandler = httpadapter.New(newResourceMux(ds))
 
-	return &SampleDatasource{
-		database: db,
-	}, nil
+	// a failed registration only loses the pool statistics of this instance.
+	if err := prometheus.Register(ds.statsCollector); err != nil {
+		log.DefaultLogger.Warn("Cannot register database statistics", "error", err)
+	}
+
+	// queries load the metadata themselves if it cannot be loaded now.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.RefreshMetadata(ctx); err != nil {
+		log.DefaultLogger.Warn("Cannot load devices and metrics", "error", err)
+	}
+	go ds.refreshMetadata(metadataRefreshInterval)
+
+	return ds, nil
 }
 
 // SampleDatasource is an example datasource which can respond to data queries, reports
 // its health and has streaming skills.
 type SampleDatasource struct {
-	database *database.Database
+	database        *database.Database
+	features        *helper.Features
+	rtuPool         *rtuPool
+	cache           *metricsDataCache
+	resourceHandler backend.CallResourceHandler
+	statsCollector  prometheus.Collector
+	// done is closed when the instance is disposed.
+	done chan struct{}
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *SampleDatasource) Dispose() {
+	close(d.done)
+	prometheus.Unregister(d.statsCollector)
 	d.database.Close()
+	d.rtuPool.Close()
+	d.cache.Clear()
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -69,36 +116,63 @@ func (d *SampleDatasource) Dispose() {
 func (d *SampleDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	log.DefaultLogger.Info("QueryData called", "request", req)
 
+	// the tracer is the one datasource.Manage sets up from the environment given by Grafana, whose
+	// span the SDK already put in ctx so that the spans of the plugin join its traces.
+	ctx, span := tracing.DefaultTracer().Start(ctx, "QueryData", trace.WithAttributes(attribute.Int("queries", len(req.Queries))))
+	defer span.End()
+
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
 	// loop over queries and execute them individually.
 	for _, q := range req.Queries {
+		queryCtx, querySpan := tracing.DefaultTracer().Start(ctx, "query "+q.RefID, trace.WithAttributes(attribute.String("refId", q.RefID)))
+
+		start := time.Now()
 		res := &backend.DataResponse{}
-		// Unmarshal the JSON into our queryModel.
-		var qm queryModel
-		res.Error = json.Unmarshal(q.JSON, &qm)
-		if res.Error != nil {
+		// Decode the JSON into our queryModel, whatever its version.
+		qm, err := parseQuery(q.JSON)
+		querySpan.SetAttributes(attribute.String("entity", string(qm.Entity)))
+		if err != nil {
+			res.Error = err
+			observeQuery(string(qm.Entity), start, res)
+			endQuerySpan(querySpan, res)
 			response.Responses[q.RefID] = *res
 			continue
 		}
+		qm.fromAlert = req.Headers["FromAlert"] == "true"
 
 		switch qm.Entity {
-		case "Devices":
-			res = d.handleDevicesQuery(req.PluginContext, q, qm)
-			break
-		case "Metrics":
-			res = d.handleMetricsQuery(req.PluginContext, q, qm)
-			break
-		case "MetricsData":
-			res = d.handleMetricsDataQuery(req.PluginContext, q, qm)
-			break
-		default:
-			res = &backend.DataResponse{
-				Error: errors.New("unknown entity '" + qm.Entity + "'"),
-			}
+		case entityDevices:
+			res = d.handleDevicesQuery(queryCtx, req.PluginContext, q, qm)
+		case entityMetrics:
+			res = d.handleMetricsQuery(queryCtx, req.PluginContext, q, qm)
+		case entityMetricsData:
+			res = d.handleMetricsDataQuery(queryCtx, req.PluginContext, q, qm)
+		case entityMetricsStats:
+			res = d.handleMetricsStatsQuery(queryCtx, req.PluginContext, q, qm)
+		case entityDeviceAvailability:
+			res = d.handleDeviceAvailabilityQuery(queryCtx, req.PluginContext, q, qm)
+		case entityAnnotations:
+			res = d.handleAnnotationsQuery(queryCtx, req.PluginContext, q, qm)
+		case entityAlarms:
+			res = d.handleAlarmsQuery(queryCtx, req.PluginContext, q, qm)
+		case entityLiveRead:
+			res = d.handleLiveReadQuery(queryCtx, req.PluginContext, q, qm)
+		case entityRawSQL:
+			res = d.handleRawSQLQuery(queryCtx, req.PluginContext, q, qm)
+		case entityVariableQuery:
+			res = d.handleVariableQuery(queryCtx, req.PluginContext, q, qm)
+		case entityAssets:
+			res = d.handleAssetsQuery(queryCtx, req.PluginContext, q, qm)
+		}
+		if res.Error == nil {
+			appendNotices(res, qm.notices...)
 		}
 
+		observeQuery(string(qm.Entity), start, res)
+		endQuerySpan(querySpan, res)
+
 		// save the response in a hashmap
 		// based on with RefID as identifier
 		response.Responses[q.RefID] = *res
@@ -107,10 +181,10 @@ func (d *SampleDatasource) QueryData(ctx context.Context, req *backend.QueryData
 	return response, nil
 }
 
-func (d *SampleDatasource) handleDevicesQuery(pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+func (d *SampleDatasource) handleDevicesQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
 	response := &backend.DataResponse{}
 
-	devices, err := d.database.QueryDevices()
+	devices, err := d.database.QueryDevices(ctx)
 	if err != nil {
 		response.Error = err
 		return response
@@ -142,19 +216,12 @@ func (d *SampleDatasource) handleDevicesQuery(pCtx backend.PluginContext, query
 	return response
 }
 
-func (d *SampleDatasource) handleMetricsQuery(pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+func (d *SampleDatasource) handleMetricsQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
 	response := &backend.DataResponse{}
 
-	var deviceIdsCsv *string
-	var _ *string // TODO: Use reflection to only return requested fields
-	if devices, ok := qm.Parameters["devices"]; ok {
-		deviceIdsCsv = &devices
-	}
-	if fields, ok := qm.Parameters["fields"]; ok {
-		_ = &fields
-	}
-
-	metrics, err := d.database.QueryMetrics(deviceIdsCsv)
+	// TODO: Use reflection to only return qm.Fields
+	filter := qm.Filter.databaseFilter()
+	metrics, err := d.database.QueryFilteredMetrics(ctx, &filter)
 	if err != nil {
 		response.Error = err
 		return response
@@ -169,6 +236,9 @@ func (d *SampleDatasource) handleMetricsQuery(pCtx backend.PluginContext, query
 	formats := make([]string, len(metrics))
 	orders := make([]string, len(metrics))
 	rates := make([]int32, len(metrics))
+	scales := make([]float64, len(metrics))
+	offsets := make([]float64, len(metrics))
+	engineeringUnits := make([]string, len(metrics))
 
 	for i, metric := range metrics {
 		ids[i] = metric.Id
@@ -180,6 +250,9 @@ func (d *SampleDatasource) handleMetricsQuery(pCtx backend.PluginContext, query
 		formats[i] = metric.DataFormat
 		orders[i] = metric.ByteOrder
 		rates[i] = metric.RefreshRate
+		scales[i] = metric.Scale
+		offsets[i] = metric.Offset
+		engineeringUnits[i] = metric.EngineeringUnit
 	}
 
 	frame := data.NewFrame("response")
@@ -195,6 +268,9 @@ func (d *SampleDatasource) handleMetricsQuery(pCtx backend.PluginContext, query
 		data.NewField("data_format", nil, formats),
 		data.NewField("byte_order", nil, orders),
 		data.NewField("refresh_rate", nil, rates),
+		data.NewField("scale", nil, scales),
+		data.NewField("offset", nil, offsets),
+		data.NewField("engineering_unit", nil, engineeringUnits),
 	)
 
 	// add the frames to the response.
@@ -203,51 +279,407 @@ func (d *SampleDatasource) handleMetricsQuery(pCtx backend.PluginContext, query
 	return response
 }
 
-func (d *SampleDatasource) handleMetricsDataQuery(pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+func (d *SampleDatasource) handleMetricsDataQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
 	response := &backend.DataResponse{}
 
-	var filter database.Filter
-	if filterType, ok := qm.Parameters["filter"]; ok {
-		filter.Entity = filterType
-	}
-	if entities, ok := qm.Parameters[filter.Entity]; ok {
-		filter.Value = entities
-	}
+	fill := fillOptions{Mode: qm.Format.Fill, Threshold: time.Duration(qm.GapThreshold)}
+	raw := qm.Format.Values == valuesRaw
 
-	devices, err := d.database.QueryMetricsData(&filter, query.TimeRange)
+	filter := qm.Filter.databaseFilter()
+	filter.Adhoc = qm.AdhocFilters
+	devices, err := d.queryMetricsData(ctx, &filter, query.TimeRange)
 	if err != nil {
 		response.Error = err
 		return response
 	}
 
+	matched := make([]database.Metric, 0)
 	for _, device := range devices {
 		for _, metric := range device.Metrics {
-			frame := metricToFrame(&device.Device, metric)
+			matched = append(matched, metric.Metric)
+			empty := len(metric.Values) == 0
+			// the wrap of counters is known from their raw format, before scaling forgets it.
+			wrap, err := counterWrap(&metric.Metric, raw)
+			if err != nil {
+				response.Error = err
+				return response
+			}
+			if !raw {
+				if err := applyScaling(metric); err != nil {
+					response.Error = err
+					return response
+				}
+			}
+			err = applyTransform(metric, qm.Aggregation.Transform, qm.Aggregation.IntegralUnit, wrap)
+			if err != nil {
+				response.Error = err
+				return response
+			}
+
+			if qm.Aggregation.Reduce == "last" {
+				frame := metricToNumericFrame(&device.Device, metric, qm.Format.States)
+				if empty {
+					frame.AppendNotices(noDataNotice(&metric.Metric))
+				}
+				response.Frames = append(response.Frames, frame)
+				continue
+			}
+
+			frame := metricToFrame(&device.Device, metric, fill, qm.Format.States)
+			if empty {
+				frame.AppendNotices(noDataNotice(&metric.Metric))
+			}
 
-			if qm.WithStreaming {
+			// alert rules are evaluated once per query, there is nobody to stream to. RunStream sends
+			// the values as stored, which would not match a transformed series.
+			if qm.WithStreaming && !qm.fromAlert && qm.Aggregation.Transform == "" {
 				channel := live.Channel{
 					Scope:     live.ScopeDatasource,
 					Namespace: pCtx.DataSourceInstanceSettings.UID,
-					Path:      "stream/metric/" + strconv.FormatInt(metric.Metric.Id, 10),
+					Path:      metricStream{MetricId: metric.Metric.Id, Raw: raw, States: qm.Format.States}.path(),
 				}
-				frame.SetMeta(&data.FrameMeta{Channel: channel.String()})
+				frame.Meta.Channel = channel.String()
 			}
 
 			response.Frames = append(response.Frames, frame)
 		}
 	}
+	appendNotices(response, filterNotices(qm, matched)...)
+
+	return response
+}
+
+func (d *SampleDatasource) handleMetricsStatsQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+
+	percentiles := qm.Aggregation.Percentiles
+	raw := qm.Format.Values == valuesRaw
+
+	queried := percentiles
+	if !raw {
+		queried = mirroredPercentiles(percentiles)
+	}
+
+	filter := qm.Filter.databaseFilter()
+	stats, err := d.database.QueryMetricsStats(ctx, &filter, query.TimeRange, queried)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if !raw {
+		if err := scaleStats(stats); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	response.Frames = append(response.Frames, statsToFrame(stats, percentiles))
+	matched := make([]database.Metric, len(stats))
+	for i, s := range stats {
+		matched[i] = s.Metric
+	}
+	appendNotices(response, filterNotices(qm, matched)...)
+	if len(stats) > 0 && stats[0].PercentilesTruncated {
+		appendNotices(response, percentilesTruncationNotice())
+	}
+
+	return response
+}
+
+func (d *SampleDatasource) handleDeviceAvailabilityQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+
+	threshold := time.Duration(qm.GapThreshold)
+
+	filter := qm.Filter.databaseFilter()
+	devices, err := d.queryCompleteMetricsData(ctx, &filter, query.TimeRange)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	from, to := pastTimeRange(query.TimeRange)
+
+	availabilities := make([]deviceAvailability, 0, len(devices))
+	for _, device := range devices {
+		availability := deviceAvailability{
+			Device:  device.Device,
+			From:    from,
+			To:      to,
+			Outages: series.Gaps(deviceTimestamps(device), from, to, deviceGapThreshold(device, threshold)),
+		}
+		availabilities = append(availabilities, availability)
+		response.Frames = append(response.Frames, availabilityToTimelineFrame(availability))
+	}
+
+	response.Frames = append(response.Frames, availabilityToSummaryFrame(availabilities))
+
+	return response
+}
+
+func (d *SampleDatasource) handleAnnotationsQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+
+	gapThreshold := time.Duration(qm.GapThreshold)
+	raw := qm.Format.Values == valuesRaw
+
+	filter := qm.Filter.databaseFilter()
+	devices, err := d.queryCompleteMetricsData(ctx, &filter, query.TimeRange)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	if err := scaleDevices(devices, raw); err != nil {
+		response.Error = err
+		return response
+	}
+
+	from, to := pastTimeRange(query.TimeRange)
+	annotations := make([]annotation, 0)
+	for _, device := range devices {
+		if qm.Annotations.hasSource("gaps") {
+			outages := series.Gaps(deviceTimestamps(device), from, to, deviceGapThreshold(device, gapThreshold))
+			annotations = append(annotations, gapAnnotations(device.Device, outages)...)
+		}
+
+		if qm.Annotations.hasSource("thresholds") {
+			for _, metric := range device.Metrics {
+				for _, threshold := range qm.Annotations.Thresholds {
+					if threshold.MetricId == metric.Metric.Id {
+						annotations = append(annotations, thresholdAnnotations(device.Device, metric, threshold)...)
+					}
+				}
+			}
+		}
+	}
+	sortAnnotations(annotations)
+
+	response.Frames = append(response.Frames, annotationsToFrame(annotations))
+
+	return response
+}
+
+func (d *SampleDatasource) handleAlarmsQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+
+	filter := qm.Filter.databaseFilter()
+	limits, err := d.database.QueryMetricLimits(ctx, &filter)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	limitsById := make(map[int64]database.MetricLimits, len(limits))
+	for _, l := range limits {
+		limitsById[l.MetricId] = l
+	}
+
+	devices, err := d.queryCompleteMetricsData(ctx, &filter, query.TimeRange)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	// limits are engineering values.
+	if err := scaleDevices(devices, false); err != nil {
+		response.Error = err
+		return response
+	}
+
+	alarms := make([]metricAlarm, 0)
+	for _, device := range devices {
+		for _, metric := range device.Metrics {
+			l, ok := limitsById[metric.Metric.Id]
+			if !ok {
+				continue
+			}
+			for _, a := range evaluateAlarms(metric.Times, metric.Values, l) {
+				alarms = append(alarms, metricAlarm{
+					alarm:  a,
+					Device: device.Device,
+					Metric: metric.Metric,
+				})
+			}
+		}
+	}
+
+	response.Frames = append(response.Frames,
+		alarmsToActiveFrame(alarms),
+		alarmsToHistoryFrame(alarms),
+	)
+
+	return response
+}
+
+// handleLiveReadQuery reads the current value of the filtered metrics directly from their devices
+// rather than from the database. Metrics that could not be read are left out of the response.
+// Registers closer than qm.RegisterGap are read together.
+func (d *SampleDatasource) handleLiveReadQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+
+	raw := qm.Format.Values == valuesRaw
+
+	filter := qm.Filter.databaseFilter()
+	metrics, err := d.database.QueryFilteredMetrics(ctx, &filter)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	values, errs := d.readMetrics(ctx, metrics, qm.RegisterGap)
+	for i := range metrics {
+		metric := &metrics[i]
+		value := values[i]
+		if err := errs[i]; err != nil {
+			log.DefaultLogger.Error("LiveRead", "metric", metric.Id, "error", err)
+			if response.Error == nil {
+				response.Error = errors.New("cannot read metric '" + metric.Name + "': " + err.Error())
+			}
+			continue
+		}
+
+		device := database.Device{Id: metric.DeviceId, Name: metric.DeviceName}
+		metricWithData := &database.MetricWithData{
+			Metric: *metric,
+			Times:  []time.Time{time.Now()},
+			Values: []float64{value},
+		}
+		if !raw {
+			if err := applyScaling(metricWithData); err != nil {
+				response.Error = err
+				return response
+			}
+		}
+		response.Frames = append(response.Frames, metricToFrame(&device, metricWithData, fillOptions{}, qm.Format.States))
+	}
+
+	return response
+}
+
+// handleRawSQLQuery runs the statement of qm, once its macros are expanded, and returns its result
+// as a single frame.
+func (d *SampleDatasource) handleRawSQLQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+	if !d.features.AllowRawSQL {
+		response.Error = invalidQuery("raw SQL queries are disabled in the settings of the datasource")
+		return response
+	}
+
+	var metrics []database.Metric
+	if strings.Contains(qm.RawSQL, "$__metricIds") {
+		filter := qm.Filter.databaseFilter()
+		var err error
+		if metrics, err = d.database.QueryFilteredMetrics(ctx, &filter); err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	sql, err := expandMacros(qm.RawSQL, query, metrics)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	result, err := d.database.QueryRaw(ctx, sql)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	frame, err := rawResultToFrame(result)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	frame.SetMeta(&data.FrameMeta{ExecutedQueryString: sql})
+	if result.Truncated {
+		frame.AppendNotices(rawTruncationNotice(result))
+	}
+	response.Frames = append(response.Frames, frame)
+
+	return response
+}
+
+// handleVariableQuery lists the values of the template variable of qm.
+func (d *SampleDatasource) handleVariableQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+
+	values, err := d.database.QueryVariable(ctx, &database.VariableQuery{
+		Kind:      qm.Variable.Kind,
+		Devices:   qm.Variable.Devices,
+		SlaveIds:  qm.Variable.SlaveIds,
+		Metrics:   qm.Variable.Metrics,
+		Regex:     qm.Variable.Regex,
+		TimeRange: query.TimeRange,
+	})
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	response.Frames = append(response.Frames, variableToFrame(values))
+
+	return response
+}
+
+// handleAssetsQuery returns the plant hierarchy, down to the metrics, in the view of qm.
+func (d *SampleDatasource) handleAssetsQuery(ctx context.Context, pCtx backend.PluginContext, query backend.DataQuery, qm queryModel) *backend.DataResponse {
+	response := &backend.DataResponse{}
+
+	assets, err := d.database.QueryAssets(ctx)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	devices, err := d.database.QueryDevices(ctx)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	metrics, err := d.database.QueryFilteredMetrics(ctx, &database.Filter{})
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	nodes, err := assetTree(assets, devices, metrics, qm.Assets.Root)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	if qm.Assets.View == "nodeGraph" {
+		response.Frames = append(response.Frames, assetTreeToNodeGraph(nodes)...)
+	} else {
+		response.Frames = append(response.Frames, assetTreeToFrame(nodes))
+	}
 
 	return response
 }
 
+// pastTimeRange returns the bounds of timeRange, with its end clamped to now since a
+// device cannot be offline in the future.
+func pastTimeRange(timeRange backend.TimeRange) (time.Time, time.Time) {
+	from, to := timeRange.From, timeRange.To
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+	return from, to
+}
+
+// CallResource handles the plugin's HTTP resources (see newResourceMux), sent by Grafana
+// under /api/datasources/:id/resources.
+func (d *SampleDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return d.resourceHandler.CallResource(ctx, req, sender)
+}
+
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
-func (d *SampleDatasource) CheckHealth(_ context.Context, req *backend.CheckHealthRequest) (res *backend.CheckHealthResult, _ error) {
+func (d *SampleDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (res *backend.CheckHealthResult, _ error) {
 	log.DefaultLogger.Info("CheckHealth called", "request", req)
 
-	result := d.database.TestConnection()
+	result := d.database.TestConnection(ctx)
 	var status = backend.HealthStatusOk
 	if !result.Success {
 		status = backend.HealthStatusError
@@ -264,15 +696,9 @@ func (d *SampleDatasource) CheckHealth(_ context.Context, req *backend.CheckHeal
 func (d *SampleDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
 	log.DefaultLogger.Info("SubscribeStream called", "request", req)
 
-	status := backend.SubscribeStreamStatusPermissionDenied
-	path := strings.Split(req.Path, "/")
-	if len(path) == 3 && path[0] == "stream" {
-		switch path[1] {
-		case "metric":
-			status = backend.SubscribeStreamStatusOK
-		default:
-			status = backend.SubscribeStreamStatusNotFound
-		}
+	status := backend.SubscribeStreamStatusOK
+	if _, err := parseMetricStream(req.Path); err != nil {
+		status = backend.SubscribeStreamStatusNotFound
 	}
 
 	return &backend.SubscribeStreamResponse{
@@ -285,13 +711,23 @@ func (d *SampleDatasource) SubscribeStream(_ context.Context, req *backend.Subsc
 func (d *SampleDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
 	log.DefaultLogger.Info("RunStream called", "request", req)
 
-	path := strings.Split(req.Path, "/")
-	metricId := path[2]
+	activeStreams.Inc()
+	defer activeStreams.Dec()
+
+	stream, err := parseMetricStream(req.Path)
+	if err != nil {
+		return database.ErrUnknownMetric
+	}
+	// there is nothing to stream for a metric that does not exist, other errors may be transient.
+	if _, err := d.database.QueryMetric(ctx, stream.MetricId); errors.Is(err, database.ErrUnknownMetric) {
+		log.DefaultLogger.Error("Cannot stream", "path", req.Path, "error", err)
+		return err
+	}
 	lastFetch := time.Now()
 
 	filter := &database.Filter{
 		Entity: "metrics",
-		Value:  metricId,
+		Value:  strconv.FormatInt(stream.MetricId, 10),
 	}
 
 	// Stream data frames periodically till stream closed by Grafana.
@@ -301,13 +737,20 @@ func (d *SampleDatasource) RunStream(ctx context.Context, req *backend.RunStream
 			log.DefaultLogger.Info("Context done, finish streaming", "path", req.Path)
 			return nil
 		case <-time.After(5 * time.Second):
+			tickCtx, tickSpan := tracing.DefaultTracer().Start(ctx, "stream tick", trace.WithAttributes(attribute.String("path", req.Path)))
+
 			preFetch := time.Now()
-			devices, err := d.database.QueryMetricsData(filter, backend.TimeRange{
+			devices, err := d.database.QueryMetricsData(tickCtx, filter, backend.TimeRange{
 				From: lastFetch,
 				To:   preFetch.Add(time.Minute),
 			})
 
+			if err == nil {
+				err = scaleDevices(devices, stream.Raw)
+			}
 			if err != nil {
+				streamSendErrors.Inc()
+				endSpan(tickSpan, err)
 				log.DefaultLogger.Error("Error sending frame", "error", err)
 				continue
 			}
@@ -315,16 +758,19 @@ func (d *SampleDatasource) RunStream(ctx context.Context, req *backend.RunStream
 			var frame *data.Frame
 			for _, device := range devices {
 				for _, metric := range device.Metrics {
-					frame = metricToFrame(&device.Device, metric)
+					frame = metricToFrame(&device.Device, metric, fillOptions{}, stream.States)
 				}
 			}
 
 			err = sender.SendFrame(frame, data.IncludeAll)
 			if err != nil {
+				streamSendErrors.Inc()
+				endSpan(tickSpan, err)
 				log.DefaultLogger.Error("Error sending frame", "error", err)
 				continue
 			}
 
+			endSpan(tickSpan, nil)
 			lastFetch = preFetch
 		}
 	}
@@ -0,0 +1,406 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+)
+
+var defaultPercentiles = []float64{50, 90, 95, 99}
+
+// dataEntities are the entities that query the data of the filtered metrics.
+var dataEntities = map[queryEntity]bool{
+	entityMetricsData:        true,
+	entityMetricsStats:       true,
+	entityDeviceAvailability: true,
+	entityAnnotations:        true,
+	entityAlarms:             true,
+	entityLiveRead:           true,
+	entityRawSQL:             true,
+}
+
+// jsonDuration is a duration written as a string, such as "5m".
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return invalidQuery("invalid duration " + string(b))
+	}
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil || duration < 0 {
+		return invalidQuery("invalid duration '" + s + "'")
+	}
+	*d = jsonDuration(duration)
+	return nil
+}
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// invalidQuery returns an error for a query that cannot be run as sent.
+func invalidQuery(message string) error {
+	return &database.Error{Kind: database.ErrorKindInvalidQuery, Message: message}
+}
+
+// parseQuery decodes and validates raw, either a queryModel or a legacyQueryModel. The entity
+// of the returned query is set whenever it could be decoded, even along an error.
+func parseQuery(raw []byte) (queryModel, error) {
+	var qm queryModel
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return qm, invalidQuery("invalid query: " + err.Error())
+	}
+
+	switch {
+	case version.Version == 0:
+		var legacy legacyQueryModel
+		if err := json.Unmarshal(raw, &legacy); err != nil {
+			return qm, invalidQuery("invalid query: " + err.Error())
+		}
+		var err error
+		if qm, err = migrateLegacyQuery(legacy); err != nil {
+			return qm, err
+		}
+	case version.Version > queryVersion:
+		return qm, invalidQuery("unsupported query version " + strconv.Itoa(version.Version) + ", update the plugin")
+	default:
+		if err := json.Unmarshal(raw, &qm); err != nil {
+			var e *database.Error
+			if errors.As(err, &e) {
+				return qm, err
+			}
+			return qm, invalidQuery("invalid query: " + err.Error())
+		}
+	}
+
+	return qm, qm.validate()
+}
+
+// migrateLegacyQuery returns legacy as a queryModel, parsing its parameters as the handlers did
+// before versions. Parameters these ignored are reported as notices.
+func migrateLegacyQuery(legacy legacyQueryModel) (queryModel, error) {
+	params := legacy.Parameters
+	qm := queryModel{
+		Version:       queryVersion,
+		Entity:        queryEntity(legacy.Entity),
+		RawSQL:        params["rawSql"],
+		AdhocFilters:  legacy.AdhocFilters,
+		WithStreaming: legacy.WithStreaming,
+		Aggregation: queryAggregation{
+			Transform:    params["transform"],
+			IntegralUnit: params["integralUnit"],
+			Reduce:       params["reduce"],
+		},
+		Format: queryFormat{
+			Values: valuesMode(params["values"]),
+			States: statesMode(params["states"]),
+			Fill:   series.FillMode(params["fill"]),
+		},
+	}
+
+	var err error
+	if qm.Filter, qm.notices, err = migrateLegacyFilter(qm.Entity, params); err != nil {
+		return qm, err
+	}
+	if qm.Entity == entityVariableQuery {
+		if qm.Variable, err = migrateLegacyVariable(params); err != nil {
+			return qm, err
+		}
+	}
+	if qm.Entity == entityAssets {
+		qm.Assets.View = params["view"]
+		if root := params["root"]; root != "" {
+			if qm.Assets.Root, err = strconv.ParseInt(root, 10, 64); err != nil {
+				return qm, &database.Error{Kind: database.ErrorKindInvalidFilter, Message: "invalid root asset '" + root + "'"}
+			}
+		}
+	}
+
+	if csv, ok := params["percentiles"]; ok {
+		qm.Aggregation.Percentiles = make([]float64, 0)
+		for _, p := range strings.Split(csv, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			value, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return qm, invalidQuery("invalid percentile '" + p + "'")
+			}
+			qm.Aggregation.Percentiles = append(qm.Aggregation.Percentiles, value)
+		}
+	}
+
+	if csv, ok := params["sources"]; ok {
+		qm.Annotations.Sources = make([]string, 0)
+		for _, source := range strings.Split(csv, ",") {
+			qm.Annotations.Sources = append(qm.Annotations.Sources, strings.TrimSpace(source))
+		}
+	}
+	if qm.Annotations.Thresholds, err = parseThresholds(params["thresholds"]); err != nil {
+		return qm, invalidQuery(err.Error())
+	}
+
+	if threshold := params["gapThreshold"]; threshold != "" {
+		duration, err := time.ParseDuration(threshold)
+		if err != nil {
+			return qm, invalidQuery("invalid gap threshold '" + threshold + "'")
+		}
+		qm.GapThreshold = jsonDuration(duration)
+	}
+	if gap := params["registerGap"]; gap != "" {
+		if qm.RegisterGap, err = strconv.Atoi(gap); err != nil {
+			return qm, invalidQuery("invalid register gap '" + gap + "'")
+		}
+	}
+	if fields, ok := params["fields"]; ok {
+		qm.Fields = strings.Split(fields, ",")
+	}
+
+	return qm, nil
+}
+
+// migrateLegacyFilter returns the filter of the legacy parameters of entity: the "filter"
+// parameter names the parameter listing the ids, except for the Metrics entity which is only
+// filtered by the "devices" parameter.
+func migrateLegacyFilter(entity queryEntity, params map[string]string) (queryFilter, []data.Notice, error) {
+	var filter queryFilter
+	var notices []data.Notice
+
+	if entity == entityMetrics {
+		if _, ok := params["devices"]; ok {
+			filter.Entity = "devices"
+		}
+	} else {
+		filter.Entity = params["filter"]
+	}
+
+	switch filter.Entity {
+	case "devices", "metrics", "assets":
+	case "tags":
+		var err error
+		filter.Tags, err = parseTags(params["tags"])
+		return filter, notices, err
+	case "":
+		if !dataEntities[entity] {
+			return filter, notices, nil
+		}
+		for _, ignored := range []string{"devices", "metrics"} {
+			if strings.TrimSpace(params[ignored]) != "" {
+				notices = append(notices, data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     "The " + ignored + " filter value is ignored, set the filter to " + ignored + " to apply it.",
+				})
+			}
+		}
+		return filter, notices, nil
+	default:
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "The unknown filter '" + filter.Entity + "' is ignored, all metrics are queried.",
+		})
+		return queryFilter{}, notices, nil
+	}
+
+	var err error
+	filter.Ids, err = parseIdList(filter.Entity, params[filter.Entity])
+	return filter, notices, err
+}
+
+// parseTags parses the comma separated key=value pairs of value, such as "site=plantA,line=2".
+func parseTags(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, tag, ok := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return nil, &database.Error{Kind: database.ErrorKindInvalidFilter, Message: "invalid tag '" + pair + "'"}
+		}
+		tags[key] = strings.TrimSpace(tag)
+	}
+	if len(tags) == 0 {
+		return nil, &database.Error{Kind: database.ErrorKindInvalidFilter, Message: "the tags filter needs key=value pairs"}
+	}
+	return tags, nil
+}
+
+// migrateLegacyVariable returns the variable of the legacy parameters of the VariableQuery entity,
+// whose chained filters are comma separated lists of ids.
+func migrateLegacyVariable(params map[string]string) (queryVariable, error) {
+	variable := queryVariable{
+		Kind:  database.VariableKind(params["kind"]),
+		Regex: params["regex"],
+	}
+
+	var err error
+	if variable.Devices, err = parseIdList("devices", params["devices"]); err != nil {
+		return variable, err
+	}
+	if variable.SlaveIds, err = parseIdList("slave ids", params["slaveIds"]); err != nil {
+		return variable, err
+	}
+	variable.Metrics, err = parseIdList("metrics", params["metrics"])
+	return variable, err
+}
+
+// parseIdList parses value, a comma separated list of the ids of entity.
+func parseIdList(entity string, value string) ([]int64, error) {
+	ids := make([]int64, 0)
+	for _, id := range strings.Split(value, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, &database.Error{Kind: database.ErrorKindInvalidFilter, Message: "invalid " + entity + " '" + value + "'"}
+		}
+		ids = append(ids, parsed)
+	}
+	return ids, nil
+}
+
+// validate checks the options of qm, and sets the defaults of those left unset.
+func (qm *queryModel) validate() error {
+	switch qm.Entity {
+	case entityDevices, entityMetrics, entityMetricsData, entityMetricsStats, entityDeviceAvailability,
+		entityAnnotations, entityAlarms, entityLiveRead, entityRawSQL, entityVariableQuery, entityAssets:
+	default:
+		return invalidQuery("unknown entity '" + string(qm.Entity) + "'")
+	}
+	if qm.Entity == entityRawSQL && strings.TrimSpace(qm.RawSQL) == "" {
+		return invalidQuery("the RawSQL entity needs a statement")
+	}
+	if qm.Entity == entityVariableQuery && !database.IsVariableKind(qm.Variable.Kind) {
+		return invalidQuery("unknown variable kind '" + string(qm.Variable.Kind) + "'")
+	}
+
+	switch qm.Filter.Entity {
+	case "", "devices", "metrics", "assets":
+	case "tags":
+		if len(qm.Filter.Tags) == 0 {
+			return &database.Error{Kind: database.ErrorKindInvalidFilter, Message: "the tags filter needs tags"}
+		}
+	default:
+		return &database.Error{Kind: database.ErrorKindInvalidFilter, Message: "unknown filter '" + qm.Filter.Entity + "'"}
+	}
+
+	if err := qm.Aggregation.validate(); err != nil {
+		return err
+	}
+	if err := qm.Format.validate(); err != nil {
+		return err
+	}
+	if err := qm.Annotations.validate(); err != nil {
+		return err
+	}
+	if err := qm.Assets.validate(); err != nil {
+		return err
+	}
+
+	if qm.RegisterGap < 0 {
+		return invalidQuery("invalid register gap '" + strconv.Itoa(qm.RegisterGap) + "'")
+	}
+	return nil
+}
+
+func (a *queryAggregation) validate() error {
+	switch a.Transform {
+	case "", "rate", "delta", "derivative", "integral":
+	default:
+		return invalidQuery("unknown transform '" + a.Transform + "'")
+	}
+	if _, err := integralDuration(a.IntegralUnit); err != nil {
+		return invalidQuery(err.Error())
+	}
+
+	if a.Reduce != "" && a.Reduce != "last" {
+		return invalidQuery("unknown reduction '" + a.Reduce + "'")
+	}
+
+	if a.Percentiles == nil {
+		a.Percentiles = defaultPercentiles
+	}
+	for _, p := range a.Percentiles {
+		if p < 0 || p > 100 {
+			return invalidQuery("invalid percentile '" + strconv.FormatFloat(p, 'f', -1, 64) + "'")
+		}
+	}
+	return nil
+}
+
+func (f *queryFormat) validate() error {
+	var err error
+	if f.Values, err = parseValuesMode(string(f.Values)); err != nil {
+		return invalidQuery(err.Error())
+	}
+	if f.States, err = parseStatesMode(string(f.States)); err != nil {
+		return invalidQuery(err.Error())
+	}
+	if f.Fill, err = series.ParseFillMode(string(f.Fill)); err != nil {
+		return invalidQuery(err.Error())
+	}
+	return nil
+}
+
+func (a *queryAnnotations) validate() error {
+	if a.Sources == nil {
+		a.Sources = []string{"gaps", "thresholds"}
+	}
+	for _, source := range a.Sources {
+		if source != "gaps" && source != "thresholds" {
+			return invalidQuery("unknown annotation source '" + source + "'")
+		}
+	}
+	return nil
+}
+
+func (a *queryAssets) validate() error {
+	if a.View == "" {
+		a.View = "tree"
+	}
+	if a.View != "tree" && a.View != "nodeGraph" {
+		return invalidQuery("unknown assets view '" + a.View + "'")
+	}
+	return nil
+}
+
+// hasSource reports whether the annotations of source are requested.
+func (a queryAnnotations) hasSource(source string) bool {
+	for _, s := range a.Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// databaseFilter returns f as the filter of the database.
+func (f queryFilter) databaseFilter() database.Filter {
+	if f.Entity == "" {
+		return database.Filter{}
+	}
+	if f.Entity == "tags" {
+		return database.Filter{Entity: f.Entity, Tags: f.Tags}
+	}
+
+	ids := make([]string, len(f.Ids))
+	for i, id := range f.Ids {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	return database.Filter{Entity: f.Entity, Value: strings.Join(ids, ",")}
+}
@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+)
+
+func TestParseLegacyQuery(t *testing.T) {
+	legacy, err := parseQuery([]byte(`{"entity":"MetricsData","withStreaming":true,"parameters":{` +
+		`"filter":"metrics","metrics":"10, 11","fill":"null","gapThreshold":"5m","values":"raw",` +
+		`"transform":"integral","integralUnit":"h","percentiles":"50,99","sources":"gaps",` +
+		`"thresholds":"10>50","registerGap":"4"},"adhocFilters":[{"key":"unit","operator":"=","value":"kW"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1, err := parseQuery([]byte(`{"version":1,"entity":"MetricsData","withStreaming":true,` +
+		`"filter":{"entity":"metrics","ids":[10,11]},` +
+		`"aggregation":{"transform":"integral","integralUnit":"h","percentiles":[50,99]},` +
+		`"format":{"values":"raw","fill":"null"},` +
+		`"annotations":{"sources":["gaps"],"thresholds":[{"metricId":10,"above":true,"value":50}]},` +
+		`"adhocFilters":[{"key":"unit","operator":"=","value":"kW"}],"gapThreshold":"5m","registerGap":4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(legacy, v1) {
+		t.Errorf("Expected the legacy query to migrate to\n%+v, got\n%+v", v1, legacy)
+	}
+	if legacy.Format.Fill != series.FillNull || time.Duration(legacy.GapThreshold) != 5*time.Minute {
+		t.Errorf("Unexpected format %+v and gap threshold %v", legacy.Format, time.Duration(legacy.GapThreshold))
+	}
+}
+
+func TestParseQueryDefaults(t *testing.T) {
+	qm, err := parseQuery([]byte(`{"version":1,"entity":"MetricsStats"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(qm.Aggregation.Percentiles, defaultPercentiles) {
+		t.Errorf("Expected the default percentiles, got %v", qm.Aggregation.Percentiles)
+	}
+	if !qm.Annotations.hasSource("gaps") || !qm.Annotations.hasSource("thresholds") {
+		t.Errorf("Expected both annotation sources, got %v", qm.Annotations.Sources)
+	}
+	if qm.Format.Values != valuesEngineering || qm.Format.Fill != series.FillNone {
+		t.Errorf("Unexpected default format %+v", qm.Format)
+	}
+	if filter := qm.Filter.databaseFilter(); !reflect.DeepEqual(filter, database.Filter{}) {
+		t.Errorf("Expected no filter, got %+v", filter)
+	}
+}
+
+func TestParseLegacyFilter(t *testing.T) {
+	type TestCase struct {
+		query          string
+		expectedFilter queryFilter
+		expectedTexts  []string
+	}
+
+	cases := []TestCase{
+		{
+			query:          `{"entity":"MetricsData","parameters":{}}`,
+			expectedFilter: queryFilter{},
+			expectedTexts:  []string{},
+		},
+		{
+			query:          `{"entity":"MetricsData","parameters":{"filter":"devices","devices":"1,2","metrics":"10"}}`,
+			expectedFilter: queryFilter{Entity: "devices", Ids: []int64{1, 2}},
+			expectedTexts:  []string{},
+		},
+		{
+			query:          `{"entity":"MetricsData","parameters":{"filter":"sites","sites":"1"}}`,
+			expectedFilter: queryFilter{},
+			expectedTexts:  []string{"The unknown filter 'sites' is ignored, all metrics are queried."},
+		},
+		{
+			query:          `{"entity":"MetricsData","parameters":{"metrics":"10"}}`,
+			expectedFilter: queryFilter{},
+			expectedTexts:  []string{"The metrics filter value is ignored, set the filter to metrics to apply it."},
+		},
+		{
+			// Metrics is filtered by devices only, whatever the filter parameter.
+			query:          `{"entity":"Metrics","parameters":{"filter":"metrics","metrics":"10","devices":"3"}}`,
+			expectedFilter: queryFilter{Entity: "devices", Ids: []int64{3}},
+			expectedTexts:  []string{},
+		},
+		{
+			query:          `{"entity":"MetricsData","parameters":{"filter":"tags","tags":"site=plantA, line = 2"}}`,
+			expectedFilter: queryFilter{Entity: "tags", Tags: map[string]string{"site": "plantA", "line": "2"}},
+			expectedTexts:  []string{},
+		},
+		{
+			query:          `{"entity":"MetricsStats","parameters":{"filter":"assets","assets":"1"}}`,
+			expectedFilter: queryFilter{Entity: "assets", Ids: []int64{1}},
+			expectedTexts:  []string{},
+		},
+		{
+			query:          `{"entity":"Devices","parameters":{"metrics":"10"}}`,
+			expectedFilter: queryFilter{},
+			expectedTexts:  []string{},
+		},
+	}
+
+	for _, tc := range cases {
+		qm, err := parseQuery([]byte(tc.query))
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", tc.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(qm.Filter, tc.expectedFilter) {
+			t.Errorf("Filter mismatch for %s: Expected %+v, got %+v", tc.query, tc.expectedFilter, qm.Filter)
+		}
+		texts := make([]string, len(qm.notices))
+		for i, notice := range qm.notices {
+			texts[i] = notice.Text
+		}
+		if !reflect.DeepEqual(texts, tc.expectedTexts) {
+			t.Errorf("Notices mismatch for %s: Expected %q, got %q", tc.query, tc.expectedTexts, texts)
+		}
+	}
+}
+
+func TestParseLegacyVariableQuery(t *testing.T) {
+	qm, err := parseQuery([]byte(`{"entity":"VariableQuery","parameters":` +
+		`{"kind":"metricsWithData","devices":"1,2","slaveIds":"3","regex":"^temp"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := queryVariable{
+		Kind:     database.VariableMetricsWithData,
+		Devices:  []int64{1, 2},
+		SlaveIds: []int64{3},
+		Metrics:  []int64{},
+		Regex:    "^temp",
+	}
+	if !reflect.DeepEqual(qm.Variable, expected) {
+		t.Errorf("Expected variable %+v, got %+v", expected, qm.Variable)
+	}
+	if len(qm.notices) != 0 {
+		t.Errorf("Expected the device ids to be used by the variable without notice, got %v", qm.notices)
+	}
+}
+
+func TestParseInvalidQuery(t *testing.T) {
+	type TestCase struct {
+		query    string
+		expected error
+	}
+
+	cases := []TestCase{
+		{query: `not json`, expected: database.ErrInvalidQuery},
+		{query: `{"version":2,"entity":"MetricsData"}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"Sites"}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"MetricsData","filter":{"entity":"sites"}}`, expected: database.ErrInvalidFilter},
+		{query: `{"version":1,"entity":"MetricsData","aggregation":{"transform":"sum"}}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"MetricsData","aggregation":{"reduce":"first"}}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"MetricsStats","aggregation":{"percentiles":[101]}}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"MetricsData","format":{"fill":"spline"}}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"Annotations","annotations":{"sources":["alarms"]}}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"MetricsData","gapThreshold":"soon"}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"LiveRead","registerGap":-1}`, expected: database.ErrInvalidQuery},
+		{query: `{"entity":"MetricsData","parameters":{"filter":"metrics","metrics":"1,x"}}`, expected: database.ErrInvalidFilter},
+		{query: `{"entity":"MetricsData","parameters":{"filter":"tags","tags":"plantA"}}`, expected: database.ErrInvalidFilter},
+		{query: `{"version":1,"entity":"MetricsData","filter":{"entity":"tags"}}`, expected: database.ErrInvalidFilter},
+		{query: `{"entity":"MetricsStats","parameters":{"percentiles":"50,high"}}`, expected: database.ErrInvalidQuery},
+		{query: `{"entity":"Annotations","parameters":{"thresholds":"10=5"}}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"VariableQuery","variable":{"kind":"sites"}}`, expected: database.ErrInvalidQuery},
+		{query: `{"entity":"VariableQuery","parameters":{"kind":"metrics","slaveIds":"$slave"}}`, expected: database.ErrInvalidFilter},
+		{query: `{"version":1,"entity":"RawSQL","rawSql":" "}`, expected: database.ErrInvalidQuery},
+		{query: `{"version":1,"entity":"Assets","assets":{"view":"graph"}}`, expected: database.ErrInvalidQuery},
+		{query: `{"entity":"Assets","parameters":{"root":"plantA"}}`, expected: database.ErrInvalidFilter},
+	}
+
+	for _, tc := range cases {
+		_, err := parseQuery([]byte(tc.query))
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("Expected %v for %s, got %v", tc.expected, tc.query, err)
+		}
+		if database.SourceOf(err) != database.ErrorSourceDownstream {
+			t.Errorf("Expected a downstream error for %s, got %v", tc.query, database.SourceOf(err))
+		}
+	}
+}
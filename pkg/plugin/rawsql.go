@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+// macro matches the macros of a raw SQL query, such as $__metricIds or $__timeFilter(timestamp).
+var macro = regexp.MustCompile(`\$__(\w+)(\(([^)]*)\))?`)
+
+// expandMacros returns sql with its macros replaced by the SQL they stand for over the time range
+// of query, metrics being the metrics of its filter:
+//   - $__timeFilter(column) restricts column to the time range,
+//   - $__timeGroup(column, interval) truncates column to a multiple of interval, a duration such
+//     as 5m or $__interval,
+//   - $__timeFrom() and $__timeTo() are the bounds of the time range,
+//   - $__unixEpochFrom() and $__unixEpochTo() are these bounds in seconds since the epoch,
+//   - $__metricIds is the comma separated list of the ids of metrics.
+func expandMacros(sql string, query backend.DataQuery, metrics []database.Metric) (string, error) {
+	from := strconv.FormatInt(query.TimeRange.From.Unix(), 10)
+	to := strconv.FormatInt(query.TimeRange.To.Unix(), 10)
+
+	var err error
+	expanded := macro.ReplaceAllStringFunc(sql, func(match string) string {
+		if err != nil {
+			return match
+		}
+		groups := macro.FindStringSubmatch(match)
+		name, hasArgs := groups[1], groups[2] != ""
+		var args []string
+		for _, arg := range strings.Split(groups[3], ",") {
+			if arg = strings.TrimSpace(arg); arg != "" {
+				args = append(args, arg)
+			}
+		}
+
+		switch name {
+		case "timeFilter":
+			if len(args) != 1 {
+				err = errors.New("$__timeFilter expects a column")
+				return match
+			}
+			return args[0] + " BETWEEN FROM_UNIXTIME(" + from + ") AND FROM_UNIXTIME(" + to + ")"
+		case "timeGroup":
+			if len(args) != 2 {
+				err = errors.New("$__timeGroup expects a column and an interval")
+				return match
+			}
+			var interval time.Duration
+			if interval, err = macroInterval(args[1], query.Interval); err != nil {
+				return match
+			}
+			seconds := strconv.FormatInt(int64(interval/time.Second), 10)
+			return "FROM_UNIXTIME(UNIX_TIMESTAMP(" + args[0] + ") DIV " + seconds + " * " + seconds + ")"
+		case "timeFrom":
+			return "FROM_UNIXTIME(" + from + ")"
+		case "timeTo":
+			return "FROM_UNIXTIME(" + to + ")"
+		case "unixEpochFrom":
+			return from
+		case "unixEpochTo":
+			return to
+		case "metricIds":
+			if hasArgs && len(args) > 0 {
+				err = errors.New("$__metricIds expects no argument")
+				return match
+			}
+			if len(metrics) == 0 {
+				// matches nothing, where an empty list would be a syntax error.
+				return "NULL"
+			}
+			ids := make([]string, len(metrics))
+			for i, metric := range metrics {
+				ids[i] = strconv.FormatInt(metric.Id, 10)
+			}
+			return strings.Join(ids, ",")
+		default:
+			err = errors.New("unknown macro $__" + name)
+			return match
+		}
+	})
+	if err != nil {
+		return "", invalidQuery(err.Error())
+	}
+	return expanded, nil
+}
+
+// macroInterval parses the interval argument of a macro, a duration of at least a second, possibly
+// quoted, or $__interval for the interval of the query.
+func macroInterval(arg string, queryInterval time.Duration) (time.Duration, error) {
+	arg = strings.Trim(arg, `'"`)
+	if arg == "$__interval" {
+		if queryInterval < time.Second {
+			return time.Second, nil
+		}
+		return queryInterval, nil
+	}
+
+	interval, err := time.ParseDuration(arg)
+	if err != nil || interval < time.Second {
+		return 0, errors.New("invalid interval '" + arg + "'")
+	}
+	return interval, nil
+}
+
+// rawResultToFrame returns the columns of result as the fields of a frame, nullable only if they hold
+// NULLs. A result with a time column and string columns is a long time series, which is returned as
+// a wide one, with a series for each value column and combination of the string columns.
+func rawResultToFrame(result *database.RawResult) (*data.Frame, error) {
+	frame := data.NewFrame("")
+	for _, column := range result.Columns {
+		frame.Fields = append(frame.Fields, rawColumnToField(column))
+	}
+
+	if frame.TimeSeriesSchema().Type != data.TimeSeriesTypeLong {
+		return frame, nil
+	}
+	if rows, _ := frame.RowLen(); rows == 0 {
+		return frame, nil
+	}
+	wide, err := data.LongToWide(frame, nil)
+	if err != nil {
+		return nil, invalidQuery("cannot convert the result to time series, order it by time: " + err.Error())
+	}
+	return wide, nil
+}
+
+func rawColumnToField(column database.RawColumn) *data.Field {
+	nullable := false
+	for _, value := range column.Values {
+		if value == nil {
+			nullable = true
+			break
+		}
+	}
+
+	var fieldType data.FieldType
+	switch column.Kind {
+	case database.RawKindInt:
+		fieldType = data.FieldTypeInt64
+	case database.RawKindUint:
+		fieldType = data.FieldTypeUint64
+	case database.RawKindFloat:
+		fieldType = data.FieldTypeFloat64
+	case database.RawKindTime:
+		fieldType = data.FieldTypeTime
+	default:
+		fieldType = data.FieldTypeString
+	}
+	if nullable {
+		fieldType = fieldType.NullableType()
+	}
+
+	field := data.NewFieldFromFieldType(fieldType, len(column.Values))
+	field.Name = column.Name
+	for i, value := range column.Values {
+		switch {
+		case value == nil:
+		case nullable:
+			field.SetConcrete(i, value)
+		default:
+			field.Set(i, value)
+		}
+	}
+	return field
+}
+
+// rawTruncationNotice tells that the rows of result beyond the row limit are missing.
+func rawTruncationNotice(result *database.RawResult) data.Notice {
+	rows := 0
+	if len(result.Columns) > 0 {
+		rows = len(result.Columns[0].Values)
+	}
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: "The row limit of the datasource was reached, only the first " + strconv.Itoa(rows) +
+			" rows are returned. Narrow the time range or add a LIMIT to the query.",
+	}
+}
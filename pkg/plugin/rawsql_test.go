@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/helper"
+)
+
+func TestExpandMacros(t *testing.T) {
+	query := backend.DataQuery{
+		Interval:  time.Minute,
+		TimeRange: backend.TimeRange{From: time.Unix(1000, 0), To: time.Unix(2000, 0)},
+	}
+	metrics := []database.Metric{{Id: 10}, {Id: 11}}
+
+	type TestCase struct {
+		sql      string
+		metrics  []database.Metric
+		expected string
+	}
+
+	cases := []TestCase{
+		{
+			sql:      "SELECT * FROM metrics_data WHERE $__timeFilter(timestamp) AND metric_id IN ($__metricIds)",
+			metrics:  metrics,
+			expected: "SELECT * FROM metrics_data WHERE timestamp BETWEEN FROM_UNIXTIME(1000) AND FROM_UNIXTIME(2000) AND metric_id IN (10,11)",
+		},
+		{
+			sql:      "SELECT $__timeGroup(timestamp, '5m') AS time FROM metrics_data WHERE metric_id IN ($__metricIds)",
+			expected: "SELECT FROM_UNIXTIME(UNIX_TIMESTAMP(timestamp) DIV 300 * 300) AS time FROM metrics_data WHERE metric_id IN (NULL)",
+		},
+		{
+			sql:      "SELECT $__timeGroup(timestamp, $__interval) FROM metrics_data",
+			expected: "SELECT FROM_UNIXTIME(UNIX_TIMESTAMP(timestamp) DIV 60 * 60) FROM metrics_data",
+		},
+		{
+			sql:      "SELECT $__unixEpochFrom(), $__unixEpochTo(), $__timeFrom(), $__timeTo()",
+			expected: "SELECT 1000, 2000, FROM_UNIXTIME(1000), FROM_UNIXTIME(2000)",
+		},
+	}
+
+	for _, tc := range cases {
+		expanded, err := expandMacros(tc.sql, query, tc.metrics)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.sql, err)
+			continue
+		}
+		if expanded != tc.expected {
+			t.Errorf("Expansion mismatch for %q: Expected %q, got %q", tc.sql, tc.expected, expanded)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT $__timeFilter()",
+		"SELECT $__timeGroup(timestamp)",
+		"SELECT $__timeGroup(timestamp, 10ms)",
+		"SELECT $__unknown(x)",
+	} {
+		if _, err := expandMacros(sql, query, nil); !errors.Is(err, database.ErrInvalidQuery) {
+			t.Errorf("Expected an invalid query for %q, got %v", sql, err)
+		}
+	}
+}
+
+func TestRawResultToFrame(t *testing.T) {
+	start := time.Unix(1659028800, 0)
+	result := &database.RawResult{Columns: []database.RawColumn{
+		{Name: "time", Kind: database.RawKindTime, Values: []interface{}{start, start, start.Add(time.Minute), start.Add(time.Minute)}},
+		{Name: "device", Kind: database.RawKindString, Values: []interface{}{"pump", "fan", "pump", "fan"}},
+		{Name: "value", Kind: database.RawKindFloat, Values: []interface{}{1.0, 2.0, nil, 4.0}},
+	}}
+
+	frame, err := rawResultToFrame(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame.Fields) != 3 || frame.Rows() != 2 {
+		t.Fatalf("Expected a wide frame of a time and 2 value fields over 2 rows, got %d fields and %d rows", len(frame.Fields), frame.Rows())
+	}
+	for _, field := range frame.Fields[1:] {
+		if field.Type() != data.FieldTypeNullableFloat64 || field.Labels["device"] == "" {
+			t.Errorf("Expected nullable values labelled by device, got %v %v", field.Type(), field.Labels)
+		}
+	}
+
+	// a table without a time column is returned as is, its fields nullable only if needed.
+	result = &database.RawResult{Columns: []database.RawColumn{
+		{Name: "id", Kind: database.RawKindInt, Values: []interface{}{int64(1), int64(2)}},
+		{Name: "name", Kind: database.RawKindString, Values: []interface{}{"pump", nil}},
+	}}
+	frame, err = rawResultToFrame(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Fields[0].Type() != data.FieldTypeInt64 || frame.Fields[1].Type() != data.FieldTypeNullableString {
+		t.Errorf("Unexpected field types %v and %v", frame.Fields[0].Type(), frame.Fields[1].Type())
+	}
+
+	// long series must be sorted by time to be made wide.
+	result = &database.RawResult{Columns: []database.RawColumn{
+		{Name: "time", Kind: database.RawKindTime, Values: []interface{}{start.Add(time.Minute), start}},
+		{Name: "device", Kind: database.RawKindString, Values: []interface{}{"pump", "pump"}},
+		{Name: "value", Kind: database.RawKindFloat, Values: []interface{}{1.0, 2.0}},
+	}}
+	if _, err := rawResultToFrame(result); !errors.Is(err, database.ErrInvalidQuery) {
+		t.Errorf("Expected an invalid query for unsorted series, got %v", err)
+	}
+}
+
+func TestRawSQLNeedsOptIn(t *testing.T) {
+	ds := &SampleDatasource{database: &database.Database{}, features: &helper.Features{}}
+	res := ds.handleRawSQLQuery(context.Background(), backend.PluginContext{}, backend.DataQuery{},
+		queryModel{Entity: entityRawSQL, RawSQL: "SELECT 1"})
+	if !errors.Is(res.Error, database.ErrInvalidQuery) {
+		t.Errorf("Expected raw SQL to be disabled, got %v", res.Error)
+	}
+}
@@ -0,0 +1,434 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// newResourceMux returns the routes served through CallResource.
+func newResourceMux(d *SampleDatasource) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/limits", editorsOnly(d.handleLimits))
+	mux.HandleFunc("/scaling", editorsOnly(d.handleScaling))
+	mux.HandleFunc("/mappings", editorsOnly(d.handleMappings))
+	mux.HandleFunc("/transports", editorsOnly(d.handleTransports))
+	mux.HandleFunc("/write", editorsOnly(d.handleWrite))
+	mux.HandleFunc("/refresh", d.handleRefresh)
+	mux.HandleFunc("/tag-keys", d.handleTagKeys)
+	mux.HandleFunc("/tag-values", d.handleTagValues)
+	mux.HandleFunc("/tags", editorsOnly(d.handleTags))
+	mux.HandleFunc("/assets", editorsOnly(d.handleAssets))
+	return mux
+}
+
+// editorsOnly restricts the methods of handler that change state, all but GET, to the editors and
+// admins of the organization, since any viewer of the datasource can call its resources.
+func editorsOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			user := httpadapter.UserFromContext(r.Context())
+			if user == nil || user.Role != "Editor" && user.Role != "Admin" {
+				http.Error(w, "only editors can change this resource", http.StatusForbidden)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// handleLimits lists (GET, optionally filtered by ?metrics=1,2), saves (POST) or deletes
+// (DELETE ?metric_id=1) metric limits.
+func (d *SampleDatasource) handleLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var filter database.Filter
+		if metrics := r.URL.Query().Get("metrics"); metrics != "" {
+			if !isIdsCsv(metrics) {
+				http.Error(w, "invalid metrics '"+metrics+"'", http.StatusBadRequest)
+				return
+			}
+			filter = database.Filter{Entity: "metrics", Value: metrics}
+		}
+
+		limits, err := d.database.QueryMetricLimits(r.Context(), &filter)
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		writeJSON(w, limits)
+	case http.MethodPost:
+		var limits database.MetricLimits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil || limits.MetricId == 0 {
+			http.Error(w, "invalid limits", http.StatusBadRequest)
+			return
+		}
+
+		if err := d.database.SaveMetricLimits(r.Context(), limits); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		writeJSON(w, limits)
+	case http.MethodDelete:
+		metricId, err := strconv.ParseInt(r.URL.Query().Get("metric_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid metric_id", http.StatusBadRequest)
+			return
+		}
+
+		if err := d.database.DeleteMetricLimits(r.Context(), metricId); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleScaling saves (POST) or deletes (DELETE ?metric_id=1) the scaling of a metric. The scaling
+// of metrics is listed along with them by the Metrics entity.
+func (d *SampleDatasource) handleScaling(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		scaling := database.MetricScaling{Scale: 1}
+		if err := json.NewDecoder(r.Body).Decode(&scaling); err != nil || scaling.MetricId == 0 || scaling.Scale == 0 {
+			http.Error(w, "invalid scaling", http.StatusBadRequest)
+			return
+		}
+
+		if err := d.database.SaveMetricScaling(r.Context(), scaling); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		d.cache.Clear()
+		writeJSON(w, scaling)
+	case http.MethodDelete:
+		metricId, err := strconv.ParseInt(r.URL.Query().Get("metric_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid metric_id", http.StatusBadRequest)
+			return
+		}
+
+		if err := d.database.DeleteMetricScaling(r.Context(), metricId); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		d.cache.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMappings lists (GET, optionally filtered by ?metrics=1,2) or replaces (POST
+// {"metricId": 1, "mappings": [{"kind": "value", "value": 0, "text": "off"}]}) the value mappings
+// of metrics.
+func (d *SampleDatasource) handleMappings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var filter database.Filter
+		if metrics := r.URL.Query().Get("metrics"); metrics != "" {
+			if !isIdsCsv(metrics) {
+				http.Error(w, "invalid metrics '"+metrics+"'", http.StatusBadRequest)
+				return
+			}
+			filter = database.Filter{Entity: "metrics", Value: metrics}
+		}
+
+		mappings, err := d.database.QueryValueMappings(r.Context(), &filter)
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		writeJSON(w, mappings)
+	case http.MethodPost:
+		var body struct {
+			MetricId int64                   `json:"metricId"`
+			Mappings []database.ValueMapping `json:"mappings"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MetricId == 0 {
+			http.Error(w, "invalid mappings", http.StatusBadRequest)
+			return
+		}
+		for i := range body.Mappings {
+			m := &body.Mappings[i]
+			if m.Kind != "value" && m.Kind != "bit" || m.Kind == "bit" && (m.Value < 0 || m.Value > 63) || m.Text == "" {
+				http.Error(w, "invalid mapping '"+m.Text+"'", http.StatusBadRequest)
+				return
+			}
+			m.MetricId = body.MetricId
+		}
+
+		if err := d.database.SaveValueMappings(r.Context(), body.MetricId, body.Mappings); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		d.cache.Clear()
+		writeJSON(w, body.Mappings)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTransports lists (GET) or saves (POST) the serial transports of devices.
+func (d *SampleDatasource) handleTransports(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		transports, err := d.database.QueryDeviceTransports(r.Context())
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		writeJSON(w, transports)
+	case http.MethodPost:
+		transport := database.DeviceTransport{
+			BaudRate: 9600,
+			DataBits: 8,
+			Parity:   "N",
+			StopBits: 1,
+			Timeout:  1000,
+		}
+		if err := json.NewDecoder(r.Body).Decode(&transport); err != nil || transport.DeviceId == 0 || transport.Port == "" {
+			http.Error(w, "invalid transport", http.StatusBadRequest)
+			return
+		}
+
+		previous, err := d.database.QueryDeviceTransport(r.Context(), transport.DeviceId)
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		if err := d.database.SaveDeviceTransport(r.Context(), transport); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		// the clients of the old and new ports were opened with settings that may no longer hold.
+		ports := []string{transport.Port}
+		if previous != nil && previous.Port != transport.Port {
+			ports = append(ports, previous.Port)
+		}
+		d.rtuPool.drop(ports...)
+		writeJSON(w, transport)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleWrite writes a value to a metric on its device (POST {"metricId": 1, "value": 42}). The
+// value is an engineering value, unless "raw" is set. Writes must be allowed in the settings of
+// the datasource.
+func (d *SampleDatasource) handleWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !d.features.AllowWrites {
+		http.Error(w, "writes are disabled in the settings of the datasource", http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		MetricId int64   `json:"metricId"`
+		Value    float64 `json:"value"`
+		Raw      bool    `json:"raw"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MetricId == 0 {
+		http.Error(w, "invalid write request", http.StatusBadRequest)
+		return
+	}
+
+	metric, err := d.database.QueryMetric(r.Context(), body.MetricId)
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+
+	value := body.Value
+	if !body.Raw {
+		scaling, _, err := engineeringScaling(metric)
+		if err == nil {
+			scaling, err = scaling.Inverse()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		value = scaling.Apply(value)
+	}
+
+	if err := d.writeMetric(r.Context(), metric, value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRefresh reloads the devices and metrics (POST), dropping the cached data along with
+// their previous definition.
+func (d *SampleDatasource) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := d.database.RefreshMetadata(r.Context()); err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	d.cache.Clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tagValue is a tag key or value, in the shape Grafana expects for ad hoc filters.
+type tagValue struct {
+	Text string `json:"text"`
+}
+
+func toTagValues(texts []string) []tagValue {
+	values := make([]tagValue, len(texts))
+	for i, text := range texts {
+		values[i] = tagValue{Text: text}
+	}
+	return values
+}
+
+// handleTagKeys lists (GET) the keys ad hoc filters can compare.
+func (d *SampleDatasource) handleTagKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := d.database.QueryTagKeys(r.Context())
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	writeJSON(w, toTagValues(keys))
+}
+
+// handleTagValues lists (GET ?key=unit) the values of a tag key.
+func (d *SampleDatasource) handleTagValues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	values, err := d.database.QueryTagValues(r.Context(), r.URL.Query().Get("key"))
+	if err != nil {
+		writeDatabaseError(w, err)
+		return
+	}
+	writeJSON(w, toTagValues(values))
+}
+
+// handleTags lists (GET) or replaces (POST {"entity": "devices", "id": 1, "tags": {"site": "plantA"}})
+// the custom tags of devices and metrics.
+func (d *SampleDatasource) handleTags(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sets, err := d.database.QueryTagSets(r.Context())
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		writeJSON(w, sets)
+	case http.MethodPost:
+		var set database.TagSet
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil || set.Id == 0 {
+			http.Error(w, "invalid tags", http.StatusBadRequest)
+			return
+		}
+
+		if err := d.database.SaveTags(r.Context(), set); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		d.cache.Clear()
+		writeJSON(w, set)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAssets lists (GET), creates or replaces (POST {"id": 2, "parentId": 1, "name": "Hall 1",
+// "kind": "area", "devices": [3]}) or deletes (DELETE ?id=2) the assets of the plant hierarchy.
+func (d *SampleDatasource) handleAssets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		assets, err := d.database.QueryAssets(r.Context())
+		if err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		writeJSON(w, assets)
+	case http.MethodPost:
+		var asset database.Asset
+		if err := json.NewDecoder(r.Body).Decode(&asset); err != nil {
+			http.Error(w, "invalid asset", http.StatusBadRequest)
+			return
+		}
+
+		if err := d.database.SaveAsset(r.Context(), &asset); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		d.cache.Clear()
+		writeJSON(w, asset)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		if err := d.database.DeleteAsset(r.Context(), id); err != nil {
+			writeDatabaseError(w, err)
+			return
+		}
+		d.cache.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeDatabaseError answers err, an error of the database, with the status matching its kind.
+func writeDatabaseError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, database.ErrInvalidFilter):
+		status = http.StatusBadRequest
+	case errors.Is(err, database.ErrUnknownMetric):
+		status = http.StatusNotFound
+	case errors.Is(err, database.ErrTimeout):
+		status = http.StatusGatewayTimeout
+	case database.SourceOf(err) == database.ErrorSourceDownstream:
+		status = http.StatusBadGateway
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.DefaultLogger.Error("Error writing resource response", "error", err)
+	}
+}
+
+// isIdsCsv reports whether csv is a comma separated list of integers.
+func isIdsCsv(csv string) bool {
+	for _, id := range strings.Split(csv, ",") {
+		if _, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/helper"
+)
+
+type statusSender struct {
+	status int
+}
+
+func (s *statusSender) Send(res *backend.CallResourceResponse) error {
+	s.status = res.Status
+	return nil
+}
+
+func TestEditorsOnly(t *testing.T) {
+	handler := httpadapter.New(editorsOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	type TestCase struct {
+		method   string
+		user     *backend.User
+		expected int
+	}
+
+	cases := []TestCase{
+		{method: http.MethodGet, user: &backend.User{Role: "Viewer"}, expected: http.StatusNoContent},
+		{method: http.MethodPost, user: &backend.User{Role: "Viewer"}, expected: http.StatusForbidden},
+		{method: http.MethodDelete, user: nil, expected: http.StatusForbidden},
+		{method: http.MethodPost, user: &backend.User{Role: "Editor"}, expected: http.StatusNoContent},
+		{method: http.MethodDelete, user: &backend.User{Role: "Admin"}, expected: http.StatusNoContent},
+	}
+
+	for _, tc := range cases {
+		sender := &statusSender{}
+		req := &backend.CallResourceRequest{Method: tc.method, Path: "limits", URL: "limits", PluginContext: backend.PluginContext{User: tc.user}}
+		if err := handler.CallResource(context.Background(), req, sender); err != nil {
+			t.Fatal(err)
+		}
+		if sender.status != tc.expected {
+			t.Errorf("Status mismatch for %s by %+v: Expected %d, got %d", tc.method, tc.user, tc.expected, sender.status)
+		}
+	}
+}
+
+func TestWriteNeedsOptIn(t *testing.T) {
+	ds := &SampleDatasource{features: &helper.Features{}}
+	handler := httpadapter.New(newResourceMux(ds))
+
+	sender := &statusSender{}
+	req := &backend.CallResourceRequest{Method: http.MethodPost, Path: "write", URL: "write", Body: []byte(`{"metricId":1,"value":1}`),
+		PluginContext: backend.PluginContext{User: &backend.User{Role: "Admin"}}}
+	if err := handler.CallResource(context.Background(), req, sender); err != nil {
+		t.Fatal(err)
+	}
+	if sender.status != http.StatusForbidden {
+		t.Errorf("Expected writes to be forbidden, got %d", sender.status)
+	}
+}
@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"errors"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/units"
+	"math"
+)
+
+// engineeringScaling returns the map turning raw values of metric into engineering values, along
+// with the unit of the latter.
+func engineeringScaling(metric *database.Metric) (units.Linear, string, error) {
+	scaling := units.Linear{Scale: metric.Scale, Offset: metric.Offset}
+	if metric.EngineeringUnit == "" {
+		return scaling, metric.Unit, nil
+	}
+
+	conversion, err := units.Conversion(metric.Unit, metric.EngineeringUnit)
+	if err != nil {
+		return units.Linear{}, "", errors.New("cannot scale metric '" + metric.Name + "': " + err.Error())
+	}
+	return scaling.Then(conversion), metric.EngineeringUnit, nil
+}
+
+// applyScaling replaces the raw data of metric by engineering values, and its unit by theirs.
+// The metric is left without scaling, so scaling it again leaves it untouched, and the scaling is
+// kept in metric.Scaling for states to be told from raw values.
+func applyScaling(metric *database.MetricWithData) error {
+	scaling, unit, err := engineeringScaling(&metric.Metric)
+	if err != nil {
+		return err
+	}
+
+	for i, value := range metric.Values {
+		metric.Values[i] = scaling.Apply(value)
+	}
+	metric.Metric.Unit = unit
+	metric.Metric.Scale, metric.Metric.Offset, metric.Metric.EngineeringUnit = 1, 0, ""
+	if metric.Scaling != nil {
+		scaling = metric.Scaling.Then(scaling)
+	}
+	metric.Scaling = &scaling
+
+	return nil
+}
+
+// scaleDevices applies the scaling of every metric of devices, unless raw values are requested.
+func scaleDevices(devices []database.DeviceWithMetrics, raw bool) error {
+	if raw {
+		return nil
+	}
+	for _, device := range devices {
+		for _, metric := range device.Metrics {
+			if err := applyScaling(metric); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mirroredPercentiles returns percentiles followed by 100-p for each of them, the percentiles of
+// raw values that become the requested ones of engineering values once a negative scale reverses
+// their order.
+func mirroredPercentiles(percentiles []float64) []float64 {
+	mirrored := make([]float64, 0, 2*len(percentiles))
+	mirrored = append(mirrored, percentiles...)
+	for _, p := range percentiles {
+		mirrored = append(mirrored, 100-p)
+	}
+	return mirrored
+}
+
+// scaleStats turns the raw statistics of metrics into engineering ones. Their percentiles must
+// have been computed for mirroredPercentiles, and are narrowed to the requested ones.
+func scaleStats(stats []database.MetricStats) error {
+	for i := range stats {
+		s := &stats[i]
+		scaling, unit, err := engineeringScaling(&s.Metric)
+		if err != nil {
+			return err
+		}
+
+		apply := func(v *float64) *float64 {
+			if v == nil {
+				return nil
+			}
+			scaled := scaling.Apply(*v)
+			return &scaled
+		}
+		s.Min, s.Max, s.Mean, s.First, s.Last = apply(s.Min), apply(s.Max), apply(s.Mean), apply(s.First), apply(s.Last)
+		if s.StdDev != nil {
+			stdDev := math.Abs(scaling.Scale) * *s.StdDev
+			s.StdDev = &stdDev
+		}
+
+		n := len(s.Percentiles) / 2
+		percentiles := s.Percentiles[:n]
+		if scaling.Scale < 0 {
+			s.Min, s.Max = s.Max, s.Min
+			percentiles = s.Percentiles[n:]
+		}
+		if len(percentiles) > 0 {
+			s.Percentiles = make([]float64, n)
+			for j, p := range percentiles {
+				s.Percentiles[j] = scaling.Apply(p)
+			}
+		}
+
+		s.Metric.Unit = unit
+		s.Metric.Scale, s.Metric.Offset, s.Metric.EngineeringUnit = 1, 0, ""
+	}
+	return nil
+}
+
+// valuesMode is whether queries return engineering values, the default, or the raw values of
+// registers.
+type valuesMode string
+
+const (
+	valuesEngineering valuesMode = "engineering"
+	valuesRaw         valuesMode = "raw"
+)
+
+func parseValuesMode(mode string) (valuesMode, error) {
+	switch m := valuesMode(mode); m {
+	case "", valuesEngineering:
+		return valuesEngineering, nil
+	case valuesRaw:
+		return m, nil
+	default:
+		return "", errors.New("unknown values '" + mode + "'")
+	}
+}
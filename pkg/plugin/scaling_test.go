@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+func TestApplyScaling(t *testing.T) {
+	metric := testMetric(10, "temperature", 200, 215)
+	metric.Metric.Unit = "°C"
+	metric.Metric.Scale = 0.1
+	metric.Metric.EngineeringUnit = "°F"
+
+	if err := applyScaling(metric); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(metric.Values[0]-68) > 1e-9 || math.Abs(metric.Values[1]-70.7) > 1e-9 {
+		t.Errorf("Value mismatch: got %f and %f", metric.Values[0], metric.Values[1])
+	}
+	if metric.Metric.Unit != "°F" {
+		t.Errorf("Expected the engineering unit, got %q", metric.Metric.Unit)
+	}
+
+	// scaling twice leaves the values untouched.
+	if err := applyScaling(metric); err != nil || math.Abs(metric.Values[0]-68) > 1e-9 {
+		t.Errorf("Expected scaling to apply once, got %f (%v)", metric.Values[0], err)
+	}
+
+	metric = testMetric(11, "power", 1)
+	metric.Metric.Scale = 1
+	metric.Metric.EngineeringUnit = "bar"
+	if err := applyScaling(metric); err == nil {
+		t.Errorf("Expected an error converting kW to bar")
+	}
+}
+
+func TestScaleStatsWithNegativeScale(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	stats := []database.MetricStats{{
+		Metric:      database.Metric{Scale: -2, Offset: 1, Unit: "W"},
+		Count:       3,
+		Min:         f(1),
+		Max:         f(3),
+		StdDev:      f(1),
+		Percentiles: []float64{2.8, 1.2}, // p90, then p10 of the raw values
+	}}
+
+	if err := scaleStats(stats); err != nil {
+		t.Fatal(err)
+	}
+	s := stats[0]
+	if *s.Min != -5 || *s.Max != -1 {
+		t.Errorf("Expected min -5 and max -1, got %f and %f", *s.Min, *s.Max)
+	}
+	if *s.StdDev != 2 {
+		t.Errorf("Expected a standard deviation of 2, got %f", *s.StdDev)
+	}
+	if len(s.Percentiles) != 1 || math.Abs(s.Percentiles[0]+1.4) > 1e-9 {
+		t.Errorf("Expected p90 -1.4, got %v", s.Percentiles)
+	}
+}
+
+func TestDerivativeOfScaledCounterWrap(t *testing.T) {
+	// a uint16 counter of tenths wraps from 6553.0 to 0.4 once scaled, an increase of 1.0.
+	metric := testMetric(10, "energy", 65530, 4)
+	metric.Metric.DataFormat = "uint16"
+	metric.Metric.Scale = 0.1
+
+	wrap, err := counterWrap(&metric.Metric, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyScaling(metric); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyTransform(metric, "derivative", "", wrap); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metric.Values) != 1 || math.Abs(metric.Values[0]-0.1) > 1e-9 {
+		t.Errorf("Expected an increase of 0.1 per second, got %v", metric.Values)
+	}
+}
+
+func TestCounterWrapOfFormats(t *testing.T) {
+	expected := map[string]float64{
+		"uint16":   1 << 16,
+		"uint48":   1 << 48,
+		"uint64":   1 << 64,
+		"int64":    1 << 64,
+		"mod10k32": 1e8,
+		"mod10k64": 1e16,
+		"float32":  0,
+	}
+	for format, modulus := range expected {
+		metric := database.Metric{DataFormat: format, Scale: 0.5}
+		wrap, err := counterWrap(&metric, true)
+		if err != nil || wrap != modulus {
+			t.Errorf("Expected the raw wrap of %s to be %g, got %g and %v", format, modulus, wrap, err)
+		}
+		wrap, err = counterWrap(&metric, false)
+		if err != nil || wrap != modulus/2 {
+			t.Errorf("Expected the scaled wrap of %s to be %g, got %g and %v", format, modulus/2, wrap, err)
+		}
+	}
+}
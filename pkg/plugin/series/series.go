@@ -0,0 +1,220 @@
+package series
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Percentile returns the p-th percentile (0-100) of sorted using linear interpolation
+// between the closest ranks. sorted must be in ascending order and not empty.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 || p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	fraction := rank - float64(lower)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + fraction*(sorted[lower+1]-sorted[lower])
+}
+
+// Delta returns the difference between consecutive values, timestamped at the later point.
+func Delta(times []time.Time, values []float64) ([]time.Time, []float64) {
+	if len(values) < 2 {
+		return []time.Time{}, []float64{}
+	}
+
+	outTimes := make([]time.Time, 0, len(values)-1)
+	outValues := make([]float64, 0, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		outTimes = append(outTimes, times[i])
+		outValues = append(outValues, values[i]-values[i-1])
+	}
+	return outTimes, outValues
+}
+
+// Rate returns the per-second change between consecutive values. Points sharing a timestamp
+// with their predecessor are dropped.
+func Rate(times []time.Time, values []float64) ([]time.Time, []float64) {
+	return derive(times, values, func(delta float64, _ float64) float64 {
+		return delta
+	})
+}
+
+// NonNegativeDerivative returns the per-second increase of a counter. A decrease is considered
+// a wrap-around of a counter of modulus wrap (e.g. 65536 for a 16-bit register) when wrap is
+// positive, and a counter reset otherwise, in which case the current value is used as increase.
+func NonNegativeDerivative(times []time.Time, values []float64, wrap float64) ([]time.Time, []float64) {
+	return derive(times, values, func(delta float64, current float64) float64 {
+		if delta >= 0 {
+			return delta
+		}
+		if wrap > 0 && delta+wrap >= 0 {
+			return delta + wrap
+		}
+		return current
+	})
+}
+
+// Integral returns the cumulative time-weighted integral of values using the trapezoidal rule,
+// expressed in value times unit (e.g. time.Hour to turn kW into kWh). The first point is 0.
+func Integral(times []time.Time, values []float64, unit time.Duration) ([]time.Time, []float64) {
+	outValues := make([]float64, len(values))
+	for i := 1; i < len(values); i++ {
+		dt := times[i].Sub(times[i-1]).Seconds() / unit.Seconds()
+		outValues[i] = outValues[i-1] + (values[i]+values[i-1])/2*dt
+	}
+	return times, outValues
+}
+
+func derive(times []time.Time, values []float64, increase func(delta float64, current float64) float64) ([]time.Time, []float64) {
+	if len(values) < 2 {
+		return []time.Time{}, []float64{}
+	}
+
+	outTimes := make([]time.Time, 0, len(values)-1)
+	outValues := make([]float64, 0, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		dt := times[i].Sub(times[i-1]).Seconds()
+		if dt <= 0 {
+			continue
+		}
+		outTimes = append(outTimes, times[i])
+		outValues = append(outValues, increase(values[i]-values[i-1], values[i])/dt)
+	}
+	return outTimes, outValues
+}
+
+type FillMode string
+
+const (
+	FillNone     FillMode = ""
+	FillNull     FillMode = "null"
+	FillPrevious FillMode = "previous"
+	FillZero     FillMode = "zero"
+	FillLinear   FillMode = "linear"
+)
+
+// ParseFillMode returns the FillMode named mode, or an error if there is none.
+func ParseFillMode(mode string) (FillMode, error) {
+	switch m := FillMode(mode); m {
+	case FillNone, FillNull, FillPrevious, FillZero, FillLinear:
+		return m, nil
+	default:
+		return FillNone, errors.New("unknown fill mode '" + mode + "'")
+	}
+}
+
+// MaxFilledPoints is the most points Fill inserts in a series.
+const MaxFilledPoints = 10000
+
+// Fill returns values as a nullable series where every gap between two consecutive points longer
+// than threshold is filled according to mode, one point per interval. FillNull only inserts a single
+// null right after the last point before the gap, which is enough to break the line when graphed,
+// and so does any mode for the gaps that would take the series over MaxFilledPoints inserted points.
+// The series is not extended: there is no point before the first one or after the last one.
+func Fill(times []time.Time, values []float64, interval time.Duration, threshold time.Duration, mode FillMode) ([]time.Time, []*float64) {
+	outTimes := make([]time.Time, 0, len(times))
+	outValues := make([]*float64, 0, len(values))
+
+	filled := 0
+	for i := range values {
+		if i > 0 && mode != FillNone && interval > 0 && times[i].Sub(times[i-1]) > threshold {
+			gapMode := mode
+			if points := int((times[i].Sub(times[i-1]) - 1) / interval); filled+points > MaxFilledPoints {
+				gapMode = FillNull
+			}
+			for t := times[i-1].Add(interval); t.Before(times[i]); t = t.Add(interval) {
+				var value *float64
+				switch gapMode {
+				case FillPrevious:
+					value = &values[i-1]
+				case FillZero:
+					zero := 0.0
+					value = &zero
+				case FillLinear:
+					ratio := t.Sub(times[i-1]).Seconds() / times[i].Sub(times[i-1]).Seconds()
+					interpolated := values[i-1] + ratio*(values[i]-values[i-1])
+					value = &interpolated
+				}
+
+				outTimes = append(outTimes, t)
+				outValues = append(outValues, value)
+				filled++
+
+				if gapMode == FillNull {
+					break
+				}
+			}
+		}
+
+		outTimes = append(outTimes, times[i])
+		outValues = append(outValues, &values[i])
+	}
+
+	return outTimes, outValues
+}
+
+type Interval struct {
+	From time.Time
+	To   time.Time
+}
+
+func (i Interval) Duration() time.Duration {
+	return i.To.Sub(i.From)
+}
+
+// Gaps returns the intervals of [from, to] longer than threshold in which there is no point.
+// times must be sorted in ascending order.
+func Gaps(times []time.Time, from time.Time, to time.Time, threshold time.Duration) []Interval {
+	gaps := make([]Interval, 0)
+
+	previous := from
+	for _, t := range times {
+		if t.Before(from) || t.After(to) {
+			continue
+		}
+		if t.Sub(previous) > threshold {
+			gaps = append(gaps, Interval{From: previous, To: t})
+		}
+		previous = t
+	}
+	if to.Sub(previous) > threshold {
+		gaps = append(gaps, Interval{From: previous, To: to})
+	}
+
+	return gaps
+}
+
+// Excursions returns the intervals in which values violate a condition, from the first violating
+// point to the first point back in bounds, or the last point if the series ends out of bounds.
+// times must be sorted in ascending order.
+func Excursions(times []time.Time, values []float64, violates func(float64) bool) []Interval {
+	excursions := make([]Interval, 0)
+
+	start := -1
+	for i, value := range values {
+		if violates(value) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			excursions = append(excursions, Interval{From: times[start], To: times[i]})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		excursions = append(excursions, Interval{From: times[start], To: times[len(times)-1]})
+	}
+
+	return excursions
+}
@@ -0,0 +1,205 @@
+package series_test
+
+import (
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+
+	type TestCase struct {
+		sorted        []float64
+		percentile    float64
+		expectedValue float64
+	}
+
+	cases := []TestCase{
+		{sorted: []float64{42}, percentile: 50, expectedValue: 42},
+		{sorted: []float64{1, 2, 3, 4, 5}, percentile: 0, expectedValue: 1},
+		{sorted: []float64{1, 2, 3, 4, 5}, percentile: 50, expectedValue: 3},
+		{sorted: []float64{1, 2, 3, 4, 5}, percentile: 100, expectedValue: 5},
+		{sorted: []float64{1, 2, 3, 4}, percentile: 50, expectedValue: 2.5},
+		{sorted: []float64{10, 20, 30, 40, 50}, percentile: 90, expectedValue: 46},
+	}
+
+	for i, tc := range cases {
+		val := series.Percentile(tc.sorted, tc.percentile)
+		if math.Abs(val-tc.expectedValue) > 1e-9 {
+			t.Errorf("Value mismatch for test %d: Expected %f, got %f", i, tc.expectedValue, val)
+		}
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	start := time.Unix(1659028780, 0)
+	times := []time.Time{start, start.Add(10 * time.Second), start.Add(20 * time.Second), start.Add(30 * time.Second)}
+
+	type TestCase struct {
+		name           string
+		transform      func([]time.Time, []float64) ([]time.Time, []float64)
+		values         []float64
+		expectedValues []float64
+	}
+
+	cases := []TestCase{
+		{
+			name:           "delta",
+			transform:      series.Delta,
+			values:         []float64{10, 30, 20, 20},
+			expectedValues: []float64{20, -10, 0},
+		},
+		{
+			name:           "rate",
+			transform:      series.Rate,
+			values:         []float64{10, 30, 20, 20},
+			expectedValues: []float64{2, -1, 0},
+		},
+		{
+			name: "derivative with 16-bit wrap",
+			transform: func(times []time.Time, values []float64) ([]time.Time, []float64) {
+				return series.NonNegativeDerivative(times, values, 65536)
+			},
+			values:         []float64{65500, 65530, 14, 54},
+			expectedValues: []float64{3, 2, 4},
+		},
+		{
+			name: "derivative with reset",
+			transform: func(times []time.Time, values []float64) ([]time.Time, []float64) {
+				return series.NonNegativeDerivative(times, values, 0)
+			},
+			values:         []float64{100, 200, 50, 60},
+			expectedValues: []float64{10, 5, 1},
+		},
+		{
+			name: "integral",
+			transform: func(times []time.Time, values []float64) ([]time.Time, []float64) {
+				return series.Integral(times, values, time.Second)
+			},
+			values:         []float64{1, 3, 3, 1},
+			expectedValues: []float64{0, 20, 50, 70},
+		},
+	}
+
+	for _, tc := range cases {
+		outTimes, values := tc.transform(times, tc.values)
+		if len(values) != len(tc.expectedValues) || len(outTimes) != len(values) {
+			t.Errorf("Length mismatch for %s: Expected %d, got %d values and %d times",
+				tc.name, len(tc.expectedValues), len(values), len(outTimes))
+			continue
+		}
+		for i := range values {
+			if math.Abs(values[i]-tc.expectedValues[i]) > 1e-9 {
+				t.Errorf("Value mismatch for %s at %d: Expected %f, got %f", tc.name, i, tc.expectedValues[i], values[i])
+			}
+		}
+	}
+}
+
+func TestFill(t *testing.T) {
+	start := time.Unix(1659028780, 0)
+	times := []time.Time{start, start.Add(10 * time.Second), start.Add(40 * time.Second)}
+	values := []float64{1, 2, 5}
+
+	type TestCase struct {
+		mode           series.FillMode
+		expectedValues []*float64
+	}
+
+	f := func(v float64) *float64 { return &v }
+	cases := []TestCase{
+		{mode: series.FillNone, expectedValues: []*float64{f(1), f(2), f(5)}},
+		{mode: series.FillNull, expectedValues: []*float64{f(1), f(2), nil, f(5)}},
+		{mode: series.FillPrevious, expectedValues: []*float64{f(1), f(2), f(2), f(2), f(5)}},
+		{mode: series.FillZero, expectedValues: []*float64{f(1), f(2), f(0), f(0), f(5)}},
+		{mode: series.FillLinear, expectedValues: []*float64{f(1), f(2), f(3), f(4), f(5)}},
+	}
+
+	for _, tc := range cases {
+		outTimes, outValues := series.Fill(times, values, 10*time.Second, 15*time.Second, tc.mode)
+		if len(outValues) != len(tc.expectedValues) || len(outTimes) != len(outValues) {
+			t.Errorf("Length mismatch for %q: Expected %d, got %d values and %d times",
+				tc.mode, len(tc.expectedValues), len(outValues), len(outTimes))
+			continue
+		}
+		for i, expected := range tc.expectedValues {
+			if (expected == nil) != (outValues[i] == nil) || expected != nil && math.Abs(*expected-*outValues[i]) > 1e-9 {
+				t.Errorf("Value mismatch for %q at %d", tc.mode, i)
+			}
+		}
+		if tc.mode != series.FillNone && !outTimes[1].Add(10*time.Second).Equal(outTimes[2]) {
+			t.Errorf("Time mismatch for %q: Expected first filled point 10s after the gap start", tc.mode)
+		}
+	}
+}
+
+func TestFillCap(t *testing.T) {
+	start := time.Unix(1659028780, 0)
+	// the first gap takes all but one of the points, the second one does not fit.
+	times := []time.Time{
+		start,
+		start.Add(series.MaxFilledPoints * time.Second),
+		start.Add((series.MaxFilledPoints + 3) * time.Second),
+	}
+	values := []float64{1, 2, 3}
+
+	outTimes, outValues := series.Fill(times, values, time.Second, 2*time.Second, series.FillPrevious)
+	// the points of the series, those of the first gap and a null.
+	if expected := 3 + series.MaxFilledPoints - 1 + 1; len(outValues) != expected || len(outTimes) != expected {
+		t.Fatalf("Expected %d points, got %d values and %d times", expected, len(outValues), len(outTimes))
+	}
+	if last := outValues[len(outValues)-2]; last != nil {
+		t.Errorf("Expected a single null in the gap over the cap, got %v", *last)
+	}
+	if filled := outValues[len(outValues)-4]; filled == nil || *filled != 1 {
+		t.Errorf("Expected the first gap to be filled with the previous value")
+	}
+}
+
+func TestGaps(t *testing.T) {
+	from := time.Unix(1659028780, 0)
+	at := func(s int) time.Time { return from.Add(time.Duration(s) * time.Second) }
+
+	times := []time.Time{at(5), at(10), at(40), at(45), at(50)}
+	gaps := series.Gaps(times, from, at(100), 10*time.Second)
+
+	expected := []series.Interval{
+		{From: at(10), To: at(40)},
+		{From: at(50), To: at(100)},
+	}
+	if len(gaps) != len(expected) {
+		t.Fatalf("Expected %d gaps, got %d", len(expected), len(gaps))
+	}
+	for i := range expected {
+		if !gaps[i].From.Equal(expected[i].From) || !gaps[i].To.Equal(expected[i].To) {
+			t.Errorf("Gap mismatch at %d: Expected %v, got %v", i, expected[i], gaps[i])
+		}
+	}
+
+	if gaps := series.Gaps(nil, from, at(100), 10*time.Second); len(gaps) != 1 || gaps[0].Duration() != 100*time.Second {
+		t.Errorf("Expected the whole range as a single gap for an empty series, got %v", gaps)
+	}
+}
+
+func TestExcursions(t *testing.T) {
+	from := time.Unix(1659028780, 0)
+	at := func(s int) time.Time { return from.Add(time.Duration(s) * time.Second) }
+
+	times := []time.Time{at(0), at(10), at(20), at(30), at(40), at(50)}
+	values := []float64{1, 12, 15, 3, 4, 11}
+	excursions := series.Excursions(times, values, func(v float64) bool { return v > 10 })
+
+	expected := []series.Interval{
+		{From: at(10), To: at(30)},
+		{From: at(50), To: at(50)},
+	}
+	if len(excursions) != len(expected) {
+		t.Fatalf("Expected %d excursions, got %d", len(expected), len(excursions))
+	}
+	for i := range expected {
+		if !excursions[i].From.Equal(expected[i].From) || !excursions[i].To.Equal(expected[i].To) {
+			t.Errorf("Excursion mismatch at %d: Expected %v, got %v", i, expected[i], excursions[i])
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"errors"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/units"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// statesMode is how the named states of status registers are rendered, as set in the "states"
+// parameter.
+type statesMode string
+
+const (
+	// statesMappings keeps numeric values and names them with value mappings in the field config.
+	statesMappings statesMode = ""
+	// statesText replaces values with the text of their state.
+	statesText statesMode = "text"
+	// statesNone ignores value mappings.
+	statesNone statesMode = "none"
+)
+
+func parseStatesMode(mode string) (statesMode, error) {
+	switch m := statesMode(mode); m {
+	case statesMappings, "mappings":
+		return statesMappings, nil
+	case statesText, statesNone:
+		return m, nil
+	default:
+		return "", errors.New("unknown states '" + mode + "'")
+	}
+}
+
+// stateOf returns the text and color of value according to mappings, and whether it is named at
+// all. Values are looked up first, then the texts of the raised bits are joined, in bit order,
+// taking the color of the lowest one.
+func stateOf(mappings []database.ValueMapping, value float64) (string, string, bool) {
+	if value != math.Trunc(value) || math.IsInf(value, 0) {
+		return "", "", false
+	}
+	integer := int64(value)
+
+	for _, m := range mappings {
+		if m.Kind == "value" && m.Value == integer {
+			return m.Text, m.Color, true
+		}
+	}
+
+	texts := make([]string, 0)
+	color := ""
+	for _, m := range mappings {
+		if m.Kind == "bit" && m.Value >= 0 && m.Value < 64 && uint64(integer)>>m.Value&1 == 1 {
+			if len(texts) == 0 {
+				color = m.Color
+			}
+			texts = append(texts, m.Text)
+		}
+	}
+	if len(texts) == 0 {
+		return "", "", false
+	}
+	return strings.Join(texts, ", "), color, true
+}
+
+// registerValue returns the register value that scaling turned into value, toRaw being its
+// inverse. Scaling back and forth rounds, so results close enough to an integer are taken for it.
+func registerValue(toRaw units.Linear, value float64) float64 {
+	raw := toRaw.Apply(value)
+	if rounded := math.Round(raw); math.Abs(raw-rounded) <= 1e-9*math.Max(1, math.Abs(rounded)) {
+		return rounded
+	}
+	return raw
+}
+
+// stateMappings returns mappings as Grafana value mappings of the values of field, which scaling
+// turned register values into, toRaw being its inverse. Every named value is mapped, along with
+// the combinations of bits found in the values of field.
+func stateMappings(mappings []database.ValueMapping, field *data.Field, scaling units.Linear, toRaw units.Linear) data.ValueMappings {
+	mapper := make(data.ValueMapper)
+	add := func(value float64) {
+		key := strconv.FormatFloat(value, 'f', -1, 64)
+		if _, ok := mapper[key]; ok {
+			return
+		}
+		if text, color, ok := stateOf(mappings, registerValue(toRaw, value)); ok {
+			mapper[key] = data.ValueMappingResult{Text: text, Color: color}
+		}
+	}
+
+	for _, m := range mappings {
+		if m.Kind == "value" {
+			add(scaling.Apply(float64(m.Value)))
+		}
+	}
+	for i := 0; i < field.Len(); i++ {
+		if v, _ := field.NullableFloatAt(i); v != nil {
+			add(*v)
+		}
+	}
+	if len(mapper) == 0 {
+		return nil
+	}
+
+	// keep the legend of state panels in value order.
+	keys := make([]float64, 0, len(mapper))
+	for key := range mapper {
+		value, _ := strconv.ParseFloat(key, 64)
+		keys = append(keys, value)
+	}
+	sort.Float64s(keys)
+	for i, value := range keys {
+		key := strconv.FormatFloat(value, 'f', -1, 64)
+		result := mapper[key]
+		result.Index = i
+		mapper[key] = result
+	}
+
+	return data.ValueMappings{mapper}
+}
+
+// stateField returns the field holding values of metric according to mode, which is field itself
+// unless the metric names its states as text. States are told from the values of the registers,
+// before metric.Scaling.
+func stateField(field *data.Field, metric *database.MetricWithData, mode statesMode) *data.Field {
+	mappings := metric.Metric.Mappings
+	if len(mappings) == 0 || mode == statesNone {
+		return field
+	}
+	scaling := units.Identity
+	if metric.Scaling != nil {
+		scaling = *metric.Scaling
+	}
+	toRaw, err := scaling.Inverse()
+	if err != nil {
+		// a scale of 0 leaves nothing of the registers.
+		return field
+	}
+
+	if mode == statesMappings {
+		field.Config.Mappings = stateMappings(mappings, field, scaling, toRaw)
+		return field
+	}
+
+	texts := make([]*string, field.Len())
+	for i := range texts {
+		v, _ := field.NullableFloatAt(i)
+		if v == nil {
+			continue
+		}
+		text, _, ok := stateOf(mappings, registerValue(toRaw, *v))
+		if !ok {
+			text = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+		texts[i] = &text
+	}
+
+	textField := data.NewField(field.Name, field.Labels, texts)
+	textField.Config = field.Config
+	// units make no sense for states.
+	textField.Config.Unit = ""
+	return textField
+}
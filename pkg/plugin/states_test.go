@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+)
+
+func TestStateOf(t *testing.T) {
+	mappings := []database.ValueMapping{
+		{Kind: "value", Value: 0, Text: "off", Color: "gray"},
+		{Kind: "value", Value: 4, Text: "running", Color: "green"},
+		{Kind: "bit", Value: 1, Text: "overheat", Color: "red"},
+		{Kind: "bit", Value: 3, Text: "grid fault", Color: "orange"},
+	}
+
+	type TestCase struct {
+		value         float64
+		expectedText  string
+		expectedColor string
+		expectedOk    bool
+	}
+
+	cases := []TestCase{
+		{value: 0, expectedText: "off", expectedColor: "gray", expectedOk: true},
+		{value: 4, expectedText: "running", expectedColor: "green", expectedOk: true},
+		{value: 2, expectedText: "overheat", expectedColor: "red", expectedOk: true},
+		{value: 10, expectedText: "overheat, grid fault", expectedColor: "red", expectedOk: true},
+		{value: 1, expectedOk: false},
+		{value: 2.5, expectedOk: false},
+	}
+
+	for _, tc := range cases {
+		text, color, ok := stateOf(mappings, tc.value)
+		if ok != tc.expectedOk || text != tc.expectedText || color != tc.expectedColor {
+			t.Errorf("State mismatch for %g: Expected %q %q %v, got %q %q %v",
+				tc.value, tc.expectedText, tc.expectedColor, tc.expectedOk, text, color, ok)
+		}
+	}
+}
+
+func TestMetricToFrameStates(t *testing.T) {
+	device := &database.Device{Id: 1, Name: "inverter"}
+	metric := testMetric(10, "state", 0, 4, 10)
+	metric.Metric.Mappings = []database.ValueMapping{
+		{Kind: "value", Value: 0, Text: "off"},
+		{Kind: "value", Value: 4, Text: "running"},
+		{Kind: "bit", Value: 1, Text: "overheat"},
+	}
+
+	frame := metricToFrame(device, metric, fillOptions{}, statesMappings)
+	mappings := frame.Fields[1].Config.Mappings
+	if len(mappings) != 1 {
+		t.Fatalf("Expected a single value mapper, got %d", len(mappings))
+	}
+	mapper := mappings[0].(data.ValueMapper)
+	if mapper["0"].Text != "off" || mapper["4"].Text != "running" || mapper["10"].Text != "overheat" {
+		t.Errorf("Unexpected value mappings: %v", mapper)
+	}
+
+	frame = metricToFrame(device, metric, fillOptions{}, statesText)
+	if frame.Fields[1].Type() != data.FieldTypeNullableString {
+		t.Fatalf("Expected a string field, got %s", frame.Fields[1].Type())
+	}
+	if text, _ := frame.Fields[1].ConcreteAt(1); text != "running" {
+		t.Errorf("Expected state running, got %v", text)
+	}
+
+	frame = metricToFrame(device, metric, fillOptions{}, statesNone)
+	if frame.Fields[1].Config.Mappings != nil {
+		t.Errorf("Expected no value mappings")
+	}
+}
+
+func TestScaledMetricStates(t *testing.T) {
+	device := &database.Device{Id: 1, Name: "inverter"}
+	scaled := func() *database.MetricWithData {
+		metric := testMetric(10, "state", 0, 4, 10)
+		metric.Metric.Scale = 0.1
+		metric.Metric.Mappings = []database.ValueMapping{
+			{Kind: "value", Value: 0, Text: "off"},
+			{Kind: "value", Value: 4, Text: "running"},
+			{Kind: "bit", Value: 1, Text: "overheat"},
+		}
+		if err := applyScaling(metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric
+	}
+
+	// the states are those of the registers, which the scaled values are mapped from.
+	frame := metricToFrame(device, scaled(), fillOptions{}, statesMappings)
+	mapper := frame.Fields[1].Config.Mappings[0].(data.ValueMapper)
+	if mapper["0"].Text != "off" || mapper["0.4"].Text != "running" || mapper["1"].Text != "overheat" {
+		t.Errorf("Unexpected value mappings: %v", mapper)
+	}
+
+	frame = metricToFrame(device, scaled(), fillOptions{}, statesText)
+	if text, _ := frame.Fields[1].ConcreteAt(1); text != "running" {
+		t.Errorf("Expected state running, got %v", text)
+	}
+
+	frame = metricToNumericFrame(device, scaled(), statesText)
+	if text, _ := frame.Fields[0].ConcreteAt(0); text != "overheat" {
+		t.Errorf("Expected the last state overheat, got %v", text)
+	}
+	frame = metricToNumericFrame(device, scaled(), statesNone)
+	if value, _ := frame.Fields[0].ConcreteAt(0); value != 1.0 || frame.Fields[0].Config.Mappings != nil {
+		t.Errorf("Expected the last value without mappings, got %v and %v", value, frame.Fields[0].Config.Mappings)
+	}
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// errUnknownStream is returned for the paths of live channels that are not metric streams.
+var errUnknownStream = errors.New("unknown stream")
+
+// metricStream is what RunStream streams of a metric, encoded in the path of its live channel as
+// "stream/metric/<id>" followed by "/raw" for raw values and "/states=<mode>" for the states mode
+// if not mappings.
+type metricStream struct {
+	MetricId int64
+	Raw      bool
+	States   statesMode
+}
+
+func (s metricStream) path() string {
+	path := "stream/metric/" + strconv.FormatInt(s.MetricId, 10)
+	if s.Raw {
+		path += "/raw"
+	}
+	if s.States != statesMappings {
+		path += "/states=" + string(s.States)
+	}
+	return path
+}
+
+func parseMetricStream(path string) (metricStream, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || parts[0] != "stream" || parts[1] != "metric" {
+		return metricStream{}, errUnknownStream
+	}
+	metricId, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return metricStream{}, errUnknownStream
+	}
+
+	stream := metricStream{MetricId: metricId}
+	for _, option := range parts[3:] {
+		if option == "raw" {
+			stream.Raw = true
+		} else if strings.HasPrefix(option, "states=") && option != "states=" {
+			if stream.States, err = parseStatesMode(strings.TrimPrefix(option, "states=")); err != nil {
+				return metricStream{}, errUnknownStream
+			}
+		} else {
+			return metricStream{}, errUnknownStream
+		}
+	}
+	return stream, nil
+}
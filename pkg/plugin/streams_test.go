@@ -0,0 +1,32 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestMetricStreamPath(t *testing.T) {
+	streams := []metricStream{
+		{MetricId: 7},
+		{MetricId: 7, Raw: true},
+		{MetricId: 7, States: statesText},
+		{MetricId: 7, Raw: true, States: statesNone},
+	}
+	for _, stream := range streams {
+		parsed, err := parseMetricStream(stream.path())
+		if err != nil {
+			t.Errorf("Cannot parse %q: %v", stream.path(), err)
+		} else if parsed != stream {
+			t.Errorf("Expected %+v from %q, got %+v", stream, stream.path(), parsed)
+		}
+	}
+
+	if path := (metricStream{MetricId: 7, Raw: true, States: statesText}).path(); path != "stream/metric/7/raw/states=text" {
+		t.Errorf("Unexpected path %q", path)
+	}
+
+	for _, path := range []string{"stream/metric", "stream/device/7", "stream/metric/x", "stream/metric/7/scaled", "stream/metric/7/states=", "stream/metric/7/states=bold"} {
+		if _, err := parseMetricStream(path); err == nil {
+			t.Errorf("Expected %q to be rejected", path)
+		}
+	}
+}
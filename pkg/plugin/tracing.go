@@ -0,0 +1,27 @@
+package plugin
+
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endQuerySpan records the outcome of a query on its span and ends it.
+func endQuerySpan(span trace.Span, res *backend.DataResponse) {
+	span.SetAttributes(attribute.Int("frames", len(res.Frames)))
+	if res.Error != nil {
+		span.SetAttributes(attribute.String("error.source", string(database.SourceOf(res.Error))))
+	}
+	endSpan(span, res.Error)
+}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestQueryDataSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	// a database that is not connected still traces the methods called on it.
+	ds := &SampleDatasource{database: &database.Database{}}
+	_, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{
+			{RefID: "A", JSON: []byte(`{"entity": "Devices"}`)},
+			{RefID: "B", JSON: []byte(`{"entity": "Unknown"}`)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"QueryData", "query A", "query B", "QueryDevices"} {
+		if spans[name] == nil {
+			t.Fatalf("Missing span %q, got %v", name, spans)
+		}
+	}
+
+	root := spans["QueryData"].SpanContext().SpanID()
+	if spans["query A"].Parent().SpanID() != root || spans["query B"].Parent().SpanID() != root {
+		t.Errorf("Expected query spans to be children of the QueryData span")
+	}
+	if spans["QueryDevices"].Parent().SpanID() != spans["query A"].SpanContext().SpanID() {
+		t.Errorf("Expected the database span to be a child of its query span")
+	}
+	if spans["query B"].Status().Code.String() != "Error" {
+		t.Errorf("Expected query B to fail, got status %v", spans["query B"].Status())
+	}
+}
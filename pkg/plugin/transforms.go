@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"errors"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/parser"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+	"math"
+	"time"
+)
+
+// applyTransform replaces the data of metric by the requested transform of it, wrap being the
+// modulus of its counter per counterWrap. An empty transform leaves the metric untouched.
+func applyTransform(metric *database.MetricWithData, transform string, integralUnit string, wrap float64) error {
+	if transform == "" {
+		return nil
+	}
+
+	times, values := metric.Times, metric.Values
+	switch transform {
+	case "rate":
+		times, values = series.Rate(times, values)
+	case "delta":
+		times, values = series.Delta(times, values)
+	case "derivative":
+		times, values = series.NonNegativeDerivative(times, values, wrap)
+	case "integral":
+		unit, err := integralDuration(integralUnit)
+		if err != nil {
+			return err
+		}
+		times, values = series.Integral(times, values, unit)
+	default:
+		return errors.New("unknown transform '" + transform + "'")
+	}
+
+	metric.Times, metric.Values = times, values
+
+	return nil
+}
+
+// counterWrap returns the modulus at which a counter stored in the registers of metric wraps
+// around, per parser.Modulus, or 0 for formats that are not used as counters. The modulus is that
+// of raw values, or unless raw is set that of engineering values, which the scale of metric
+// stretches.
+func counterWrap(metric *database.Metric, raw bool) (float64, error) {
+	wrap := parser.Modulus(metric.DataFormat)
+	if wrap == 0 || raw {
+		return wrap, nil
+	}
+
+	scaling, _, err := engineeringScaling(metric)
+	if err != nil {
+		return 0, err
+	}
+	return wrap * math.Abs(scaling.Scale), nil
+}
+
+func integralDuration(unit string) (time.Duration, error) {
+	switch unit {
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "", "h":
+		return time.Hour, nil
+	default:
+		return 0, errors.New("unknown integral unit '" + unit + "'")
+	}
+}
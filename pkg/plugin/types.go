@@ -1,7 +1,116 @@
 package plugin
 
+import (
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/database"
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/series"
+)
+
+// queryVersion is the version of queryModel. Queries without a version are legacyQueryModel.
+const queryVersion = 1
+
+type queryEntity string
+
+const (
+	entityDevices            queryEntity = "Devices"
+	entityMetrics            queryEntity = "Metrics"
+	entityMetricsData        queryEntity = "MetricsData"
+	entityMetricsStats       queryEntity = "MetricsStats"
+	entityDeviceAvailability queryEntity = "DeviceAvailability"
+	entityAnnotations        queryEntity = "Annotations"
+	entityAlarms             queryEntity = "Alarms"
+	entityLiveRead           queryEntity = "LiveRead"
+	entityRawSQL             queryEntity = "RawSQL"
+	entityVariableQuery      queryEntity = "VariableQuery"
+	entityAssets             queryEntity = "Assets"
+)
+
+// queryModel is a query as sent by Grafana, once decoded and validated by parseQuery.
 type queryModel struct {
+	Version int         `json:"version"`
+	Entity  queryEntity `json:"entity"`
+	Filter  queryFilter `json:"filter"`
+	// AdhocFilters are the ad hoc filters of the dashboard, applied by the MetricsData entity.
+	AdhocFilters []database.AdhocFilter `json:"adhocFilters"`
+	Aggregation  queryAggregation       `json:"aggregation"`
+	Format       queryFormat            `json:"format"`
+	Annotations  queryAnnotations       `json:"annotations"`
+	Variable     queryVariable          `json:"variable"`
+	Assets       queryAssets            `json:"assets"`
+	// GapThreshold is the longest interval between two points of a metric that is not a gap,
+	// which defaults to twice its refresh rate.
+	GapThreshold jsonDuration `json:"gapThreshold"`
+	// RegisterGap is the largest number of unused registers read to read metrics together.
+	RegisterGap int `json:"registerGap"`
+	// RawSQL is the statement of the RawSQL entity, whose macros are expanded by expandMacros.
+	RawSQL string `json:"rawSql"`
+	// Fields are the fields of the Metrics entity to return, not used yet.
+	Fields []string `json:"fields"`
+	// WithStreaming streams the new values of the series, unless they are transformed or reduced.
+	WithStreaming bool `json:"withStreaming"`
+
+	// fromAlert is set when the query is evaluated by Grafana alerting rather than by a panel.
+	fromAlert bool
+	// notices are added to the response, they tell what a legacy query meant that was ignored.
+	notices []data.Notice
+}
+
+// queryFilter restricts a query to the devices, metrics or assets listed in Ids, or to none if Ids
+// is empty, the assets standing for all the devices under them. The zero value queries everything.
+type queryFilter struct {
+	Entity string  `json:"entity"`
+	Ids    []int64 `json:"ids"`
+	// Tags are the custom tags the metrics must all have, for the "tags" entity.
+	Tags map[string]string `json:"tags"`
+}
+
+type queryAggregation struct {
+	// Transform is one of rate, delta, derivative or integral, the latter per IntegralUnit.
+	Transform    string `json:"transform"`
+	IntegralUnit string `json:"integralUnit"`
+	// Reduce is empty to return the series, or last to return its last value.
+	Reduce string `json:"reduce"`
+	// Percentiles are the percentiles of the MetricsStats entity, defaultPercentiles if nil.
+	Percentiles []float64 `json:"percentiles"`
+}
+
+type queryFormat struct {
+	Values valuesMode      `json:"values"`
+	States statesMode      `json:"states"`
+	Fill   series.FillMode `json:"fill"`
+}
+
+type queryAnnotations struct {
+	// Sources are gaps and/or thresholds, both if nil.
+	Sources    []string          `json:"sources"`
+	Thresholds []metricThreshold `json:"thresholds"`
+}
+
+// queryVariable is the template variable listed by the VariableQuery entity. Devices, SlaveIds and
+// Metrics restrict its values to those of the devices, slave ids and metrics listed, if any, so that
+// variables can be chained.
+type queryVariable struct {
+	Kind     database.VariableKind `json:"kind"`
+	Devices  []int64               `json:"devices"`
+	SlaveIds []int64               `json:"slaveIds"`
+	Metrics  []int64               `json:"metrics"`
+	// Regex is a MySQL regular expression the texts of the values must match, if set.
+	Regex string `json:"regex"`
+}
+
+// queryAssets is the view of the plant hierarchy returned by the Assets entity: a table of the
+// nodes in tree order ("tree", the default) or the frames of the node graph panel ("nodeGraph").
+// Root, if set, is the asset whose subtree is returned.
+type queryAssets struct {
+	View string `json:"view"`
+	Root int64  `json:"root"`
+}
+
+// legacyQueryModel is the query model before versions, whose options are all strings.
+type legacyQueryModel struct {
 	Entity        string            `json:"entity"`
 	Parameters    map[string]string `json:"parameters"`
 	WithStreaming bool              `json:"withStreaming"`
+	// AdhocFilters were added along the parameters, which only hold strings.
+	AdhocFilters []database.AdhocFilter `json:"adhocFilters"`
 }
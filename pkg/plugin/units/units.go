@@ -0,0 +1,92 @@
+package units
+
+import "errors"
+
+// Linear is the affine map x -> Scale*x + Offset, which scaling factors, offsets and conversions
+// between engineering units all are.
+type Linear struct {
+	Scale  float64
+	Offset float64
+}
+
+// Identity leaves values untouched.
+var Identity = Linear{Scale: 1}
+
+// Apply returns the image of x.
+func (l Linear) Apply(x float64) float64 {
+	return l.Scale*x + l.Offset
+}
+
+// Then returns the map applying l, then next.
+func (l Linear) Then(next Linear) Linear {
+	return Linear{
+		Scale:  next.Scale * l.Scale,
+		Offset: next.Scale*l.Offset + next.Offset,
+	}
+}
+
+// Inverse returns the map undoing l, or an error if l is not invertible.
+func (l Linear) Inverse() (Linear, error) {
+	if l.Scale == 0 {
+		return Linear{}, errors.New("a scale of 0 cannot be inverted")
+	}
+	return Linear{Scale: 1 / l.Scale, Offset: -l.Offset / l.Scale}, nil
+}
+
+// unit is a unit of a quantity, converted to the base unit of the quantity by toBase.
+type unit struct {
+	quantity string
+	toBase   Linear
+}
+
+// known holds the supported units, by symbol and by Grafana unit id. Temperatures are based on
+// kelvins, power on watts and pressure on pascals.
+var known = map[string]unit{
+	"K":            {"temperature", Identity},
+	"kelvin":       {"temperature", Identity},
+	"°C":           {"temperature", Linear{Scale: 1, Offset: 273.15}},
+	"celsius":      {"temperature", Linear{Scale: 1, Offset: 273.15}},
+	"°F":           {"temperature", Linear{Scale: 5.0 / 9, Offset: 273.15 - 32*5.0/9}},
+	"fahrenheit":   {"temperature", Linear{Scale: 5.0 / 9, Offset: 273.15 - 32*5.0/9}},
+	"mW":           {"power", Linear{Scale: 1e-3}},
+	"mwatt":        {"power", Linear{Scale: 1e-3}},
+	"W":            {"power", Identity},
+	"watt":         {"power", Identity},
+	"kW":           {"power", Linear{Scale: 1e3}},
+	"kwatt":        {"power", Linear{Scale: 1e3}},
+	"MW":           {"power", Linear{Scale: 1e6}},
+	"megwatt":      {"power", Linear{Scale: 1e6}},
+	"Pa":           {"pressure", Identity},
+	"hPa":          {"pressure", Linear{Scale: 1e2}},
+	"pressurehpa":  {"pressure", Linear{Scale: 1e2}},
+	"kPa":          {"pressure", Linear{Scale: 1e3}},
+	"pressurekpa":  {"pressure", Linear{Scale: 1e3}},
+	"mbar":         {"pressure", Linear{Scale: 1e2}},
+	"pressurembar": {"pressure", Linear{Scale: 1e2}},
+	"bar":          {"pressure", Linear{Scale: 1e5}},
+	"pressurebar":  {"pressure", Linear{Scale: 1e5}},
+}
+
+// Conversion returns the map converting values in unit from to unit to. Both must be units of the
+// same quantity, unless they are equal.
+func Conversion(from string, to string) (Linear, error) {
+	if from == to {
+		return Identity, nil
+	}
+
+	fromUnit, ok := known[from]
+	if !ok {
+		return Linear{}, errors.New("unknown unit '" + from + "'")
+	}
+	toUnit, ok := known[to]
+	if !ok {
+		return Linear{}, errors.New("unknown unit '" + to + "'")
+	}
+	if fromUnit.quantity != toUnit.quantity {
+		return Linear{}, errors.New("cannot convert " + fromUnit.quantity + " in '" + from + "' to " +
+			toUnit.quantity + " in '" + to + "'")
+	}
+
+	fromBase, _ := toUnit.toBase.Inverse()
+	return fromUnit.toBase.Then(fromBase), nil
+}
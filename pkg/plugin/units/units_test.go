@@ -0,0 +1,71 @@
+package units_test
+
+import (
+	"github.com/grafana/grafana-starter-datasource-backend/pkg/plugin/units"
+	"math"
+	"testing"
+)
+
+func TestConversion(t *testing.T) {
+
+	type TestCase struct {
+		from          string
+		to            string
+		value         float64
+		expectedValue float64
+	}
+
+	cases := []TestCase{
+		{from: "°C", to: "°F", value: 100, expectedValue: 212},
+		{from: "fahrenheit", to: "celsius", value: -40, expectedValue: -40},
+		{from: "°C", to: "K", value: 0, expectedValue: 273.15},
+		{from: "W", to: "kW", value: 1500, expectedValue: 1.5},
+		{from: "MW", to: "mW", value: 1, expectedValue: 1e9},
+		{from: "Pa", to: "bar", value: 250000, expectedValue: 2.5},
+		{from: "mbar", to: "hPa", value: 1013, expectedValue: 1013},
+		{from: "rpm", to: "rpm", value: 42, expectedValue: 42},
+	}
+
+	for _, tc := range cases {
+		conversion, err := units.Conversion(tc.from, tc.to)
+		if err != nil {
+			t.Errorf("Unexpected error converting %s to %s: %v", tc.from, tc.to, err)
+			continue
+		}
+		if val := conversion.Apply(tc.value); math.Abs(val-tc.expectedValue) > 1e-9 {
+			t.Errorf("Value mismatch converting %g %s to %s: Expected %f, got %f", tc.value, tc.from, tc.to, tc.expectedValue, val)
+		}
+	}
+
+	for _, pair := range [][2]string{{"°C", "bar"}, {"rpm", "kW"}, {"W", "furlong"}} {
+		if _, err := units.Conversion(pair[0], pair[1]); err == nil {
+			t.Errorf("Expected an error converting %s to %s", pair[0], pair[1])
+		}
+	}
+}
+
+func TestLinear(t *testing.T) {
+	// a raw register holding tenths of degrees, shown in fahrenheit.
+	scaling := units.Linear{Scale: 0.1}
+	conversion, err := units.Conversion("°C", "°F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := scaling.Then(conversion)
+
+	if val := l.Apply(1000); math.Abs(val-212) > 1e-9 {
+		t.Errorf("Value mismatch: Expected 212, got %f", val)
+	}
+
+	inverse, err := l.Inverse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val := inverse.Apply(212); math.Abs(val-1000) > 1e-9 {
+		t.Errorf("Value mismatch for the inverse: Expected 1000, got %f", val)
+	}
+
+	if _, err := (units.Linear{Offset: 1}).Inverse(); err == nil {
+		t.Errorf("Expected an error inverting a scale of 0")
+	}
+}
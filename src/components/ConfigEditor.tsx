@@ -3,7 +3,7 @@ import { LegacyForms } from '@grafana/ui';
 import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
 import { MyDataSourceOptions, MySecureJsonData } from '../types';
 
-const { SecretFormField, FormField } = LegacyForms;
+const { SecretFormField, FormField, Switch } = LegacyForms;
 
 interface Props extends DataSourcePluginOptionsEditorProps<MyDataSourceOptions> {}
 
@@ -15,6 +15,12 @@ interface CfgFormFieldProps {
   value: any
 }
 
+interface CfgSwitchProps {
+  label: string,
+  field: keyof MyDataSourceOptions,
+  tooltip: string
+}
+
 export class ConfigEditor extends PureComponent<Props, State> {
 
   onFieldChange = (event: ChangeEvent<HTMLInputElement>, field: string, isSecret?: boolean) => {
@@ -43,6 +49,22 @@ export class ConfigEditor extends PureComponent<Props, State> {
     });
   };
 
+  CfgSwitch = (props: CfgSwitchProps) => {
+    const { onOptionsChange, options } = this.props;
+    return (
+        <Switch
+            label={props.label}
+            labelClass="width-6"
+            tooltip={props.tooltip}
+            checked={Boolean(options.jsonData[props.field])}
+            onChange={e => onOptionsChange({
+              ...options,
+              jsonData: { ...options.jsonData, [props.field]: e.currentTarget.checked },
+            })}
+        />
+    );
+  }
+
   CfgFormField = (props: CfgFormFieldProps) => (
       <div className="gf-form">
         <FormField
@@ -80,6 +102,10 @@ export class ConfigEditor extends PureComponent<Props, State> {
           </div>
         </div>
         <this.CfgFormField label="Database" field="database" value={jsonData.database}/>
+        <this.CfgFormField label="Row limit" field="rowLimit" value={jsonData.rowLimit}/>
+        <this.CfgSwitch label="Writes" field="allowWrites" tooltip="Let editors write the registers of devices"/>
+        <this.CfgSwitch label="Raw SQL" field="allowRawSQL"
+                        tooltip="Let anyone who can query the datasource run SELECT statements on its database. Whatever its user is granted can be read, so it should only be granted SELECT on the exprom tables, and not the FILE privilege"/>
       </div>
     );
   }
@@ -2,19 +2,44 @@ import React, {useState, useEffect} from 'react';
 
 import {Select} from '@grafana/ui';
 import {SelectableValue} from "@grafana/data";
-import {MyVariableQuery} from "../types";
+import {MyVariableQuery, VariableKind} from "../types";
 
 interface VariableQueryProps {
     query: MyVariableQuery;
     onChange: (query: MyVariableQuery, definition: string) => void;
 }
 
+const kinds: Array<SelectableValue<VariableKind>> = [
+    {label: "Devices", value: "devices"},
+    {label: "Metrics", value: "metrics"},
+    {label: "Metrics with data in range", value: "metricsWithData"},
+    {label: "Units", value: "units"},
+    {label: "Data formats", value: "dataFormats"},
+    {label: "Slave ids", value: "slaveIds"},
+];
+
+// Filters chain the variables: device -> slave -> metric.
+const filters: Array<{key: "devices" | "slaveIds" | "metrics", label: string, kinds: VariableKind[]}> = [
+    {key: "devices", label: "From Device(s)", kinds: ["metrics", "metricsWithData", "units", "dataFormats", "slaveIds"]},
+    {key: "slaveIds", label: "From Slave(s)", kinds: ["devices", "metrics", "metricsWithData", "units", "dataFormats"]},
+    {key: "metrics", label: "From Metric(s)", kinds: ["devices", "units", "dataFormats", "slaveIds"]},
+];
+
 export default function VariableQueryEditor ({ onChange, query }: VariableQueryProps) {
-    const [state, setState] = useState(query);
-    const entities = ["Devices", "Metrics"];
+    const [state, setState] = useState<MyVariableQuery>({
+        ...query,
+        kind: query.kind ?? (query.entity?.toLowerCase() as VariableKind | undefined) ?? "devices",
+        entity: undefined,
+    });
 
     const saveQuery = () => {
-        onChange(state, `${state.entity} (${state.devices ?? ''})`);
+        const restrictions = filters
+            .filter(f => state[f.key])
+            .map(f => `${f.key}: ${state[f.key]}`);
+        if(state.regex) {
+            restrictions.push(`regex: ${state.regex}`);
+        }
+        onChange(state, `${state.kind} (${restrictions.join(", ")})`);
     };
 
     useEffect(() => {
@@ -22,39 +47,36 @@ export default function VariableQueryEditor ({ onChange, query }: VariableQueryP
         // eslint-disable-next-line react-hooks/exhaustive-deps
     }, [state])
 
-    const toSelectableValue = (entity: string): SelectableValue<string> => {
-        return {
-            label: entity,
-            value: entity
-        }
-    };
+    const createInput = (label: string, value: string | undefined, set: (v: string) => void) => (
+        <div className="gf-form" key={label}>
+            <span className="gf-form-label width-10">{label}</span>
+            <input
+                className="gf-form-input"
+                onBlur={saveQuery}
+                onChange={e => set(e.target.value)}
+                value={value ?? ""}
+            />
+        </div>
+    )
 
     return (
         <>
             <div className="gf-form">
                 <span className="gf-form-label width-10">Select Entity</span>
                 <Select
-                    options={entities.map(toSelectableValue)}
-                    value={state.entity}
-                    onChange={e => setState({...state, entity: e.value ?? entities[0]})}
+                    options={kinds}
+                    value={state.kind}
+                    onChange={e => setState({...state, kind: e.value ?? "devices"})}
                     allowCustomValue={false}
                     closeMenuOnSelect={true}
                     isClearable={false}
                     isMulti={false}
                 />
-                { state.entity === "Metrics" && (
-                    <>
-                        <span className="gf-form-label width-10">From Device(s)</span>
-                        <input
-                        name="rawQuery"
-                        className="gf-form-input"
-                        onBlur={saveQuery}
-                        onChange={e => setState({...state, devices: e.target.value})}
-                        value={state.devices ?? ""}
-                        />
-                    </>
-                )}
             </div>
+            {filters
+                .filter(f => f.kinds.includes(state.kind!))
+                .map(f => createInput(f.label, state[f.key], v => setState({...state, [f.key]: v})))}
+            {createInput("Regex", state.regex, v => setState({...state, regex: v}))}
         </>
     );
 };
@@ -3,10 +3,11 @@ import {
     DataQueryResponse,
     DataSourceInstanceSettings,
     MetricFindValue,
-    ScopedVars
+    ScopedVars,
+    TimeRange
 } from '@grafana/data';
 import {BackendDataSourceResponse, DataSourceWithBackend, getBackendSrv, getTemplateSrv} from '@grafana/runtime';
-import {MyDataSourceOptions, MyQuery, MyVariableQuery} from './types';
+import {AdhocFilter, MyDataSourceOptions, MyQuery, MyVariableQuery} from './types';
 import {Observable} from "rxjs";
 
 export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptions> {
@@ -26,33 +27,47 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
     }
 
     async metricFindQuery(query: MyVariableQuery, options?: any): Promise<MetricFindValue[]> {
-        const q: Partial<MyQuery> = {
-            entity: query.entity,
+        const parameters: {[key: string]: string} = {
+            kind: query.kind ?? query.entity?.toLowerCase() ?? "devices",
         }
-
-        if(query.devices) {
-            q.parameters = {
-                ...q.parameters,
-                devices: getTemplateSrv().replace(query.devices, undefined, 'csv')
+        for (const key of ["devices", "slaveIds", "metrics", "regex"] as const) {
+            const value = query[key];
+            if(value) {
+                parameters[key] = getTemplateSrv().replace(value, undefined, key === "regex" ? 'regex' : 'csv')
             }
         }
 
+        const q: Partial<MyQuery> = {
+            entity: "VariableQuery",
+            parameters: parameters,
+        }
+
         console.log("metricFindQuery", q)
 
-        const response = await this._internalQuery(q);
+        const response = await this._internalQuery(q, options?.range);
         const results = this._formatResults(response);
 
         return results.map(r => ({
-                text: r.name,
-                value: r.id
+                text: r.text,
+                value: r.value
             })
         )
     }
 
+    async getTagKeys(): Promise<MetricFindValue[]> {
+        return this.getResource('tag-keys');
+    }
+
+    async getTagValues(options: {key: string}): Promise<MetricFindValue[]> {
+        return this.getResource('tag-values', {key: options.key});
+    }
+
     query(request: DataQueryRequest<MyQuery>): Observable<DataQueryResponse> {
         console.log("query", request);
 
+        const adhocFilters: AdhocFilter[] = (getTemplateSrv() as any).getAdhocFilters?.(this.name) ?? [];
         for (const target of request.targets) {
+            target.adhocFilters = adhocFilters;
             if(target.parameters.metrics) {
                 target.parameters.metrics = getTemplateSrv()
                     .replace(target.parameters.metrics, undefined, 'csv')
@@ -66,14 +81,14 @@ export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptio
         return super.query(request);
     }
 
-    async _internalQuery(pq: Partial<MyQuery>): Promise<BackendDataSourceResponse> {
+    async _internalQuery(pq: Partial<MyQuery>, range?: TimeRange): Promise<BackendDataSourceResponse> {
         const q = this._buildQuery(pq);
         const result = await getBackendSrv().datasourceRequest<BackendDataSourceResponse>({
             url: 'api/ds/query',
             method: 'POST',
             data: {
-                from: '5m',
-                to: 'now',
+                from: range ? range.from.valueOf().toString() : 'now-5m',
+                to: range ? range.to.valueOf().toString() : 'now',
                 queries: [{
                     ...q,
                     datasource: this.name,
@@ -1,9 +1,16 @@
 import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
+export interface AdhocFilter {
+  key: string
+  operator: string
+  value: string
+}
+
 export interface MyQuery extends DataQuery {
   entity: string
   parameters: {[key: string]: string}
   withStreaming: boolean;
+  adhocFilters?: AdhocFilter[]
 }
 
 export const defaultQuery: Partial<MyQuery> = {
@@ -22,6 +29,12 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
   hostname: string;
   user: string;
   database: string;
+  rowLimit?: string;
+  // allowWrites lets editors write the registers of devices.
+  allowWrites?: boolean;
+  // allowRawSQL lets anyone who can query the datasource run SELECT statements on its database,
+  // reading whatever the grants of its user allow.
+  allowRawSQL?: boolean;
 }
 
 /**
@@ -31,7 +44,17 @@ export interface MySecureJsonData {
   password: string;
 }
 
+export type VariableKind = "devices" | "metrics" | "units" | "dataFormats" | "slaveIds" | "metricsWithData";
+
+/**
+ * devices, slaveIds and metrics are comma separated ids, usually other variables, restricting the values.
+ * entity is the kind of the variables saved before kinds, either Devices or Metrics.
+ */
 export interface MyVariableQuery {
-  entity: string
+  kind?: VariableKind
+  entity?: string
   devices?: string
+  slaveIds?: string
+  metrics?: string
+  regex?: string
 }